@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// deadLetterQueue almacena de forma durable los lotes que no se pudieron insertar en BigQuery
+type deadLetterQueue interface {
+	// Spool guarda un lote de filas fallidas
+	Spool(ctx context.Context, rows []*transcriptRow) error
+	// List devuelve los nombres de los lotes pendientes, del más antiguo al más reciente
+	List(ctx context.Context) ([]string, error)
+	// Read lee las filas de un lote
+	Read(ctx context.Context, name string) ([]*transcriptRow, error)
+	// Remove elimina un lote ya reprocesado
+	Remove(ctx context.Context, name string) error
+}
+
+// newDeadLetterQueue crea la cola de mensajes fallidos según la configuración.
+// Si hay un bucket configurado se usa Cloud Storage; si no, el directorio local configurado.
+func newDeadLetterQueue(ctx context.Context) (deadLetterQueue, error) {
+	if deadLetterBucket != "" {
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error al crear el cliente de Cloud Storage: %v", err)
+		}
+		return &gcsDeadLetterQueue{bucket: client.Bucket(deadLetterBucket), prefix: deadLetterPrefix}, nil
+	}
+
+	if deadLetterDir == "" {
+		return nil, fmt.Errorf("no hay un bucket ni un directorio configurado para la cola de mensajes fallidos")
+	}
+	if err := os.MkdirAll(deadLetterDir, 0o755); err != nil {
+		return nil, fmt.Errorf("error al crear el directorio de mensajes fallidos: %v", err)
+	}
+	return &localDeadLetterQueue{dir: deadLetterDir}, nil
+}
+
+// deadLetterBatchName genera un nombre de lote ordenable cronológicamente
+func deadLetterBatchName() string {
+	return fmt.Sprintf("batch-%020d.json", time.Now().UnixNano())
+}
+
+// localDeadLetterQueue guarda los lotes como archivos JSON en un directorio local
+type localDeadLetterQueue struct {
+	dir string
+}
+
+// Spool escribe el lote en un archivo temporal y lo renombra para que la escritura sea atómica
+func (q *localDeadLetterQueue) Spool(ctx context.Context, rows []*transcriptRow) error {
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return fmt.Errorf("error al serializar el lote: %v", err)
+	}
+
+	name := deadLetterBatchName()
+	tmpPath := filepath.Join(q.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("error al escribir el lote %s: %v", name, err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(q.dir, name)); err != nil {
+		return fmt.Errorf("error al confirmar el lote %s: %v", name, err)
+	}
+	return nil
+}
+
+// List devuelve los lotes del directorio ordenados por nombre
+func (q *localDeadLetterQueue) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error al listar el directorio de mensajes fallidos: %v", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "batch-") {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Read lee las filas de un archivo de lote
+func (q *localDeadLetterQueue) Read(ctx context.Context, name string) ([]*transcriptRow, error) {
+	data, err := os.ReadFile(filepath.Join(q.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("error al leer el lote %s: %v", name, err)
+	}
+
+	var rows []*transcriptRow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("error al parsear el lote %s: %v", name, err)
+	}
+	return rows, nil
+}
+
+// Remove elimina el archivo de un lote
+func (q *localDeadLetterQueue) Remove(ctx context.Context, name string) error {
+	if err := os.Remove(filepath.Join(q.dir, name)); err != nil {
+		return fmt.Errorf("error al eliminar el lote %s: %v", name, err)
+	}
+	return nil
+}
+
+// gcsDeadLetterQueue guarda los lotes como objetos JSON en un bucket de Cloud Storage
+type gcsDeadLetterQueue struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// Spool sube el lote como un nuevo objeto. La precondición DoesNotExist evita sobrescribir otro lote.
+func (q *gcsDeadLetterQueue) Spool(ctx context.Context, rows []*transcriptRow) error {
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return fmt.Errorf("error al serializar el lote: %v", err)
+	}
+
+	name := deadLetterBatchName()
+	obj := q.bucket.Object(q.prefix + name).If(storage.Conditions{DoesNotExist: true})
+	w := obj.NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("error al subir el lote %s: %v", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error al confirmar el lote %s: %v", name, err)
+	}
+	return nil
+}
+
+// List devuelve los lotes del bucket bajo el prefijo configurado
+func (q *gcsDeadLetterQueue) List(ctx context.Context) ([]string, error) {
+	var names []string
+	it := q.bucket.Objects(ctx, &storage.Query{Prefix: q.prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error al listar los lotes en Cloud Storage: %v", err)
+		}
+		name := strings.TrimPrefix(attrs.Name, q.prefix)
+		if strings.HasPrefix(name, "batch-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Read descarga y parsea un lote del bucket
+func (q *gcsDeadLetterQueue) Read(ctx context.Context, name string) ([]*transcriptRow, error) {
+	r, err := q.bucket.Object(q.prefix + name).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error al abrir el lote %s: %v", name, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error al leer el lote %s: %v", name, err)
+	}
+
+	var rows []*transcriptRow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("error al parsear el lote %s: %v", name, err)
+	}
+	return rows, nil
+}
+
+// Remove elimina un lote del bucket
+func (q *gcsDeadLetterQueue) Remove(ctx context.Context, name string) error {
+	if err := q.bucket.Object(q.prefix + name).Delete(ctx); err != nil {
+		return fmt.Errorf("error al eliminar el lote %s: %v", name, err)
+	}
+	return nil
+}
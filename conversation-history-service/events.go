@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"kairosia/internal/events"
+)
+
+// HandleConversationEvent consume los eventos del ciclo de vida de la conversación
+// entregados por una suscripción push de Pub/Sub (o por el bus local con el mismo formato).
+// Una respuesta distinta de 2xx hace que el mensaje se reintente.
+func HandleConversationEvent(w http.ResponseWriter, r *http.Request) {
+	// Verificar que el método sea POST
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	event, err := events.DecodePushRequest(r)
+	if err != nil {
+		// Un mensaje mal formado no se arreglará reintentando: se confirma y se registra
+		log.Printf("Error al decodificar el evento: %v", err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ctx := r.Context()
+	switch event.Type {
+	case events.TurnCompleted:
+		var data events.TurnCompletedData
+		if err := event.DecodeData(&data); err != nil {
+			log.Printf("Error al decodificar el evento %s: %v", event.ID, err)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if data.Transcript == nil {
+			log.Printf("El evento %s no incluye la transcripción", event.ID)
+			break
+		}
+		if err := saveTranscriptToBigQuery(ctx, event.ID, data.Transcript); err != nil {
+			log.Printf("Error al guardar la transcripción del evento %s: %v", event.ID, err)
+			http.Error(w, "Error al guardar la transcripción", http.StatusInternalServerError)
+			return
+		}
+		if err := updateVectorIndex(ctx, data.Transcript); err != nil {
+			log.Printf("Error al actualizar el índice vectorial: %v", err)
+		}
+
+	case events.CallEnded:
+		var data events.CallEndedData
+		if err := event.DecodeData(&data); err != nil {
+			log.Printf("Error al decodificar el evento %s: %v", event.ID, err)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if data.Transcript == nil {
+			log.Printf("El evento %s no incluye la transcripción", event.ID)
+			break
+		}
+		if err := saveTranscriptToBigQuery(ctx, event.ID, data.Transcript); err != nil {
+			log.Printf("Error al guardar la transcripción final del evento %s: %v", event.ID, err)
+			http.Error(w, "Error al guardar la transcripción", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Llamada %s finalizada (%s, %d segundos)", event.CallSid, data.CallStatus, data.DurationSeconds)
+
+	case events.CallStarted, events.HandoffRequested:
+		// Por ahora solo se registran; la transcripción llega con los turnos
+		log.Printf("Evento %s recibido para la llamada %s", event.Type, event.CallSid)
+
+	default:
+		log.Printf("Tipo de evento desconocido %s (%s)", event.Type, event.ID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -8,11 +8,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
-	"cloud.google.com/go/bigquery"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
-	"google.golang.org/api/option"
 
+	"kairosia/internal/auth"
 	"kairosia/internal/models"
 	"kairosia/internal/utils"
 )
@@ -24,6 +24,15 @@ var (
 	bigqueryTable             string
 	vertexAIEmbeddingModel    string
 	vertexAIVectorSearchIndex string
+	bigqueryBatchSize         int
+	bigqueryFlushInterval     time.Duration
+	bigqueryMaxRetries        int
+	bigqueryRetryBaseDelay    time.Duration
+	bigqueryRetryMaxDelay     time.Duration
+	deadLetterDir             string
+	deadLetterBucket          string
+	deadLetterPrefix          string
+	serviceAuthConfig         auth.Config
 )
 
 func init() {
@@ -34,12 +43,42 @@ func init() {
 	bigqueryTable = utils.GetEnv("BIGQUERY_TABLE", "conversation_transcripts")
 	vertexAIEmbeddingModel = utils.GetEnv("VERTEX_AI_EMBEDDING_MODEL", "textembedding-gecko")
 	vertexAIVectorSearchIndex = utils.GetEnv("VERTEX_AI_VECTOR_SEARCH_INDEX", "")
+	bigqueryBatchSize = utils.Atoi(utils.GetEnv("BIGQUERY_BATCH_SIZE", "50"), 50)
+	bigqueryFlushInterval = time.Duration(utils.Atoi(utils.GetEnv("BIGQUERY_FLUSH_INTERVAL_MS", "500"), 500)) * time.Millisecond
+	bigqueryMaxRetries = utils.Atoi(utils.GetEnv("BIGQUERY_MAX_RETRIES", "5"), 5)
+	bigqueryRetryBaseDelay = time.Duration(utils.Atoi(utils.GetEnv("BIGQUERY_RETRY_BASE_DELAY_MS", "200"), 200)) * time.Millisecond
+	bigqueryRetryMaxDelay = time.Duration(utils.Atoi(utils.GetEnv("BIGQUERY_RETRY_MAX_DELAY_MS", "10000"), 10000)) * time.Millisecond
+	deadLetterDir = utils.GetEnv("DEAD_LETTER_DIR", "")
+	deadLetterBucket = utils.GetEnv("DEAD_LETTER_BUCKET", "")
+	deadLetterPrefix = utils.GetEnv("DEAD_LETTER_PREFIX", "dead-letter/")
+	serviceAuthConfig = auth.Config{
+		Mode:                   utils.GetEnv("SERVICE_AUTH_MODE", auth.ModeIDToken),
+		Audience:               utils.GetEnv("SERVICE_AUTH_AUDIENCE", ""),
+		AllowedServiceAccounts: utils.ParseList(utils.GetEnv("SERVICE_AUTH_ALLOWED_SERVICE_ACCOUNTS", "")),
+		HMACSecret:             utils.GetEnv("SERVICE_AUTH_HMAC_SECRET", ""),
+		HMACTolerance:          time.Duration(utils.Atoi(utils.GetEnv("SERVICE_AUTH_HMAC_TOLERANCE_SECONDS", "300"), 300)) * time.Second,
+		LocalKeyFile:           utils.GetEnv("SERVICE_AUTH_LOCAL_KEY_FILE", "/tmp/kairosia-local-issuer.pem"),
+	}
+
+	// La cola de mensajes fallidos debe ser durable: un directorio local solo si se configura explícitamente
+	if deadLetterBucket == "" && deadLetterDir == "" {
+		log.Fatalf("Se requiere DEAD_LETTER_BUCKET (o DEAD_LETTER_DIR en desarrollo) para la cola de mensajes fallidos")
+	}
+
+	// Crear el verificador de autenticación entre servicios
+	verifier, err := auth.NewVerifier(serviceAuthConfig)
+	if err != nil {
+		log.Fatalf("Error al configurar la autenticación entre servicios: %v", err)
+	}
 
-	// Registrar la función HTTP
-	functions.HTTP("SaveTranscript", SaveTranscript)
+	// Registrar las funciones HTTP. Solo los servicios autorizados pueden invocarlas.
+	functions.HTTP("SaveTranscript", auth.Middleware(verifier, SaveTranscript))
+	functions.HTTP("HandleConversationEvent", auth.Middleware(verifier, HandleConversationEvent))
 }
 
-// SaveTranscript guarda la transcripción de una conversación en BigQuery
+// SaveTranscript guarda la transcripción de una conversación en BigQuery.
+// El servicio de voz publica eventos consumidos por HandleConversationEvent; este endpoint
+// se mantiene para la ingesta directa de transcripciones.
 func SaveTranscript(w http.ResponseWriter, r *http.Request) {
 	// Verificar que el método sea POST
 	if r.Method != http.MethodPost {
@@ -66,8 +105,15 @@ func SaveTranscript(w http.ResponseWriter, r *http.Request) {
 	// Inicializar el contexto
 	ctx := context.Background()
 
-	// Guardar la transcripción en BigQuery
-	if err := saveTranscriptToBigQuery(ctx, &payload); err != nil {
+	// El servicio de voz envía una clave de idempotencia por evento; se usa como ID de inserción
+	insertID := r.Header.Get("Idempotency-Key")
+	if insertID == "" {
+		insertID = transcriptInsertID(&payload)
+	}
+
+	// Guardar la transcripción en BigQuery. La escritura se agrupa con otras solicitudes
+	// y solo falla si el lote no pudo insertarse ni guardarse en la cola de mensajes fallidos.
+	if err := saveTranscriptToBigQuery(ctx, insertID, &payload); err != nil {
 		log.Printf("Error al guardar la transcripción en BigQuery: %v", err)
 		http.Error(w, fmt.Sprintf("Error al guardar la transcripción en BigQuery: %v", err), http.StatusInternalServerError)
 		return
@@ -84,25 +130,14 @@ func SaveTranscript(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"success"}`))
 }
 
-// saveTranscriptToBigQuery guarda la transcripción en BigQuery
-func saveTranscriptToBigQuery(ctx context.Context, payload *models.FullTranscriptPayload) error {
-	// Inicializar el cliente de BigQuery
-	client, err := bigquery.NewClient(ctx, projectID, option.WithEndpoint(fmt.Sprintf("https://bigquery.googleapis.com")))
+// saveTranscriptToBigQuery guarda la transcripción en BigQuery a través del escritor por lotes
+func saveTranscriptToBigQuery(ctx context.Context, insertID string, payload *models.FullTranscriptPayload) error {
+	w, err := getTranscriptWriter(ctx)
 	if err != nil {
-		return fmt.Errorf("error al crear el cliente de BigQuery: %v", err)
+		return err
 	}
-	defer client.Close()
 
-	// Obtener la referencia a la tabla
-	table := client.Dataset(bigqueryDataset).Table(bigqueryTable)
-
-	// Insertar el registro
-	inserter := table.Inserter()
-	if err := inserter.Put(ctx, payload); err != nil {
-		return fmt.Errorf("error al insertar el registro en BigQuery: %v", err)
-	}
-
-	return nil
+	return w.Write(ctx, insertID, payload)
 }
 
 // updateVectorIndex actualiza el índice vectorial con los embeddings de la conversación
@@ -232,6 +267,14 @@ func getAllTexts(entries []models.TranscriptEntry) []string {
 }
 
 func main() {
+	// Subcomando para reprocesar los lotes de la cola de mensajes fallidos
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := replayDeadLetters(context.Background()); err != nil {
+			log.Fatalf("Error al reprocesar la cola de mensajes fallidos: %v", err)
+		}
+		return
+	}
+
 	// Obtener el puerto del entorno o usar 8080 por defecto
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+
+	"kairosia/internal/models"
+	"kairosia/internal/utils"
+)
+
+// transcriptRow representa una fila pendiente de insertar en BigQuery junto con su ID de inserción
+type transcriptRow struct {
+	InsertID string                        `json:"insert_id"`
+	Payload  *models.FullTranscriptPayload `json:"payload"`
+}
+
+// writeRequest representa una solicitud de escritura a la espera del resultado de su lote
+type writeRequest struct {
+	row  *transcriptRow
+	done chan error
+}
+
+// pendingBatch es un lote en curso: las solicitudes que esperan su resultado y las filas que aún no se insertan
+type pendingBatch struct {
+	requests []*writeRequest
+	rows     []*transcriptRow
+	attempt  int
+}
+
+// transcriptWriter agrupa las filas en lotes, las inserta en BigQuery con reintentos
+// y envía a la cola de mensajes fallidos los lotes que no se pudieron insertar
+type transcriptWriter struct {
+	inserter       *bigquery.Inserter
+	deadLetters    deadLetterQueue
+	batchSize      int
+	flushInterval  time.Duration
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	requests       chan *writeRequest
+	retries        chan *pendingBatch
+}
+
+var (
+	writerOnce    sync.Once
+	writer        *transcriptWriter
+	writerInitErr error
+)
+
+// getTranscriptWriter obtiene el escritor de transcripciones, creándolo la primera vez
+func getTranscriptWriter(ctx context.Context) (*transcriptWriter, error) {
+	writerOnce.Do(func() {
+		writer, writerInitErr = newTranscriptWriter(ctx)
+		if writerInitErr == nil {
+			go writer.run()
+		}
+	})
+	return writer, writerInitErr
+}
+
+// newTranscriptWriter crea un escritor de transcripciones a partir de la configuración
+func newTranscriptWriter(ctx context.Context) (*transcriptWriter, error) {
+	// Inicializar el cliente de BigQuery. El cliente vive mientras viva el proceso.
+	client, err := bigquery.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente de BigQuery: %v", err)
+	}
+
+	dlq, err := newDeadLetterQueue(ctx)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &transcriptWriter{
+		inserter:       client.Dataset(bigqueryDataset).Table(bigqueryTable).Inserter(),
+		deadLetters:    dlq,
+		batchSize:      bigqueryBatchSize,
+		flushInterval:  bigqueryFlushInterval,
+		maxRetries:     bigqueryMaxRetries,
+		retryBaseDelay: bigqueryRetryBaseDelay,
+		retryMaxDelay:  bigqueryRetryMaxDelay,
+		requests:       make(chan *writeRequest, bigqueryBatchSize*4),
+		retries:        make(chan *pendingBatch),
+	}, nil
+}
+
+// Write encola una transcripción y espera a que su lote quede persistido,
+// ya sea en BigQuery o en la cola de mensajes fallidos
+func (w *transcriptWriter) Write(ctx context.Context, insertID string, payload *models.FullTranscriptPayload) error {
+	req := &writeRequest{
+		row:  &transcriptRow{InsertID: insertID, Payload: payload},
+		done: make(chan error, 1),
+	}
+
+	select {
+	case w.requests <- req:
+	case <-ctx.Done():
+		return fmt.Errorf("error al encolar la transcripción: %v", ctx.Err())
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("error al esperar la escritura de la transcripción: %v", ctx.Err())
+	}
+}
+
+// run acumula solicitudes y vacía el lote cuando se llena o vence el intervalo. Los reintentos de un
+// lote se programan con un temporizador y vuelven por el canal retries, sin detener los demás lotes.
+func (w *transcriptWriter) run() {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*writeRequest, 0, w.batchSize)
+	for {
+		select {
+		case req := <-w.requests:
+			batch = append(batch, req)
+			if len(batch) < w.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case retry := <-w.retries:
+			w.flush(retry)
+			continue
+		}
+
+		rows := make([]*transcriptRow, len(batch))
+		for i, req := range batch {
+			rows[i] = req.row
+		}
+		w.flush(&pendingBatch{requests: batch, rows: rows})
+		batch = make([]*writeRequest, 0, w.batchSize)
+	}
+}
+
+// flush hace un intento de insertar las filas pendientes de un lote. Si quedan filas con error, programa
+// el próximo intento con backoff exponencial; al agotar los intentos las envía a la cola de mensajes
+// fallidos. Cuando el lote termina notifica el resultado a cada solicitud.
+func (w *transcriptWriter) flush(batch *pendingBatch) {
+	ctx := context.Background()
+
+	failed, err := w.insertOnce(ctx, batch.rows)
+	if err == nil {
+		w.finish(batch, nil)
+		return
+	}
+	log.Printf("Error al insertar en BigQuery (intento %d de %d): %v", batch.attempt+1, w.maxRetries+1, err)
+
+	if batch.attempt < w.maxRetries {
+		batch.rows = failed
+		batch.attempt++
+		time.AfterFunc(utils.BackoffDelay(batch.attempt, w.retryBaseDelay, w.retryMaxDelay), func() {
+			w.retries <- batch
+		})
+		return
+	}
+
+	var result error
+	log.Printf("Error al insertar %d filas en BigQuery, enviándolas a la cola de mensajes fallidos: %v", len(failed), err)
+	if spoolErr := w.deadLetters.Spool(ctx, failed); spoolErr != nil {
+		result = fmt.Errorf("error al guardar el lote en la cola de mensajes fallidos: %v", spoolErr)
+	}
+	w.finish(batch, result)
+}
+
+// finish notifica el resultado de un lote a cada solicitud
+func (w *transcriptWriter) finish(batch *pendingBatch, result error) {
+	for _, req := range batch.requests {
+		req.done <- result
+	}
+}
+
+// insertWithRetry inserta las filas con reintentos y backoff exponencial, esperando entre intentos. Se usa al
+// reprocesar la cola de mensajes fallidos; devuelve las filas que seguían fallando al agotar los intentos.
+func (w *transcriptWriter) insertWithRetry(ctx context.Context, rows []*transcriptRow) ([]*transcriptRow, error) {
+	pending := rows
+	var err error
+	for attempt := 0; attempt <= w.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(utils.BackoffDelay(attempt, w.retryBaseDelay, w.retryMaxDelay))
+		}
+
+		if pending, err = w.insertOnce(ctx, pending); err == nil {
+			return nil, nil
+		}
+		log.Printf("Error al insertar en BigQuery (intento %d de %d): %v", attempt+1, w.maxRetries+1, err)
+	}
+	return pending, err
+}
+
+// insertOnce inserta las filas una vez. Si BigQuery informa errores por fila, devuelve solo esas filas para
+// reintentarlas; si el error es de toda la solicitud, devuelve todas.
+func (w *transcriptWriter) insertOnce(ctx context.Context, rows []*transcriptRow) ([]*transcriptRow, error) {
+	err := w.inserter.Put(ctx, toValueSavers(rows))
+	if err == nil {
+		return nil, nil
+	}
+
+	var multiErr bigquery.PutMultiError
+	if errors.As(err, &multiErr) {
+		failed := make([]*transcriptRow, 0, len(multiErr))
+		for _, rowErr := range multiErr {
+			if rowErr.RowIndex >= 0 && rowErr.RowIndex < len(rows) {
+				failed = append(failed, rows[rowErr.RowIndex])
+			}
+		}
+		if len(failed) > 0 {
+			rows = failed
+		}
+	}
+	return rows, fmt.Errorf("error al insertar el registro en BigQuery: %v", err)
+}
+
+// toValueSavers convierte las filas en savers con ID de inserción para la deduplicación de BigQuery
+func toValueSavers(rows []*transcriptRow) []*bigquery.StructSaver {
+	savers := make([]*bigquery.StructSaver, len(rows))
+	for i, row := range rows {
+		savers[i] = &bigquery.StructSaver{
+			Struct:   row.Payload,
+			InsertID: row.InsertID,
+		}
+	}
+	return savers
+}
+
+// transcriptInsertID genera el ID de inserción de una transcripción.
+// El servicio de voz envía la transcripción acumulada en cada turno, por lo que
+// el par (CallSid, número de entradas) identifica de forma única cada envío.
+func transcriptInsertID(payload *models.FullTranscriptPayload) string {
+	return fmt.Sprintf("%s-%d", payload.CallSid, len(payload.TranscriptEntries))
+}
+
+// replayDeadLetters reinserta en BigQuery los lotes guardados en la cola de mensajes fallidos.
+// Los IDs de inserción originales se conservan, por lo que reintentar un lote ya insertado no duplica filas.
+func replayDeadLetters(ctx context.Context) error {
+	w, err := newTranscriptWriter(ctx)
+	if err != nil {
+		return err
+	}
+
+	names, err := w.deadLetters.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Reprocesando %d lotes de la cola de mensajes fallidos", len(names))
+	var failures int
+	for _, name := range names {
+		rows, err := w.deadLetters.Read(ctx, name)
+		if err != nil {
+			log.Printf("Error al leer el lote %s: %v", name, err)
+			failures++
+			continue
+		}
+
+		if _, err := w.insertWithRetry(ctx, rows); err != nil {
+			log.Printf("Error al reprocesar el lote %s: %v", name, err)
+			failures++
+			continue
+		}
+
+		if err := w.deadLetters.Remove(ctx, name); err != nil {
+			log.Printf("Error al eliminar el lote reprocesado %s: %v", name, err)
+			failures++
+			continue
+		}
+		log.Printf("Lote %s reprocesado (%d filas)", name, len(rows))
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d lotes no pudieron reprocesarse", failures)
+	}
+	return nil
+}
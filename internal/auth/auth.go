@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// ModeNone desactiva la autenticación (solo para desarrollo)
+	ModeNone = "none"
+	// ModeIDToken usa ID tokens firmados por Google
+	ModeIDToken = "idtoken"
+	// ModeHMAC usa una firma HMAC-SHA256 del cuerpo con un secreto compartido
+	ModeHMAC = "hmac"
+	// ModeLocal usa ID tokens firmados por un emisor de pruebas local, verificables sin conexión
+	ModeLocal = "local"
+)
+
+// Config contiene la configuración de autenticación entre servicios
+type Config struct {
+	// Mode es uno de ModeNone, ModeIDToken, ModeHMAC o ModeLocal
+	Mode string
+	// Audience es la audiencia esperada de los tokens. Si está vacía, el verificador usa la URL de la solicitud.
+	Audience string
+	// AllowedServiceAccounts restringe los correos de cuentas de servicio aceptados. Es obligatoria en el modo
+	// idtoken, porque cualquier cuenta de Google puede obtener un token para la audiencia; en el modo local, vacía acepta cualquiera.
+	AllowedServiceAccounts []string
+	// HMACSecret es el secreto compartido del modo HMAC
+	HMACSecret string
+	// HMACTolerance es la diferencia máxima aceptada entre la marca de tiempo de la firma y el reloj local
+	HMACTolerance time.Duration
+	// LocalKeyFile es la ruta de la clave RSA del emisor de pruebas local
+	LocalKeyFile string
+	// LocalSubject es el correo que el emisor local incluye en los tokens
+	LocalSubject string
+}
+
+// Principal identifica al llamador autenticado
+type Principal struct {
+	Email  string
+	Method string
+}
+
+// Verifier verifica la autenticación de una solicitud entrante
+type Verifier interface {
+	Verify(r *http.Request) (*Principal, error)
+}
+
+// NewVerifier crea el verificador correspondiente al modo configurado
+func NewVerifier(cfg Config) (Verifier, error) {
+	switch cfg.Mode {
+	case ModeNone, "":
+		return noneVerifier{}, nil
+	case ModeIDToken:
+		if len(cfg.AllowedServiceAccounts) == 0 {
+			return nil, fmt.Errorf("el modo idtoken requiere una lista de cuentas de servicio permitidas")
+		}
+		return &googleVerifier{cfg: cfg}, nil
+	case ModeHMAC:
+		if cfg.HMACSecret == "" {
+			return nil, fmt.Errorf("el modo hmac requiere un secreto compartido")
+		}
+		return &hmacVerifier{secret: []byte(cfg.HMACSecret), tolerance: cfg.HMACTolerance}, nil
+	case ModeLocal:
+		key, err := LoadOrCreateLocalKey(cfg.LocalKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &localVerifier{cfg: cfg, key: &key.PublicKey}, nil
+	default:
+		return nil, fmt.Errorf("modo de autenticación no soportado: %s", cfg.Mode)
+	}
+}
+
+// NewHTTPClient crea un cliente HTTP que adjunta automáticamente las credenciales del modo configurado
+func NewHTTPClient(ctx context.Context, cfg Config, timeout time.Duration) (*http.Client, error) {
+	switch cfg.Mode {
+	case ModeNone, "":
+		return &http.Client{Timeout: timeout}, nil
+	case ModeIDToken:
+		return newIDTokenClient(ctx, cfg.Audience, timeout)
+	case ModeHMAC:
+		if cfg.HMACSecret == "" {
+			return nil, fmt.Errorf("el modo hmac requiere un secreto compartido")
+		}
+		return &http.Client{
+			Timeout:   timeout,
+			Transport: &hmacTransport{secret: []byte(cfg.HMACSecret), base: http.DefaultTransport},
+		}, nil
+	case ModeLocal:
+		issuer, err := NewLocalIssuer(cfg.LocalKeyFile, cfg.LocalSubject)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{
+			Timeout:   timeout,
+			Transport: &localTransport{issuer: issuer, audience: cfg.Audience, base: http.DefaultTransport},
+		}, nil
+	default:
+		return nil, fmt.Errorf("modo de autenticación no soportado: %s", cfg.Mode)
+	}
+}
+
+// Middleware rechaza con 401 las solicitudes que no superan la verificación
+func Middleware(verifier Verifier, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := verifier.Verify(r)
+		if err != nil {
+			log.Printf("Solicitud no autenticada a %s: %v", r.URL.Path, err)
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
+		if principal != nil && principal.Email != "" {
+			log.Printf("Solicitud a %s autenticada como %s (%s)", r.URL.Path, principal.Email, principal.Method)
+		}
+		next(w, r)
+	}
+}
+
+// bearerToken extrae el token de la cabecera Authorization
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", fmt.Errorf("falta la cabecera Authorization con un token Bearer")
+	}
+	return strings.TrimPrefix(header, "Bearer "), nil
+}
+
+// requestAudience devuelve la audiencia configurada o, si no hay, la URL base de la solicitud
+func requestAudience(cfg Config, r *http.Request) string {
+	if cfg.Audience != "" {
+		return cfg.Audience
+	}
+	// Detrás de Cloud Run la conexión llega sin TLS y el esquema original viene en X-Forwarded-Proto
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.Host
+}
+
+// checkServiceAccount verifica que el correo del token esté en la lista permitida
+func checkServiceAccount(cfg Config, email string) error {
+	if len(cfg.AllowedServiceAccounts) == 0 {
+		return nil
+	}
+	for _, allowed := range cfg.AllowedServiceAccounts {
+		if strings.EqualFold(allowed, email) {
+			return nil
+		}
+	}
+	return fmt.Errorf("la cuenta %q no está autorizada", email)
+}
+
+// noneVerifier acepta todas las solicitudes
+type noneVerifier struct{}
+
+// Verify acepta la solicitud sin comprobaciones
+func (noneVerifier) Verify(r *http.Request) (*Principal, error) {
+	return &Principal{Method: ModeNone}, nil
+}
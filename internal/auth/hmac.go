@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader es la cabecera con la firma HMAC: "t=<unix>,v1=<hex>"
+const SignatureHeader = "X-Kairosia-Signature"
+
+// Sign calcula la firma HMAC-SHA256 de "<timestamp>.<método>.<ruta>.<cuerpo>".
+// El método y la ruta impiden reenviar una solicitud firmada a otro endpoint dentro de la ventana de tolerancia.
+func Sign(secret []byte, timestamp int64, method, path string, body []byte) string {
+	// Una URL sin ruta llega al servidor como "/"
+	if path == "" {
+		path = "/"
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write([]byte(strings.ToUpper(method)))
+	mac.Write([]byte("."))
+	mac.Write([]byte(path))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hmacVerifier valida la firma HMAC del método, la ruta y el cuerpo de la solicitud
+type hmacVerifier struct {
+	secret    []byte
+	tolerance time.Duration
+}
+
+// Verify comprueba la firma y la antigüedad de la marca de tiempo. El cuerpo se restaura para el manejador.
+func (v *hmacVerifier) Verify(r *http.Request) (*Principal, error) {
+	timestamp, signature, err := parseSignatureHeader(r.Header.Get(SignatureHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	tolerance := v.tolerance
+	if tolerance <= 0 {
+		tolerance = 5 * time.Minute
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return nil, fmt.Errorf("la firma está fuera de la ventana de tolerancia")
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error al leer el cuerpo de la solicitud: %v", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	expected := Sign(v.secret, timestamp, r.Method, r.URL.Path, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, fmt.Errorf("firma HMAC inválida")
+	}
+
+	return &Principal{Method: ModeHMAC}, nil
+}
+
+// parseSignatureHeader extrae la marca de tiempo y la firma de la cabecera
+func parseSignatureHeader(header string) (int64, string, error) {
+	if header == "" {
+		return 0, "", fmt.Errorf("falta la cabecera %s", SignatureHeader)
+	}
+
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, "", fmt.Errorf("marca de tiempo inválida en %s", SignatureHeader)
+			}
+			timestamp = t
+		case "v1":
+			signature = value
+		}
+	}
+
+	if timestamp == 0 || signature == "" {
+		return 0, "", fmt.Errorf("cabecera %s incompleta", SignatureHeader)
+	}
+	return timestamp, signature, nil
+}
+
+// hmacTransport firma el método, la ruta y el cuerpo de cada solicitud saliente
+type hmacTransport struct {
+	secret []byte
+	base   http.RoundTripper
+}
+
+// RoundTrip agrega la cabecera de firma a una copia de la solicitud
+func (t *hmacTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error al leer el cuerpo para firmarlo: %v", err)
+		}
+	}
+
+	signed := req.Clone(req.Context())
+	signed.Body = io.NopCloser(bytes.NewReader(body))
+	timestamp := time.Now().Unix()
+	signed.Header.Set(SignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, Sign(t.secret, timestamp, signed.Method, signed.URL.Path, body)))
+
+	return t.base.RoundTrip(signed)
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/idtoken"
+)
+
+// googleVerifier valida ID tokens firmados por Google
+type googleVerifier struct {
+	cfg Config
+}
+
+// Verify valida la firma, la audiencia y la cuenta de servicio del token
+func (v *googleVerifier) Verify(r *http.Request) (*Principal, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := idtoken.Validate(r.Context(), token, requestAudience(v.cfg, r))
+	if err != nil {
+		return nil, fmt.Errorf("ID token inválido: %v", err)
+	}
+
+	email, _ := payload.Claims["email"].(string)
+	if verified, _ := payload.Claims["email_verified"].(bool); !verified {
+		return nil, fmt.Errorf("el correo del ID token no está verificado")
+	}
+	if err := checkServiceAccount(v.cfg, email); err != nil {
+		return nil, err
+	}
+
+	return &Principal{Email: email, Method: ModeIDToken}, nil
+}
+
+// newIDTokenClient crea un cliente que adjunta ID tokens de Google para la audiencia indicada
+func newIDTokenClient(ctx context.Context, audience string, timeout time.Duration) (*http.Client, error) {
+	client, err := idtoken.NewClient(ctx, audience)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente con ID token: %v", err)
+	}
+	client.Timeout = timeout
+	return client, nil
+}
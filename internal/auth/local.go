@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalIssuerName es el emisor (iss) de los tokens del modo local
+const LocalIssuerName = "https://kairosia.local/issuer"
+
+// localTokenTTL es la vigencia de los tokens emitidos localmente
+const localTokenTTL = time.Hour
+
+// localClaims contiene los claims de los tokens del emisor local
+type localClaims struct {
+	Issuer        string `json:"iss"`
+	Audience      string `json:"aud"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	IssuedAt      int64  `json:"iat"`
+	ExpiresAt     int64  `json:"exp"`
+}
+
+// LocalIssuer emite ID tokens RS256 con una clave local, con la misma forma que los de Google.
+// Permite probar el flujo de autenticación sin conexión ni credenciales de GCP.
+type LocalIssuer struct {
+	key     *rsa.PrivateKey
+	subject string
+}
+
+// NewLocalIssuer crea un emisor local usando la clave del archivo indicado, creándola si no existe
+func NewLocalIssuer(keyFile, subject string) (*LocalIssuer, error) {
+	key, err := LoadOrCreateLocalKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalIssuer{key: key, subject: subject}, nil
+}
+
+// Token emite un token firmado para la audiencia indicada
+func (i *LocalIssuer) Token(audience string) (string, error) {
+	now := time.Now()
+	claims := localClaims{
+		Issuer:        LocalIssuerName,
+		Audience:      audience,
+		Subject:       i.subject,
+		Email:         i.subject,
+		EmailVerified: true,
+		IssuedAt:      now.Unix(),
+		ExpiresAt:     now.Add(localTokenTTL).Unix(),
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "local"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("error al firmar el token local: %v", err)
+	}
+
+	return signingInput + "." + encodeSegment(signature), nil
+}
+
+// localVerifier valida los tokens del emisor local
+type localVerifier struct {
+	cfg Config
+	key *rsa.PublicKey
+}
+
+// Verify valida la firma, el emisor, la audiencia, la vigencia y la cuenta del token
+func (v *localVerifier) Verify(r *http.Request) (*Principal, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token local mal formado")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("firma del token local mal codificada")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(v.key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("firma del token local inválida")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("claims del token local mal codificados")
+	}
+	var claims localClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("claims del token local inválidos")
+	}
+
+	if claims.Issuer != LocalIssuerName {
+		return nil, fmt.Errorf("emisor del token local inesperado: %s", claims.Issuer)
+	}
+	if audience := requestAudience(v.cfg, r); claims.Audience != audience {
+		return nil, fmt.Errorf("audiencia del token local inesperada: %s", claims.Audience)
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, fmt.Errorf("el token local expiró")
+	}
+	if err := checkServiceAccount(v.cfg, claims.Email); err != nil {
+		return nil, err
+	}
+
+	return &Principal{Email: claims.Email, Method: ModeLocal}, nil
+}
+
+// localTransport adjunta un token del emisor local a cada solicitud saliente
+type localTransport struct {
+	issuer   *LocalIssuer
+	audience string
+	base     http.RoundTripper
+}
+
+// RoundTrip agrega la cabecera Authorization a una copia de la solicitud
+func (t *localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	audience := t.audience
+	if audience == "" {
+		audience = req.URL.Scheme + "://" + req.URL.Host
+	}
+
+	token, err := t.issuer.Token(audience)
+	if err != nil {
+		return nil, err
+	}
+
+	authorized := req.Clone(req.Context())
+	authorized.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(authorized)
+}
+
+// LoadOrCreateLocalKey lee la clave RSA del emisor local. Si el archivo no existe, genera una
+// clave nueva y la guarda; si otro proceso la crea a la vez, se usa la que quedó en disco.
+func LoadOrCreateLocalKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		return nil, fmt.Errorf("el modo local requiere la ruta de la clave del emisor")
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := createLocalKey(path); err != nil && !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error al leer la clave del emisor local: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("la clave del emisor local no está en formato PEM")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error al parsear la clave del emisor local: %v", err)
+	}
+	return key, nil
+}
+
+// createLocalKey genera una clave RSA y la publica en la ruta solo si el archivo no existe.
+// La clave se escribe primero en un archivo temporal para que nadie lea una clave a medio escribir.
+func createLocalKey(path string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("error al generar la clave del emisor local: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".kairosia-issuer-*")
+	if err != nil {
+		return fmt.Errorf("error al crear la clave del emisor local: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := pem.Encode(tmp, block); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error al guardar la clave del emisor local: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error al guardar la clave del emisor local: %v", err)
+	}
+
+	// os.Link falla con ErrExist si otro proceso publicó la clave antes
+	return os.Link(tmp.Name(), path)
+}
+
+// encodeSegment codifica un segmento del token en base64url sin relleno
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
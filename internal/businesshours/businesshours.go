@@ -0,0 +1,200 @@
+package businesshours
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"kairosia/internal/models"
+)
+
+const (
+	// DefaultTimezone es la zona horaria de los horarios que no indican una
+	DefaultTimezone = "America/Santiago"
+
+	// CalendarChile es el calendario de feriados nacionales de Chile
+	CalendarChile = "CL"
+
+	// dateLayout es el formato de las fechas de feriados y cierres: "2006-01-02"
+	dateLayout = "2006-01-02"
+	// minutesPerDay es el fin del día en minutos; "24:00" cierra a medianoche
+	minutesPerDay = 24 * 60
+)
+
+// Status es el resultado de evaluar un horario de atención en un instante
+type Status struct {
+	Open bool
+	// Holiday es el nombre del feriado o cierre del día, si lo hay
+	Holiday string
+}
+
+// Evaluate indica si el horario está abierto en el instante t, en la zona horaria del horario.
+// Los feriados del calendario y las fechas de cierre cierran el día completo; un horario
+// sin ventanas atiende todo el día los días que no son feriado.
+func Evaluate(hours models.BusinessHours, t time.Time) (Status, error) {
+	timezone := hours.Timezone
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return Status{}, fmt.Errorf("zona horaria inválida %q: %v", timezone, err)
+	}
+	local := t.In(location)
+
+	date := local.Format(dateLayout)
+	for _, closed := range hours.ClosedDates {
+		if closed == date {
+			return Status{Holiday: "Cierre programado"}, nil
+		}
+	}
+	if name, ok := Holiday(hours.HolidayCalendar, local); ok {
+		return Status{Holiday: name}, nil
+	}
+
+	if len(hours.Windows) == 0 {
+		return Status{Open: true}, nil
+	}
+	minute := local.Hour()*60 + local.Minute()
+	for _, window := range hours.Windows {
+		open, err := windowOpen(window, local.Weekday(), minute)
+		if err != nil {
+			return Status{}, err
+		}
+		if open {
+			return Status{Open: true}, nil
+		}
+	}
+	return Status{}, nil
+}
+
+// windowOpen indica si la ventana está abierta en el día y minuto indicados. Si End es anterior a Start,
+// la ventana cruza la medianoche y termina al día siguiente, que no necesita estar entre sus días.
+func windowOpen(window models.BusinessWindow, weekday time.Weekday, minute int) (bool, error) {
+	start, err := parseClock(window.Start)
+	if err != nil {
+		return false, err
+	}
+	end, err := parseClock(window.End)
+	if err != nil {
+		return false, err
+	}
+
+	if start < end {
+		return includesWeekday(window.Weekdays, weekday) && minute >= start && minute < end, nil
+	}
+	// Ventana nocturna: desde Start hasta la medianoche y desde la medianoche hasta End del día siguiente
+	if includesWeekday(window.Weekdays, weekday) && minute >= start {
+		return true, nil
+	}
+	previous := (weekday + 6) % 7
+	return includesWeekday(window.Weekdays, previous) && minute < end, nil
+}
+
+// includesWeekday indica si el día está en la lista; una lista vacía incluye todos los días
+func includesWeekday(weekdays []int, weekday time.Weekday) bool {
+	if len(weekdays) == 0 {
+		return true
+	}
+	for _, day := range weekdays {
+		if time.Weekday(day) == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock convierte "HH:MM" en minutos desde la medianoche
+func parseClock(s string) (int, error) {
+	hour, minute, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, fmt.Errorf("hora inválida %q: se espera HH:MM", s)
+	}
+	h, errHour := strconv.Atoi(hour)
+	m, errMinute := strconv.Atoi(minute)
+	if errHour != nil || errMinute != nil || h < 0 || m < 0 || m > 59 || h*60+m > minutesPerDay {
+		return 0, fmt.Errorf("hora inválida %q: se espera HH:MM", s)
+	}
+	return h*60 + m, nil
+}
+
+// ParseWindows convierte "1-5 09:00-18:00;6 10:00-14:00" en ventanas de atención.
+// Los días siguen time.Weekday (0 es domingo) y aceptan rangos y listas ("1-5", "1,3,5");
+// una ventana sin días ("09:00-18:00") aplica a todos los días.
+func ParseWindows(s string) ([]models.BusinessWindow, error) {
+	var windows []models.BusinessWindow
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		days, span := "", item
+		if fields := strings.Fields(item); len(fields) == 2 {
+			days, span = fields[0], fields[1]
+		} else if len(fields) != 1 {
+			return nil, fmt.Errorf("ventana de atención inválida %q", item)
+		}
+
+		start, end, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("ventana de atención inválida %q: se espera HH:MM-HH:MM", item)
+		}
+		window := models.BusinessWindow{Start: start, End: end}
+		if _, err := parseClock(start); err != nil {
+			return nil, err
+		}
+		if _, err := parseClock(end); err != nil {
+			return nil, err
+		}
+
+		weekdays, err := parseWeekdays(days)
+		if err != nil {
+			return nil, fmt.Errorf("ventana de atención inválida %q: %v", item, err)
+		}
+		window.Weekdays = weekdays
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// parseWeekdays convierte "1-5" o "1,3,5" en días de la semana; una cadena vacía son todos los días
+func parseWeekdays(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var weekdays []int
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("día de la semana inválido: %s", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(last); err != nil {
+				return nil, fmt.Errorf("día de la semana inválido: %s", part)
+			}
+		}
+		if from < 0 || to > 6 || from > to {
+			return nil, fmt.Errorf("día de la semana inválido: %s", part)
+		}
+		for day := from; day <= to; day++ {
+			weekdays = append(weekdays, day)
+		}
+	}
+	return weekdays, nil
+}
+
+// Holiday devuelve el nombre del feriado del calendario en la fecha local de t.
+// Un calendario vacío no tiene feriados.
+func Holiday(calendar string, t time.Time) (string, bool) {
+	switch strings.ToUpper(calendar) {
+	case CalendarChile:
+		name, ok := ChileanHolidays(t.Year())[t.Format(dateLayout)]
+		return name, ok
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,210 @@
+package businesshours
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"kairosia/internal/models"
+)
+
+// utc construye un instante UTC; Evaluate lo convierte a la zona horaria del horario
+func utc(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestEvaluate(t *testing.T) {
+	weekdays := models.BusinessHours{
+		Windows: []models.BusinessWindow{{Weekdays: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "18:00"}},
+	}
+	overnight := models.BusinessHours{
+		Timezone: "UTC",
+		Windows:  []models.BusinessWindow{{Weekdays: []int{5}, Start: "22:00", End: "02:00"}},
+	}
+	newYork := models.BusinessHours{
+		Timezone: "America/New_York",
+		Windows:  weekdays.Windows,
+	}
+	holidays := models.BusinessHours{
+		HolidayCalendar: CalendarChile,
+		ClosedDates:     []string{"2024-07-12"},
+		Windows:         weekdays.Windows,
+	}
+	allDay := models.BusinessHours{HolidayCalendar: CalendarChile}
+
+	tests := []struct {
+		name  string
+		hours models.BusinessHours
+		at    time.Time
+		want  Status
+	}{
+		// Santiago en julio es UTC-4
+		{name: "dentro de la ventana", hours: weekdays, at: utc(2024, time.July, 10, 13, 0), want: Status{Open: true}},
+		{name: "antes de abrir", hours: weekdays, at: utc(2024, time.July, 10, 12, 59), want: Status{}},
+		{name: "al cerrar", hours: weekdays, at: utc(2024, time.July, 10, 22, 0), want: Status{}},
+		{name: "fin de semana", hours: weekdays, at: utc(2024, time.July, 13, 15, 0), want: Status{}},
+		// Santiago en enero es UTC-3 por el horario de verano
+		{name: "horario de verano abierto", hours: weekdays, at: utc(2024, time.January, 10, 12, 0), want: Status{Open: true}},
+		{name: "horario de verano cerrado", hours: weekdays, at: utc(2024, time.January, 10, 21, 0), want: Status{}},
+		// 02:00 UTC del sábado es viernes en Santiago
+		{name: "día local distinto del día UTC", hours: weekdays, at: utc(2024, time.July, 13, 2, 0), want: Status{}},
+		{name: "otra zona horaria abierta", hours: newYork, at: utc(2024, time.July, 10, 21, 30), want: Status{Open: true}},
+		{name: "otra zona horaria cerrada", hours: newYork, at: utc(2024, time.July, 10, 22, 0), want: Status{}},
+
+		{name: "nocturna antes de medianoche", hours: overnight, at: utc(2024, time.July, 12, 23, 0), want: Status{Open: true}},
+		{name: "nocturna después de medianoche", hours: overnight, at: utc(2024, time.July, 13, 1, 59), want: Status{Open: true}},
+		{name: "nocturna al cerrar", hours: overnight, at: utc(2024, time.July, 13, 2, 0), want: Status{}},
+		{name: "nocturna antes de abrir", hours: overnight, at: utc(2024, time.July, 12, 21, 59), want: Status{}},
+		{name: "nocturna otro día", hours: overnight, at: utc(2024, time.July, 11, 23, 0), want: Status{}},
+		{name: "nocturna madrugada del viernes", hours: overnight, at: utc(2024, time.July, 12, 1, 0), want: Status{}},
+
+		{name: "feriado", hours: holidays, at: utc(2024, time.September, 18, 15, 0), want: Status{Holiday: "Independencia Nacional"}},
+		// 01:00 UTC del 19 de septiembre es el 18 en Santiago (UTC-3)
+		{name: "feriado en la fecha local", hours: allDay, at: utc(2024, time.September, 19, 1, 0), want: Status{Holiday: "Independencia Nacional"}},
+		{name: "cierre programado", hours: holidays, at: utc(2024, time.July, 12, 15, 0), want: Status{Holiday: "Cierre programado"}},
+		{name: "sin ventanas", hours: allDay, at: utc(2024, time.July, 13, 7, 0), want: Status{Open: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Evaluate(tt.hours, tt.at)
+			if err != nil {
+				t.Fatalf("Evaluate error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Evaluate(%s) = %+v, want %+v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		hours models.BusinessHours
+	}{
+		{name: "zona horaria", hours: models.BusinessHours{Timezone: "America/Nowhere"}},
+		{name: "hora", hours: models.BusinessHours{Windows: []models.BusinessWindow{{Start: "9", End: "18:00"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Evaluate(tt.hours, utc(2024, time.July, 10, 15, 0)); err == nil {
+				t.Error("Evaluate error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestParseWindows(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []models.BusinessWindow
+		wantErr bool
+	}{
+		{
+			value: "1-5 09:00-18:00;6 10:00-14:00",
+			want: []models.BusinessWindow{
+				{Weekdays: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "18:00"},
+				{Weekdays: []int{6}, Start: "10:00", End: "14:00"},
+			},
+		},
+		{value: "1,3,5 08:30-12:00", want: []models.BusinessWindow{{Weekdays: []int{1, 3, 5}, Start: "08:30", End: "12:00"}}},
+		{value: "22:00-02:00", want: []models.BusinessWindow{{Start: "22:00", End: "02:00"}}},
+		{value: "00:00-24:00", want: []models.BusinessWindow{{Start: "00:00", End: "24:00"}}},
+		{value: "", want: nil},
+		{value: "1-5 09:00", wantErr: true},
+		{value: "1-5 09:00-25:00", wantErr: true},
+		{value: "7 09:00-18:00", wantErr: true},
+		{value: "5-1 09:00-18:00", wantErr: true},
+		{value: "1-5 09:00 18:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseWindows(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWindows(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWindows(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChileanHolidays(t *testing.T) {
+	tests := []struct {
+		date string
+		want string
+	}{
+		// Viernes y Sábado Santo según el domingo de Pascua
+		{date: "2023-04-07", want: "Viernes Santo"},
+		{date: "2023-04-08", want: "Sábado Santo"},
+		{date: "2024-03-29", want: "Viernes Santo"},
+		{date: "2024-03-30", want: "Sábado Santo"},
+		{date: "2025-04-18", want: "Viernes Santo"},
+		{date: "2025-04-19", want: "Sábado Santo"},
+
+		// Solsticio de invierno: fijo en 2021 y calculado desde 2022
+		{date: "2021-06-21", want: "Día Nacional de los Pueblos Indígenas"},
+		{date: "2022-06-21", want: "Día Nacional de los Pueblos Indígenas"},
+		{date: "2023-06-21", want: "Día Nacional de los Pueblos Indígenas"},
+		{date: "2024-06-20", want: "Día Nacional de los Pueblos Indígenas"},
+		{date: "2025-06-20", want: "Día Nacional de los Pueblos Indígenas"},
+
+		// Ley 19.668: martes a jueves pasan al lunes anterior y viernes al lunes siguiente
+		{date: "2021-06-28", want: "San Pedro y San Pablo"},
+		{date: "2022-06-27", want: "San Pedro y San Pablo"},
+		{date: "2023-06-26", want: "San Pedro y San Pablo"},
+		{date: "2018-07-02", want: "San Pedro y San Pablo"},
+		{date: "2024-06-29", want: "San Pedro y San Pablo"},
+		{date: "2021-10-11", want: "Encuentro de Dos Mundos"},
+		{date: "2022-10-10", want: "Encuentro de Dos Mundos"},
+		{date: "2023-10-09", want: "Encuentro de Dos Mundos"},
+		{date: "2018-10-15", want: "Encuentro de Dos Mundos"},
+
+		{date: "2023-01-02", want: "Feriado adicional de Año Nuevo"},
+		{date: "2018-09-17", want: "Fiestas Patrias"},
+		{date: "2019-09-20", want: "Fiestas Patrias"},
+		{date: "2023-10-27", want: "Día de las Iglesias Evangélicas y Protestantes"},
+		{date: "2024-10-31", want: "Día de las Iglesias Evangélicas y Protestantes"},
+		{date: "2024-12-25", want: "Navidad"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			day, err := time.Parse(dateLayout, tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ChileanHolidays(day.Year())[tt.date]; got != tt.want {
+				t.Errorf("ChileanHolidays(%d)[%s] = %q, want %q", day.Year(), tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChileanHolidaysMovedDates(t *testing.T) {
+	// Las fechas originales de los feriados trasladados no son feriado
+	tests := []string{
+		"2020-06-21",
+		"2023-06-29",
+		"2018-06-29",
+		"2023-10-12",
+		"2018-10-12",
+		"2023-10-31",
+		"2024-01-02",
+		"2023-09-20",
+	}
+
+	for _, date := range tests {
+		day, err := time.Parse(dateLayout, date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name, ok := ChileanHolidays(day.Year())[date]; ok {
+			t.Errorf("ChileanHolidays(%d)[%s] = %q, want no holiday", day.Year(), date, name)
+		}
+	}
+}
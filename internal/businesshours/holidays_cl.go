@@ -0,0 +1,151 @@
+package businesshours
+
+import (
+	"math"
+	"time"
+)
+
+// chileTimezone es la zona horaria en la que se determina la fecha del solsticio de invierno
+const chileTimezone = "America/Santiago"
+
+// ChileanHolidays devuelve los feriados nacionales de Chile del año, indexados por fecha "2006-01-02".
+// Incluye los feriados trasladables (Leyes 19.668, 20.215, 20.299 y 20.983) y el Día Nacional de los
+// Pueblos Indígenas (Ley 21.357). Los feriados extraordinarios, como los días de elecciones, se
+// configuran como fechas de cierre del horario de atención.
+func ChileanHolidays(year int) map[string]string {
+	holidays := map[string]string{}
+	add := func(t time.Time, name string) {
+		holidays[t.Format(dateLayout)] = name
+	}
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	add(date(time.January, 1), "Año Nuevo")
+	// Ley 20.983: el 2 de enero es feriado cuando cae lunes, es decir, cuando el Año Nuevo cae domingo
+	if date(time.January, 1).Weekday() == time.Sunday {
+		add(date(time.January, 2), "Feriado adicional de Año Nuevo")
+	}
+	easter := easterSunday(year)
+	add(easter.AddDate(0, 0, -2), "Viernes Santo")
+	add(easter.AddDate(0, 0, -1), "Sábado Santo")
+	add(date(time.May, 1), "Día Nacional del Trabajo")
+	add(date(time.May, 21), "Día de las Glorias Navales")
+	if solstice, ok := indigenousPeoplesDay(year); ok {
+		add(solstice, "Día Nacional de los Pueblos Indígenas")
+	}
+	add(nearestMonday(date(time.June, 29)), "San Pedro y San Pablo")
+	add(date(time.July, 16), "Día de la Virgen del Carmen")
+	add(date(time.August, 15), "Asunción de la Virgen")
+
+	// Fiestas Patrias: el 17 de septiembre es feriado si cae lunes y el 20 si cae viernes
+	if sep17 := date(time.September, 17); sep17.Weekday() == time.Monday {
+		add(sep17, "Fiestas Patrias")
+	}
+	add(date(time.September, 18), "Independencia Nacional")
+	add(date(time.September, 19), "Día de las Glorias del Ejército")
+	if sep20 := date(time.September, 20); sep20.Weekday() == time.Friday {
+		add(sep20, "Fiestas Patrias")
+	}
+
+	add(nearestMonday(date(time.October, 12)), "Encuentro de Dos Mundos")
+
+	// Día de las Iglesias Evangélicas: si el 31 de octubre cae martes se traslada al viernes anterior
+	// y si cae miércoles, al viernes siguiente
+	reformation := date(time.October, 31)
+	switch reformation.Weekday() {
+	case time.Tuesday:
+		reformation = reformation.AddDate(0, 0, -4)
+	case time.Wednesday:
+		reformation = reformation.AddDate(0, 0, 2)
+	}
+	add(reformation, "Día de las Iglesias Evangélicas y Protestantes")
+
+	add(date(time.November, 1), "Día de Todos los Santos")
+	add(date(time.December, 8), "Inmaculada Concepción")
+	add(date(time.December, 25), "Navidad")
+
+	return holidays
+}
+
+// nearestMonday traslada los feriados de la Ley 19.668: si caen martes, miércoles o jueves pasan
+// al lunes anterior y si caen viernes, al lunes siguiente
+func nearestMonday(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Tuesday, time.Wednesday, time.Thursday:
+		return t.AddDate(0, 0, -int(t.Weekday()-time.Monday))
+	case time.Friday:
+		return t.AddDate(0, 0, 3)
+	default:
+		return t
+	}
+}
+
+// easterSunday calcula el domingo de Pascua del calendario gregoriano (algoritmo de Meeus/Jones/Butcher)
+func easterSunday(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// indigenousPeoplesDay devuelve el día del solsticio de invierno en Chile, feriado desde 2021
+func indigenousPeoplesDay(year int) (time.Time, bool) {
+	if year < 2021 {
+		return time.Time{}, false
+	}
+	// La Ley 21.357 fijó el feriado de 2021 el 21 de junio
+	if year == 2021 {
+		return time.Date(year, time.June, 21, 0, 0, 0, 0, time.UTC), true
+	}
+
+	location, err := time.LoadLocation(chileTimezone)
+	if err != nil {
+		location = time.FixedZone("CLT", -4*60*60)
+	}
+	local := juneSolstice(year).In(location)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC), true
+}
+
+// juneSolstice calcula el instante aproximado del solsticio de junio (Meeus, Astronomical Algorithms,
+// cap. 27). El error es de pocos minutos, suficiente para determinar la fecha del feriado.
+func juneSolstice(year int) time.Time {
+	y := (float64(year) - 2000) / 1000
+	jde0 := 2451716.56767 + 365241.62603*y + 0.00325*y*y + 0.00888*y*y*y - 0.00030*y*y*y*y
+
+	t := (jde0 - 2451545.0) / 36525
+	w := (35999.373*t - 2.47) * math.Pi / 180
+	deltaLambda := 1 + 0.0334*math.Cos(w) + 0.0007*math.Cos(2*w)
+
+	var s float64
+	for _, term := range solsticeTerms {
+		s += term[0] * math.Cos((term[1]+term[2]*t)*math.Pi/180)
+	}
+	jde := jde0 + 0.00001*s/deltaLambda
+
+	// Día juliano a tiempo Unix; la diferencia entre TT y UTC (~70 s) no afecta la fecha
+	return time.Unix(int64(math.Round((jde-2440587.5)*86400)), 0).UTC()
+}
+
+// solsticeTerms son los términos periódicos A, B y C de la tabla 27.C de Meeus
+var solsticeTerms = [][3]float64{
+	{485, 324.96, 1934.136}, {203, 337.23, 32964.467}, {199, 342.08, 20.186},
+	{182, 27.85, 445267.112}, {156, 73.14, 45036.886}, {136, 171.52, 22518.443},
+	{77, 222.54, 65928.934}, {74, 296.72, 3034.906}, {70, 243.58, 9037.513},
+	{58, 119.81, 33718.147}, {52, 297.17, 150.678}, {50, 21.02, 2281.226},
+	{45, 247.54, 29929.562}, {44, 325.15, 31555.956}, {29, 60.93, 4443.417},
+	{18, 155.12, 67555.328}, {17, 288.79, 4562.452}, {16, 198.04, 62894.029},
+	{14, 199.76, 31436.921}, {12, 95.39, 14577.848}, {12, 287.11, 31931.756},
+	{12, 320.81, 34777.259}, {9, 227.73, 1222.114}, {8, 15.45, 16859.074},
+}
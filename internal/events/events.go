@@ -0,0 +1,173 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"kairosia/internal/models"
+)
+
+// EventType identifica el tipo de un evento del ciclo de vida de una conversación
+type EventType string
+
+const (
+	// CallStarted se emite cuando se crea el estado de una nueva llamada
+	CallStarted EventType = "call.started"
+	// TurnCompleted se emite al completar cada turno usuario/IA
+	TurnCompleted EventType = "call.turn_completed"
+	// HandoffRequested se emite cuando Dialogflow solicita transferir a un agente humano
+	HandoffRequested EventType = "call.handoff_requested"
+	// CallEnded se emite cuando Twilio informa que la llamada terminó
+	CallEnded EventType = "call.ended"
+)
+
+// SubjectPrefix es el prefijo de los subjects de los eventos de conversación
+const SubjectPrefix = "kairosia.conversation."
+
+// Subject devuelve el subject del tipo de evento, por ejemplo "kairosia.conversation.call.started"
+func (t EventType) Subject() string {
+	return SubjectPrefix + string(t)
+}
+
+// Event representa un evento del ciclo de vida de una conversación.
+// Data contiene el JSON de uno de los tipos *Data según Type.
+type Event struct {
+	ID         string          `json:"id"`
+	Type       EventType       `json:"type"`
+	CallSid    string          `json:"call_sid"`
+	TenantID   string          `json:"tenant_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// CallStartedData contiene los datos de un evento CallStarted
+type CallStartedData struct {
+	FromNumber     string    `json:"from_number"`
+	ToNumber       string    `json:"to_number"`
+	StartTimestamp time.Time `json:"start_timestamp"`
+}
+
+// TurnCompletedData contiene los datos de un evento TurnCompleted.
+// Transcript es la transcripción acumulada hasta el turno.
+type TurnCompletedData struct {
+	TurnIndex  int                           `json:"turn_index"`
+	UserEntry  models.TranscriptEntry        `json:"user_entry"`
+	AIEntry    models.TranscriptEntry        `json:"ai_entry"`
+	Transcript *models.FullTranscriptPayload `json:"transcript"`
+}
+
+// HandoffRequestedData contiene los datos de un evento HandoffRequested
+type HandoffRequestedData struct {
+	Handoff   *models.LiveAgentHandoffPayload `json:"handoff"`
+	Timestamp time.Time                       `json:"timestamp"`
+}
+
+// CallEndedData contiene los datos de un evento CallEnded
+type CallEndedData struct {
+	CallStatus      string                        `json:"call_status"`
+	DurationSeconds int                           `json:"duration_seconds"`
+	Transcript      *models.FullTranscriptPayload `json:"transcript"`
+}
+
+// NewEvent crea un evento serializando sus datos
+func NewEvent(id string, eventType EventType, callSid, tenantID string, data interface{}) (*Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar los datos del evento %s: %v", eventType, err)
+	}
+
+	return &Event{
+		ID:         id,
+		Type:       eventType,
+		CallSid:    callSid,
+		TenantID:   tenantID,
+		OccurredAt: time.Now(),
+		Data:       raw,
+	}, nil
+}
+
+// DecodeData parsea los datos del evento en el tipo correspondiente
+func (e *Event) DecodeData(v interface{}) error {
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		return fmt.Errorf("error al parsear los datos del evento %s: %v", e.ID, err)
+	}
+	return nil
+}
+
+// Attributes devuelve los atributos del mensaje usados para filtrar y enrutar el evento
+func (e *Event) Attributes() map[string]string {
+	return map[string]string{
+		"event_id":   e.ID,
+		"event_type": string(e.Type),
+		"call_sid":   e.CallSid,
+		"tenant_id":  e.TenantID,
+	}
+}
+
+// Publisher publica eventos de conversación en un bus de eventos
+type Publisher interface {
+	// Publish publica un evento y espera la confirmación del bus
+	Publish(ctx context.Context, event *Event) error
+	// Close libera los recursos del publicador
+	Close() error
+}
+
+// Handler procesa un evento recibido del bus
+type Handler func(ctx context.Context, event *Event) error
+
+// PushMessage representa el mensaje de una suscripción push de Pub/Sub
+type PushMessage struct {
+	Data        []byte            `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	MessageID   string            `json:"messageId"`
+	PublishTime time.Time         `json:"publishTime"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+// PushEnvelope representa el cuerpo de una solicitud push de Pub/Sub
+type PushEnvelope struct {
+	Message      PushMessage `json:"message"`
+	Subscription string      `json:"subscription"`
+}
+
+// NewPushEnvelope construye el sobre push de un evento, con el mismo formato que envía Pub/Sub
+func NewPushEnvelope(event *Event, subscription string) (*PushEnvelope, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar el evento %s: %v", event.ID, err)
+	}
+
+	return &PushEnvelope{
+		Message: PushMessage{
+			Data:        data,
+			Attributes:  event.Attributes(),
+			MessageID:   event.ID,
+			PublishTime: time.Now(),
+			OrderingKey: event.CallSid,
+		},
+		Subscription: subscription,
+	}, nil
+}
+
+// DecodePushRequest extrae el evento de una solicitud push de Pub/Sub
+func DecodePushRequest(r *http.Request) (*Event, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error al leer el cuerpo de la solicitud: %v", err)
+	}
+
+	var envelope PushEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("error al parsear el sobre push: %v", err)
+	}
+
+	var event Event
+	if err := json.Unmarshal(envelope.Message.Data, &event); err != nil {
+		return nil, fmt.Errorf("error al parsear el evento %s: %v", envelope.Message.MessageID, err)
+	}
+	return &event, nil
+}
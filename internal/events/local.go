@@ -0,0 +1,121 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// LocalBus es un bus de eventos en proceso para desarrollo local.
+// Los subjects y patrones siguen la semántica de NATS: tokens separados por ".",
+// "*" coincide con un token y ">" con uno o más tokens al final.
+type LocalBus struct {
+	mu            sync.RWMutex
+	subscriptions []*localSubscription
+}
+
+// localSubscription asocia un patrón de subject con su manejador
+type localSubscription struct {
+	pattern string
+	handler Handler
+}
+
+// NewLocalBus crea un bus de eventos en proceso
+func NewLocalBus() *LocalBus {
+	return &LocalBus{}
+}
+
+// Subscribe registra un manejador para los eventos cuyo subject coincide con el patrón
+func (b *LocalBus) Subscribe(pattern string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions = append(b.subscriptions, &localSubscription{pattern: pattern, handler: handler})
+}
+
+// Publish entrega el evento de forma síncrona a todos los suscriptores cuyo patrón coincide.
+// Devuelve un error si algún manejador falla, para que el llamador pueda reintentar.
+func (b *LocalBus) Publish(ctx context.Context, event *Event) error {
+	b.mu.RLock()
+	subscriptions := make([]*localSubscription, len(b.subscriptions))
+	copy(subscriptions, b.subscriptions)
+	b.mu.RUnlock()
+
+	subject := event.Type.Subject()
+	var errs []error
+	for _, sub := range subscriptions {
+		if !MatchSubject(sub.pattern, subject) {
+			continue
+		}
+		if err := sub.handler(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("error del suscriptor %s: %v", sub.pattern, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close no libera recursos; existe para cumplir la interfaz Publisher
+func (b *LocalBus) Close() error {
+	return nil
+}
+
+// PushForwarder devuelve un manejador que reenvía cada evento a un endpoint HTTP con el
+// formato de una suscripción push de Pub/Sub, de modo que el consumidor no distingue
+// entre el bus local y Pub/Sub
+func PushForwarder(client *http.Client, endpoint, subscription string) Handler {
+	return func(ctx context.Context, event *Event) error {
+		envelope, err := NewPushEnvelope(event, subscription)
+		if err != nil {
+			return err
+		}
+
+		body, err := json.Marshal(envelope)
+		if err != nil {
+			return fmt.Errorf("error al serializar el sobre push: %v", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("error al crear la solicitud push: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("error al enviar el evento %s a %s: %v", event.ID, endpoint, err)
+		}
+		defer resp.Body.Close()
+
+		// Igual que Pub/Sub, cualquier respuesta 2xx confirma el mensaje
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			respBody, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("error del endpoint push: %s - %s", resp.Status, string(respBody))
+		}
+		return nil
+	}
+}
+
+// MatchSubject indica si un subject coincide con un patrón con comodines de NATS
+func MatchSubject(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, token := range patternTokens {
+		if token == ">" {
+			// ">" debe ser el último token y cubrir al menos un token del subject
+			return i == len(patternTokens)-1 && len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) {
+			return false
+		}
+		if token != "*" && token != subjectTokens[i] {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
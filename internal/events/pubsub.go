@@ -0,0 +1,55 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// PubSubPublisher publica eventos en un tópico de Google Cloud Pub/Sub.
+// Los mensajes usan el CallSid como clave de orden para conservar la secuencia de cada llamada.
+type PubSubPublisher struct {
+	client *pubsub.Client
+	topic  *pubsub.Topic
+}
+
+// NewPubSubPublisher crea un publicador para el tópico indicado
+func NewPubSubPublisher(ctx context.Context, projectID, topicID string) (*PubSubPublisher, error) {
+	client, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente de Pub/Sub: %v", err)
+	}
+
+	topic := client.Topic(topicID)
+	topic.EnableMessageOrdering = true
+
+	return &PubSubPublisher{client: client, topic: topic}, nil
+}
+
+// Publish publica el evento y espera a que Pub/Sub lo confirme
+func (p *PubSubPublisher) Publish(ctx context.Context, event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error al serializar el evento %s: %v", event.ID, err)
+	}
+
+	result := p.topic.Publish(ctx, &pubsub.Message{
+		Data:        data,
+		Attributes:  event.Attributes(),
+		OrderingKey: event.CallSid,
+	})
+	if _, err := result.Get(ctx); err != nil {
+		// Tras un error, Pub/Sub pausa la clave de orden hasta que se reanude explícitamente
+		p.topic.ResumePublish(event.CallSid)
+		return fmt.Errorf("error al publicar el evento %s en Pub/Sub: %v", event.ID, err)
+	}
+	return nil
+}
+
+// Close detiene el tópico y cierra el cliente
+func (p *PubSubPublisher) Close() error {
+	p.topic.Stop()
+	return p.client.Close()
+}
@@ -0,0 +1,70 @@
+package language
+
+import (
+	"strings"
+	"unicode"
+
+	"kairosia/internal/prompts"
+)
+
+// Answer es la respuesta del cliente a una pregunta de sí o no
+type Answer int
+
+const (
+	// AnswerUnknown indica que el texto no es una respuesta de sí o no
+	AnswerUnknown Answer = iota
+	AnswerYes
+	AnswerNo
+)
+
+// yesWords y noWords son las palabras de afirmación y de negación de cada lengua
+var (
+	yesWords = map[string][]string{
+		"es": {"sí", "si", "correcto", "exacto", "exactamente", "claro", "afirmativo", "efectivamente", "así", "eso", "ok", "dale"},
+		"en": {"yes", "yeah", "yep", "yup", "correct", "right", "exactly", "sure", "ok", "okay"},
+		"pt": {"sim", "isso", "correto", "exato", "exatamente", "claro", "certo", "ok"},
+	}
+	noWords = map[string][]string{
+		"es": {"no", "incorrecto", "negativo", "nop"},
+		"en": {"no", "nope", "wrong", "incorrect"},
+		"pt": {"não", "nao", "errado", "incorreto", "negativo"},
+	}
+)
+
+// maxConfirmationWords es el largo máximo de una respuesta de sí o no. En un texto más largo solo se
+// considera la primera palabra ("no, dije pagar la cuenta"), para que una respuesta nueva que contiene
+// una negación ("quiero pagar, pero no sé cuánto debo") no se tome como un no.
+const maxConfirmationWords = 3
+
+// Confirmation clasifica la respuesta del cliente a una pregunta de sí o no en el idioma de la llamada.
+// Si el texto tiene una negación la respuesta es AnswerNo, aunque también tenga una afirmación ("eso no").
+func Confirmation(text, locale string) Answer {
+	language := prompts.Language(locale)
+	tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	if len(tokens) > maxConfirmationWords {
+		tokens = tokens[:1]
+	}
+
+	answer := AnswerUnknown
+	for _, token := range tokens {
+		if contains(noWords[language], token) {
+			return AnswerNo
+		}
+		if contains(yesWords[language], token) {
+			answer = AnswerYes
+		}
+	}
+	return answer
+}
+
+// contains indica si una palabra está en la lista
+func contains(words []string, word string) bool {
+	for _, candidate := range words {
+		if candidate == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package language
+
+import (
+	"strings"
+	"unicode"
+
+	"kairosia/internal/prompts"
+)
+
+// Idiomas que reconoce Detect
+const (
+	SpanishChile     = "es-CL"
+	EnglishUS        = "en-US"
+	PortugueseBrazil = "pt-BR"
+)
+
+// markers son palabras frecuentes y propias de cada lengua. Se excluyen las que se escriben igual en
+// más de una (por ejemplo "no", "para" o "me"), que no ayudan a distinguirlas.
+var markers = map[string][]string{
+	"es": {
+		"el", "la", "los", "las", "una", "y", "es", "estoy", "quiero", "necesito", "tengo", "mi", "con", "por",
+		"hola", "sí", "si", "gracias", "usted", "cuenta", "boleta", "pagar", "quisiera", "puedo", "hablar",
+		"ayuda", "ayudar", "buenos", "buenas", "días", "tardes", "noches", "qué", "cómo", "cuándo", "dónde",
+		"pero", "muy", "yo", "eso", "esto", "ya", "hay", "del", "al", "señor", "señora", "bueno", "ahora",
+		"llamo", "saber", "plan", "cuánto", "necesita", "servicio", "internet",
+	},
+	"en": {
+		"the", "and", "is", "are", "i", "i'm", "my", "you", "your", "to", "of", "it", "this", "that", "want",
+		"need", "have", "hello", "hi", "yes", "please", "thank", "thanks", "speak", "english", "account",
+		"bill", "pay", "can", "could", "would", "like", "what", "how", "when", "where", "with", "for", "do",
+		"don't", "not", "help", "good", "morning", "afternoon", "evening", "there", "about", "calling", "know",
+	},
+	"pt": {
+		"não", "sim", "obrigado", "obrigada", "você", "vocês", "olá", "oi", "eu", "meu", "minha", "quero",
+		"preciso", "tenho", "falar", "conta", "pagamento", "bom", "boa", "estou", "com", "uma", "um", "do",
+		"da", "dos", "das", "na", "em", "isso", "isto", "muito", "mas", "ajuda", "gostaria", "posso", "fatura",
+		"ele", "ela", "é", "são", "tudo", "português", "ligando", "agora", "saber", "quanto",
+	},
+}
+
+// letterMarkers son letras que solo aparecen en una de las lenguas
+var letterMarkers = map[rune]string{
+	'ñ': "es", '¿': "es", '¡': "es",
+	'ã': "pt", 'õ': "pt", 'ç': "pt", 'ê': "pt", 'ô': "pt",
+}
+
+// markerLanguages indexa las palabras de markers; una palabra que quedó en dos lenguas no se cuenta
+var markerLanguages = func() map[string]string {
+	index := map[string]string{}
+	for language, words := range markers {
+		for _, word := range words {
+			if previous, ok := index[word]; ok && previous != language {
+				index[word] = ""
+				continue
+			}
+			index[word] = language
+		}
+	}
+	return index
+}()
+
+// Detection es el resultado de clasificar un texto
+type Detection struct {
+	// Locale es el idioma candidato ganador, o vacío si el texto no tiene marcas de ninguno
+	Locale string
+	// Score es la proporción de las marcas del texto que corresponden a Locale, entre 0 y 1
+	Score float64
+	// Matches es la cantidad de marcas del texto que corresponden a Locale
+	Matches int
+}
+
+// Detect clasifica un texto entre los idiomas candidatos ("es-CL", "en-US", "pt-BR", ...) contando las
+// palabras y letras propias de cada lengua. Los candidatos de una lengua que Detect no conoce se ignoran y,
+// si hay varios de la misma lengua, gana el primero.
+func Detect(text string, candidates []string) Detection {
+	counts := map[string]int{}
+	for _, r := range strings.ToLower(text) {
+		if language, ok := letterMarkers[r]; ok {
+			counts[language]++
+		}
+	}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		if language := markerLanguages[word]; language != "" {
+			counts[language]++
+		}
+	}
+
+	var detection Detection
+	total := 0
+	counted := map[string]bool{}
+	for _, candidate := range candidates {
+		base := prompts.Language(candidate)
+		if counted[base] {
+			continue
+		}
+		counted[base] = true
+		matches := counts[base]
+		total += matches
+		if matches > detection.Matches {
+			detection = Detection{Locale: candidate, Matches: matches}
+		}
+	}
+	if detection.Matches > 0 {
+		detection.Score = float64(detection.Matches) / float64(total)
+	}
+	return detection
+}
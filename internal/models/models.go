@@ -18,6 +18,11 @@ type VoiceRequest struct {
 	RecordingDuration string `json:"RecordingDuration,omitempty"`
 	Digits        string `json:"Digits,omitempty"`
 	SpeechResult  string `json:"SpeechResult,omitempty"`
+	Confidence    string `json:"Confidence,omitempty"`
+	AnsweredBy    string `json:"AnsweredBy,omitempty"`
+	CampaignID    string `json:"CampaignId,omitempty"`
+	CampaignContactID string `json:"CampaignContactId,omitempty"`
+	CallbackID    string `json:"CallbackId,omitempty"`
 }
 
 // ConversationState representa el estado de una conversación en Firestore
@@ -34,6 +39,149 @@ type ConversationState struct {
 	HandoffOccurred bool   `json:"handoff_occurred" firestore:"handoff_occurred"`
 	HandoffReason   string `json:"handoff_reason,omitempty" firestore:"handoff_reason,omitempty"`
 	HandoffTimestamp *time.Time `json:"handoff_timestamp,omitempty" firestore:"handoff_timestamp,omitempty"`
+	HandoffPreserveContext bool `json:"handoff_preserve_context,omitempty" firestore:"handoff_preserve_context,omitempty"`
+	HandoffSummary  string     `json:"handoff_summary,omitempty" firestore:"handoff_summary,omitempty"`
+	LastDialogflowResult *DialogflowQueryResult `json:"last_dialogflow_result,omitempty" firestore:"last_dialogflow_result,omitempty"`
+	CallStatus      string     `json:"call_status,omitempty" firestore:"call_status,omitempty"`
+	EndTimestamp    *time.Time `json:"end_timestamp,omitempty" firestore:"end_timestamp,omitempty"`
+	Campaign        *CampaignContext `json:"campaign,omitempty" firestore:"campaign,omitempty"`
+	AnsweredBy      string     `json:"answered_by,omitempty" firestore:"answered_by,omitempty"`
+	MachineDetectionOutcome string `json:"machine_detection_outcome,omitempty" firestore:"machine_detection_outcome,omitempty"`
+	CallbackOffered bool       `json:"callback_offered,omitempty" firestore:"callback_offered,omitempty"`
+	Callback        *CallbackContext `json:"callback,omitempty" firestore:"callback,omitempty"`
+	CurrentTransferNumber  string            `json:"current_transfer_number,omitempty" firestore:"current_transfer_number,omitempty"`
+	PendingTransferNumbers []string          `json:"pending_transfer_numbers,omitempty" firestore:"pending_transfer_numbers,omitempty"`
+	TransferAttempts       []TransferAttempt `json:"transfer_attempts,omitempty" firestore:"transfer_attempts,omitempty"`
+	TransferOutcome        string            `json:"transfer_outcome,omitempty" firestore:"transfer_outcome,omitempty"`
+	TransferDurationSeconds int              `json:"transfer_duration_seconds,omitempty" firestore:"transfer_duration_seconds,omitempty"`
+	VoicemailRecordingURL  string            `json:"voicemail_recording_url,omitempty" firestore:"voicemail_recording_url,omitempty"`
+	ConferenceName   string            `json:"conference_name,omitempty" firestore:"conference_name,omitempty"`
+	ConferenceSid    string            `json:"conference_sid,omitempty" firestore:"conference_sid,omitempty"`
+	AgentCallSid     string            `json:"agent_call_sid,omitempty" firestore:"agent_call_sid,omitempty"`
+	ConferenceEvents []ConferenceEvent `json:"conference_events,omitempty" firestore:"conference_events,omitempty"`
+	QueueName        string            `json:"queue_name,omitempty" firestore:"queue_name,omitempty"`
+	QueueResult      string            `json:"queue_result,omitempty" firestore:"queue_result,omitempty"`
+	QueueTimeSeconds int               `json:"queue_time_seconds,omitempty" firestore:"queue_time_seconds,omitempty"`
+	RoutingRuleID    string            `json:"routing_rule_id,omitempty" firestore:"routing_rule_id,omitempty"`
+	BusinessHours    *BusinessHoursStatus `json:"business_hours,omitempty" firestore:"business_hours,omitempty"`
+	// LanguageCode es el idioma al que se cambió la llamada; vacío usa los idiomas predeterminados
+	LanguageCode     string            `json:"language_code,omitempty" firestore:"language_code,omitempty"`
+	DetectedLanguage string            `json:"detected_language,omitempty" firestore:"detected_language,omitempty"`
+	// NoInputCount y NoMatchCount son los turnos seguidos sin respuesta del cliente y sin coincidencia en
+	// Dialogflow; los totales acumulan toda la llamada para analítica
+	NoInputCount     int               `json:"no_input_count,omitempty" firestore:"no_input_count,omitempty"`
+	NoMatchCount     int               `json:"no_match_count,omitempty" firestore:"no_match_count,omitempty"`
+	NoInputTotal     int               `json:"no_input_total,omitempty" firestore:"no_input_total,omitempty"`
+	NoMatchTotal     int               `json:"no_match_total,omitempty" firestore:"no_match_total,omitempty"`
+	// EscalationMenu indica que el último TwiML fue el menú DTMF de la política de reintentos
+	EscalationMenu   bool              `json:"escalation_menu,omitempty" firestore:"escalation_menu,omitempty"`
+	// PendingConfirmation es la transcripción de baja confianza que se está confirmando con el cliente
+	PendingConfirmation  *SpeechConfirmation `json:"pending_confirmation,omitempty" firestore:"pending_confirmation,omitempty"`
+	ConfirmationAttempts int                 `json:"confirmation_attempts,omitempty" firestore:"confirmation_attempts,omitempty"`
+	SpeechStats          SpeechStats         `json:"speech_stats" firestore:"speech_stats"`
+	// DTMFMenu y DigitCollection son el menú por teclado o la captura de dígitos que pidió Dialogflow
+	DTMFMenu        *DTMFMenu        `json:"dtmf_menu,omitempty" firestore:"dtmf_menu,omitempty"`
+	DigitCollection *DigitCollection `json:"digit_collection,omitempty" firestore:"digit_collection,omitempty"`
+}
+
+// DTMFMenu representa un menú por teclado: la opción de cada tecla se envía a Dialogflow en el parámetro menu_option
+type DTMFMenu struct {
+	Options  map[string]string `json:"options" firestore:"options"`
+	Prompt   string            `json:"prompt" firestore:"prompt"`
+	Attempts int               `json:"attempts,omitempty" firestore:"attempts,omitempty"`
+}
+
+// DigitCollection representa la captura de un número por teclado (RUT, número de cuenta, etc.), que se envía
+// a Dialogflow en el parámetro Parameter
+type DigitCollection struct {
+	Parameter   string `json:"parameter" firestore:"parameter"`
+	Type        string `json:"type" firestore:"type"`
+	NumDigits   int    `json:"num_digits,omitempty" firestore:"num_digits,omitempty"`
+	MinDigits   int    `json:"min_digits,omitempty" firestore:"min_digits,omitempty"`
+	MaxDigits   int    `json:"max_digits,omitempty" firestore:"max_digits,omitempty"`
+	FinishOnKey string `json:"finish_on_key,omitempty" firestore:"finish_on_key,omitempty"`
+	Prompt      string `json:"prompt" firestore:"prompt"`
+	Attempts    int    `json:"attempts,omitempty" firestore:"attempts,omitempty"`
+}
+
+// SpeechConfirmation representa una transcripción de baja confianza pendiente de confirmación
+type SpeechConfirmation struct {
+	Text       string  `json:"text" firestore:"text"`
+	Confidence float64 `json:"confidence" firestore:"confidence"`
+}
+
+// SpeechStats acumula la confianza del reconocimiento de voz de la llamada para analítica
+type SpeechStats struct {
+	Turns                 int     `json:"turns,omitempty" firestore:"turns,omitempty"`
+	ConfidenceSum         float64 `json:"confidence_sum,omitempty" firestore:"confidence_sum,omitempty"`
+	ConfidenceMin         float64 `json:"confidence_min,omitempty" firestore:"confidence_min,omitempty"`
+	LowConfidenceTurns    int     `json:"low_confidence_turns,omitempty" firestore:"low_confidence_turns,omitempty"`
+	Confirmations         int     `json:"confirmations,omitempty" firestore:"confirmations,omitempty"`
+	ConfirmationsRejected int     `json:"confirmations_rejected,omitempty" firestore:"confirmations_rejected,omitempty"`
+}
+
+// ConferenceEvent representa un evento de la conferencia de una transferencia (entradas, salidas y supervisión)
+type ConferenceEvent struct {
+	Event              string    `json:"event" firestore:"event"`
+	ParticipantCallSid string    `json:"participant_call_sid,omitempty" firestore:"participant_call_sid,omitempty"`
+	Role               string    `json:"role,omitempty" firestore:"role,omitempty"`
+	Timestamp          time.Time `json:"timestamp" firestore:"timestamp"`
+}
+
+// TransferAttempt representa un intento de transferencia a un agente y su resultado según el <Dial>
+type TransferAttempt struct {
+	Number          string    `json:"number" firestore:"number"`
+	DialCallSid     string    `json:"dial_call_sid,omitempty" firestore:"dial_call_sid,omitempty"`
+	DialCallStatus  string    `json:"dial_call_status" firestore:"dial_call_status"`
+	DurationSeconds int       `json:"duration_seconds,omitempty" firestore:"duration_seconds,omitempty"`
+	Timestamp       time.Time `json:"timestamp" firestore:"timestamp"`
+}
+
+// HandoffContext representa el contexto de la conversación que recibe el escritorio del agente en una transferencia
+type HandoffContext struct {
+	CallSid          string                 `json:"call_sid"`
+	TenantID         string                 `json:"tenant_id"`
+	Reason           string                 `json:"reason,omitempty"`
+	Summary          string                 `json:"summary,omitempty"`
+	TransferNumber   string                 `json:"transfer_number,omitempty"`
+	IntentName       string                 `json:"intent_name,omitempty"`
+	Parameters       map[string]interface{} `json:"parameters,omitempty"`
+	CallerProfile    CallerProfile          `json:"caller_profile"`
+	Transcript       []TranscriptEntry      `json:"transcript"`
+	HandoffTimestamp *time.Time             `json:"handoff_timestamp,omitempty"`
+	TransferOutcome  string                 `json:"transfer_outcome,omitempty"`
+	TransferAttempts []TransferAttempt      `json:"transfer_attempts,omitempty"`
+	CallStatus       string                 `json:"call_status,omitempty"`
+}
+
+// CallerProfile representa lo que se sabe del cliente al momento de la transferencia
+type CallerProfile struct {
+	PhoneNumber          string            `json:"phone_number"`
+	Direction            string            `json:"direction"`
+	AnsweredBy           string            `json:"answered_by,omitempty"`
+	CampaignID           string            `json:"campaign_id,omitempty"`
+	ContactID            string            `json:"contact_id,omitempty"`
+	Variables            map[string]string `json:"variables,omitempty"`
+	CallbackID           string            `json:"callback_id,omitempty"`
+	OriginalCallSid      string            `json:"original_call_sid,omitempty"`
+	PreviousConversation []TranscriptEntry `json:"previous_conversation,omitempty"`
+}
+
+// CallbackContext representa el contexto restaurado en una devolución de llamada
+type CallbackContext struct {
+	CallbackID      string            `json:"callback_id" firestore:"callback_id"`
+	OriginalCallSid string            `json:"original_call_sid" firestore:"original_call_sid"`
+	Reason          string            `json:"reason,omitempty" firestore:"reason,omitempty"`
+	PreviousTurns   []TranscriptEntry `json:"previous_turns,omitempty" firestore:"previous_turns,omitempty"`
+}
+
+// CampaignContext representa el contexto de campaña de una llamada saliente
+type CampaignContext struct {
+	CampaignID string            `json:"campaign_id" firestore:"campaign_id"`
+	ContactID  string            `json:"contact_id" firestore:"contact_id"`
+	Variables  map[string]string `json:"variables,omitempty" firestore:"variables,omitempty"`
+	Greeting   string            `json:"greeting,omitempty" firestore:"greeting,omitempty"`
+	VoicemailMessage string      `json:"voicemail_message,omitempty" firestore:"voicemail_message,omitempty"`
 }
 
 // TranscriptEntry representa una entrada en la transcripción de una conversación
@@ -42,6 +190,8 @@ type TranscriptEntry struct {
 	Text       string    `json:"text" firestore:"text" bigquery:"text"`
 	Timestamp  time.Time `json:"timestamp" firestore:"timestamp" bigquery:"timestamp"`
 	Confidence float64   `json:"confidence,omitempty" firestore:"confidence,omitempty" bigquery:"confidence"`
+	// InputMode es la forma en que respondió el cliente: speech o dtmf
+	InputMode  string    `json:"input_mode,omitempty" firestore:"input_mode,omitempty" bigquery:"input_mode"`
 	Embedding  []float64 `json:"embedding,omitempty" firestore:"embedding,omitempty" bigquery:"embedding"`
 }
 
@@ -53,7 +203,9 @@ type DialogflowQueryResult struct {
 	IntentConfidence float64                `json:"intent_confidence,omitempty" firestore:"intent_confidence,omitempty" bigquery:"intent_confidence"`
 	Parameters       map[string]interface{} `json:"parameters,omitempty" firestore:"parameters,omitempty" bigquery:"parameters"`
 	PageID           string                 `json:"page_id,omitempty" firestore:"page_id,omitempty" bigquery:"page_id"`
+	MatchType        string                 `json:"match_type,omitempty" firestore:"match_type,omitempty" bigquery:"match_type"`
 	ResponseText     string                 `json:"response_text" firestore:"response_text"`
+	ResponseSSML     string                 `json:"response_ssml,omitempty" firestore:"response_ssml,omitempty"`
 	CustomPayload    map[string]interface{} `json:"custom_payload,omitempty" firestore:"custom_payload,omitempty"`
 }
 
@@ -63,6 +215,8 @@ type LiveAgentHandoffPayload struct {
 	TransferNumber string `json:"transferNumber"`
 	Reason         string `json:"reason"`
 	PreserveContext bool   `json:"preserveContext"`
+	AlternateNumbers []string `json:"alternateNumbers,omitempty"`
+	Queue           string `json:"queue,omitempty"`
 }
 
 // FullTranscriptPayload representa el payload completo para guardar en BigQuery
@@ -79,10 +233,176 @@ type FullTranscriptPayload struct {
 	HandoffOccurred   bool               `json:"handoff_occurred" bigquery:"handoff_occurred"`
 	HandoffReason     string             `json:"handoff_reason,omitempty" bigquery:"handoff_reason"`
 	HandoffTimestamp  *time.Time         `json:"handoff_timestamp,omitempty" bigquery:"handoff_timestamp"`
+	AnsweredBy        string             `json:"answered_by,omitempty" bigquery:"answered_by"`
+	MachineDetectionOutcome string       `json:"machine_detection_outcome,omitempty" bigquery:"machine_detection_outcome"`
+	TransferOutcome   string             `json:"transfer_outcome,omitempty" bigquery:"transfer_outcome"`
+	TransferDurationSeconds int          `json:"transfer_duration_seconds,omitempty" bigquery:"transfer_duration_seconds"`
+	LanguageCode      string             `json:"language_code,omitempty" bigquery:"language_code"`
+	DetectedLanguage  string             `json:"detected_language,omitempty" bigquery:"detected_language"`
+	NoInputCount      int                `json:"no_input_count" bigquery:"no_input_count"`
+	NoMatchCount      int                `json:"no_match_count" bigquery:"no_match_count"`
+	SpeechConfidenceAvg float64          `json:"speech_confidence_avg,omitempty" bigquery:"speech_confidence_avg"`
+	SpeechConfidenceMin float64          `json:"speech_confidence_min,omitempty" bigquery:"speech_confidence_min"`
+	LowConfidenceTurns  int              `json:"low_confidence_turns" bigquery:"low_confidence_turns"`
+	SpeechConfirmations int              `json:"speech_confirmations" bigquery:"speech_confirmations"`
+	SpeechConfirmationsRejected int      `json:"speech_confirmations_rejected" bigquery:"speech_confirmations_rejected"`
 	Embedding         []float64          `json:"embedding,omitempty" bigquery:"embedding"`
 	CreatedAt         time.Time          `json:"created_at" bigquery:"created_at"`
 }
 
+// OutboxEvent representa un evento del ciclo de vida de la conversación pendiente de publicar.
+// Se guarda en Firestore en la misma transacción que el ConversationState.
+type OutboxEvent struct {
+	ID            string     `json:"id" firestore:"id"`
+	CallSid       string     `json:"call_sid" firestore:"call_sid"`
+	TenantID      string     `json:"tenant_id" firestore:"tenant_id"`
+	EventType     string     `json:"event_type" firestore:"event_type"`
+	Data          []byte     `json:"data" firestore:"data"`
+	Status        string     `json:"status" firestore:"status"`
+	Attempts      int        `json:"attempts" firestore:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" firestore:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty" firestore:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" firestore:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty" firestore:"delivered_at,omitempty"`
+	Destination   string     `json:"destination,omitempty" firestore:"destination,omitempty"`
+}
+
+// OutboxMetrics representa el estado de la cola de salida de eventos
+type OutboxMetrics struct {
+	Pending           int64 `json:"pending"`
+	Failed            int64 `json:"failed"`
+	DeliveredTotal    int64 `json:"delivered_total"`
+	FailedAttempts    int64 `json:"failed_attempts_total"`
+	DeadLetteredTotal int64 `json:"dead_lettered_total"`
+}
+
+// CallingHours representa la ventana horaria en la que una campaña puede realizar llamadas
+type CallingHours struct {
+	Timezone  string `json:"timezone" firestore:"timezone"`
+	StartHour int    `json:"start_hour" firestore:"start_hour"`
+	EndHour   int    `json:"end_hour" firestore:"end_hour"`
+	Weekdays  []int  `json:"weekdays,omitempty" firestore:"weekdays,omitempty"`
+}
+
+// RoutingRule representa una regla de la tabla de enrutamiento de las transferencias a agentes.
+// Los criterios vacíos aceptan cualquier valor; Destinations se intentan en orden ("+56...", "sip:...", "client:<identidad>" o "queue:<cola>").
+type RoutingRule struct {
+	ID            string        `json:"id" firestore:"id"`
+	TenantID      string        `json:"tenant_id" firestore:"tenant_id"`
+	Description   string        `json:"description,omitempty" firestore:"description,omitempty"`
+	Priority      int           `json:"priority" firestore:"priority"`
+	Disabled      bool          `json:"disabled,omitempty" firestore:"disabled,omitempty"`
+	Intents       []string      `json:"intents,omitempty" firestore:"intents,omitempty"`
+	Languages     []string      `json:"languages,omitempty" firestore:"languages,omitempty"`
+	CustomerTiers []string      `json:"customer_tiers,omitempty" firestore:"customer_tiers,omitempty"`
+	Hours         *CallingHours `json:"hours,omitempty" firestore:"hours,omitempty"`
+	BusinessHours string        `json:"business_hours,omitempty" firestore:"business_hours,omitempty"`
+	Destinations  []string      `json:"destinations" firestore:"destinations"`
+}
+
+// SpeechVocabulary representa el vocabulario propio de un tenant para el reconocimiento de voz: nombres de
+// planes, códigos de productos, calles, etc. Boost es el peso de las frases en los modelos de Google que
+// admiten refuerzo de frases; 0 usa el peso predeterminado.
+type SpeechVocabulary struct {
+	TenantID string   `json:"tenant_id" firestore:"tenant_id"`
+	Phrases  []string `json:"phrases" firestore:"phrases"`
+	Boost    float64  `json:"boost,omitempty" firestore:"boost,omitempty"`
+}
+
+// BusinessHours representa el horario de atención de un tenant y cómo se atienden las llamadas fuera de él
+type BusinessHours struct {
+	TenantID           string           `json:"tenant_id" firestore:"tenant_id"`
+	Timezone           string           `json:"timezone,omitempty" firestore:"timezone,omitempty"`
+	Windows            []BusinessWindow `json:"windows,omitempty" firestore:"windows,omitempty"`
+	HolidayCalendar    string           `json:"holiday_calendar,omitempty" firestore:"holiday_calendar,omitempty"`
+	ClosedDates        []string         `json:"closed_dates,omitempty" firestore:"closed_dates,omitempty"`
+	AfterHoursGreeting string           `json:"after_hours_greeting,omitempty" firestore:"after_hours_greeting,omitempty"`
+	AfterHoursHandoff  string           `json:"after_hours_handoff,omitempty" firestore:"after_hours_handoff,omitempty"`
+}
+
+// BusinessWindow representa una ventana de atención "HH:MM"-"HH:MM" en los días indicados (0 es domingo).
+// Si End es anterior a Start, la ventana termina al día siguiente.
+type BusinessWindow struct {
+	Weekdays []int  `json:"weekdays,omitempty" firestore:"weekdays,omitempty"`
+	Start    string `json:"start" firestore:"start"`
+	End      string `json:"end" firestore:"end"`
+}
+
+// BusinessHoursStatus representa el horario de atención evaluado al inicio de la llamada
+type BusinessHoursStatus struct {
+	Open               bool   `json:"open" firestore:"open"`
+	Holiday            string `json:"holiday,omitempty" firestore:"holiday,omitempty"`
+	AfterHoursGreeting string `json:"after_hours_greeting,omitempty" firestore:"after_hours_greeting,omitempty"`
+	AfterHoursHandoff  string `json:"after_hours_handoff,omitempty" firestore:"after_hours_handoff,omitempty"`
+}
+
+// Campaign representa una campaña de llamadas salientes
+type Campaign struct {
+	ID                 string       `json:"id" firestore:"id"`
+	TenantID           string       `json:"tenant_id" firestore:"tenant_id"`
+	Name               string       `json:"name" firestore:"name"`
+	FromNumber         string       `json:"from_number" firestore:"from_number"`
+	GreetingTemplate   string       `json:"greeting_template,omitempty" firestore:"greeting_template,omitempty"`
+	VoicemailTemplate  string       `json:"voicemail_template,omitempty" firestore:"voicemail_template,omitempty"`
+	Status             string       `json:"status" firestore:"status"`
+	CallingHours       CallingHours `json:"calling_hours" firestore:"calling_hours"`
+	MaxConcurrentCalls int          `json:"max_concurrent_calls" firestore:"max_concurrent_calls"`
+	MaxAttempts        int          `json:"max_attempts" firestore:"max_attempts"`
+	RetryDelayMinutes  int          `json:"retry_delay_minutes" firestore:"retry_delay_minutes"`
+	CreatedAt          time.Time    `json:"created_at" firestore:"created_at"`
+	UpdatedAt          time.Time    `json:"updated_at" firestore:"updated_at"`
+}
+
+// CampaignContact representa un contacto de una campaña y el resultado de sus llamadas
+type CampaignContact struct {
+	ID                  string            `json:"id" firestore:"id"`
+	CampaignID          string            `json:"campaign_id" firestore:"campaign_id"`
+	TenantID            string            `json:"tenant_id" firestore:"tenant_id"`
+	PhoneNumber         string            `json:"phone_number" firestore:"phone_number"`
+	Variables           map[string]string `json:"variables,omitempty" firestore:"variables,omitempty"`
+	Status              string            `json:"status" firestore:"status"`
+	Attempts            int               `json:"attempts" firestore:"attempts"`
+	NextAttemptAt       time.Time         `json:"next_attempt_at" firestore:"next_attempt_at"`
+	DialedAt            time.Time         `json:"dialed_at,omitempty" firestore:"dialed_at,omitempty"`
+	CallSid             string            `json:"call_sid,omitempty" firestore:"call_sid,omitempty"`
+	LastCallStatus      string            `json:"last_call_status,omitempty" firestore:"last_call_status,omitempty"`
+	AnsweredBy          string            `json:"answered_by,omitempty" firestore:"answered_by,omitempty"`
+	Outcome             string            `json:"outcome,omitempty" firestore:"outcome,omitempty"`
+	Disposition         string            `json:"disposition,omitempty" firestore:"disposition,omitempty"`
+	CallDurationSeconds int               `json:"call_duration_seconds,omitempty" firestore:"call_duration_seconds,omitempty"`
+	LastError           string            `json:"last_error,omitempty" firestore:"last_error,omitempty"`
+	CreatedAt           time.Time         `json:"created_at" firestore:"created_at"`
+	UpdatedAt           time.Time         `json:"updated_at" firestore:"updated_at"`
+}
+
+// CallbackRequest representa una devolución de llamada programada cuando no hubo agentes disponibles
+type CallbackRequest struct {
+	ID              string            `json:"id" firestore:"id"`
+	TenantID        string            `json:"tenant_id" firestore:"tenant_id"`
+	OriginalCallSid string            `json:"original_call_sid" firestore:"original_call_sid"`
+	PhoneNumber     string            `json:"phone_number" firestore:"phone_number"`
+	FromNumber      string            `json:"from_number" firestore:"from_number"`
+	Reason          string            `json:"reason,omitempty" firestore:"reason,omitempty"`
+	ContextTurns    []TranscriptEntry `json:"context_turns,omitempty" firestore:"context_turns,omitempty"`
+	ScheduledAt     time.Time         `json:"scheduled_at" firestore:"scheduled_at"`
+	Status          string            `json:"status" firestore:"status"`
+	Attempts        int               `json:"attempts" firestore:"attempts"`
+	NextAttemptAt   time.Time         `json:"next_attempt_at" firestore:"next_attempt_at"`
+	DialedAt        time.Time         `json:"dialed_at,omitempty" firestore:"dialed_at,omitempty"`
+	CallSid         string            `json:"call_sid,omitempty" firestore:"call_sid,omitempty"`
+	LastCallStatus  string            `json:"last_call_status,omitempty" firestore:"last_call_status,omitempty"`
+	AnsweredBy      string            `json:"answered_by,omitempty" firestore:"answered_by,omitempty"`
+	LastError       string            `json:"last_error,omitempty" firestore:"last_error,omitempty"`
+	CreatedAt       time.Time         `json:"created_at" firestore:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at" firestore:"updated_at"`
+}
+
+// CampaignSummary representa el estado de una campaña con el número de contactos por estado
+type CampaignSummary struct {
+	Campaign *Campaign       `json:"campaign"`
+	Contacts map[string]int64 `json:"contacts"`
+}
+
 // VectorSearchMatch representa un resultado de búsqueda de Vector Search
 type VectorSearchMatch struct {
 	ID        string                 `json:"id"`
@@ -107,16 +427,39 @@ type VectorSearchResponse struct {
 type TwiMLResponse struct {
 	XMLName struct{} `xml:"Response"`
 	Say     *TwiMLSay `xml:"Say,omitempty"`
+	Play    *TwiMLPlay `xml:"Play,omitempty"`
 	Gather  *TwiMLGather `xml:"Gather,omitempty"`
 	Dial    *TwiMLDial `xml:"Dial,omitempty"`
+	Enqueue *TwiMLEnqueue `xml:"Enqueue,omitempty"`
+	Record  *TwiMLRecord `xml:"Record,omitempty"`
+	Leave   *TwiMLLeave `xml:"Leave,omitempty"`
 	Hangup  *TwiMLHangup `xml:"Hangup,omitempty"`
 }
 
-// TwiMLSay representa el elemento Say de TwiML
+// TwiMLPlay representa el elemento Play de TwiML
+type TwiMLPlay struct {
+	Loop  string `xml:"loop,attr,omitempty"`
+	URL   string `xml:",chardata"`
+}
+
+// TwiMLEnqueue representa el elemento Enqueue de TwiML
+type TwiMLEnqueue struct {
+	Action        string `xml:"action,attr,omitempty"`
+	Method        string `xml:"method,attr,omitempty"`
+	WaitURL       string `xml:"waitUrl,attr,omitempty"`
+	WaitURLMethod string `xml:"waitUrlMethod,attr,omitempty"`
+	Name          string `xml:",chardata"`
+}
+
+// TwiMLLeave representa el elemento Leave de TwiML, que saca al cliente de la cola
+type TwiMLLeave struct{}
+
+// TwiMLSay representa el elemento Say de TwiML. Si SSML tiene un documento <speak>, se lee en lugar de Value.
 type TwiMLSay struct {
 	Voice    string `xml:"voice,attr,omitempty"`
 	Language string `xml:"language,attr,omitempty"`
 	Value    string `xml:",chardata"`
+	SSML     string `xml:"-"`
 }
 
 // TwiMLGather representa el elemento Gather de TwiML
@@ -124,12 +467,15 @@ type TwiMLGather struct {
 	Input         string `xml:"input,attr"`
 	Timeout       string `xml:"timeout,attr,omitempty"`
 	NumDigits     string `xml:"numDigits,attr,omitempty"`
+	FinishOnKey   string `xml:"finishOnKey,attr,omitempty"`
 	Action        string `xml:"action,attr,omitempty"`
 	Method        string `xml:"method,attr,omitempty"`
 	Language      string `xml:"language,attr,omitempty"`
 	Hints         string `xml:"hints,attr,omitempty"`
+	SpeechModel   string `xml:"speechModel,attr,omitempty"`
 	ProfanityFilter string `xml:"profanityFilter,attr,omitempty"`
 	SpeechTimeout string `xml:"speechTimeout,attr,omitempty"`
+	ActionOnEmptyResult string `xml:"actionOnEmptyResult,attr,omitempty"`
 	Say           *TwiMLSay `xml:"Say,omitempty"`
 }
 
@@ -140,7 +486,70 @@ type TwiMLDial struct {
 	Timeout     string `xml:"timeout,attr,omitempty"`
 	CallerId    string `xml:"callerId,attr,omitempty"`
 	Record      string `xml:"record,attr,omitempty"`
-	Number      string `xml:",chardata"`
+	Number      *TwiMLNumber `xml:"Number,omitempty"`
+	Conference  *TwiMLConference `xml:"Conference,omitempty"`
+	Queue       *TwiMLQueue `xml:"Queue,omitempty"`
+	Sip         *TwiMLSip `xml:"Sip,omitempty"`
+	Client      *TwiMLClient `xml:"Client,omitempty"`
+}
+
+// TwiMLSip representa el elemento Sip de TwiML, que conecta con una URI SIP.
+// Las cabeceras personalizadas (X-...) viajan como parámetros de la URI: "sip:agente@pbx?X-Call-Sid=CA...".
+type TwiMLSip struct {
+	URL      string `xml:"url,attr,omitempty"`
+	Method   string `xml:"method,attr,omitempty"`
+	Username string `xml:"username,attr,omitempty"`
+	Password string `xml:"password,attr,omitempty"`
+	URI      string `xml:",chardata"`
+}
+
+// TwiMLClient representa el elemento Client de TwiML, que conecta con un agente de Twilio Voice SDK
+type TwiMLClient struct {
+	URL        string           `xml:"url,attr,omitempty"`
+	Method     string           `xml:"method,attr,omitempty"`
+	Identity   string           `xml:"Identity"`
+	Parameters []TwiMLParameter `xml:"Parameter,omitempty"`
+}
+
+// TwiMLParameter representa un parámetro personalizado que recibe el agente de un Client
+type TwiMLParameter struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// TwiMLQueue representa el elemento Queue de TwiML, que conecta al agente con el primero de la cola
+type TwiMLQueue struct {
+	URL    string `xml:"url,attr,omitempty"`
+	Method string `xml:"method,attr,omitempty"`
+	Name   string `xml:",chardata"`
+}
+
+// TwiMLConference representa el elemento Conference de TwiML
+type TwiMLConference struct {
+	StartConferenceOnEnter string `xml:"startConferenceOnEnter,attr,omitempty"`
+	EndConferenceOnExit    string `xml:"endConferenceOnExit,attr,omitempty"`
+	Beep                   string `xml:"beep,attr,omitempty"`
+	WaitURL                string `xml:"waitUrl,attr,omitempty"`
+	StatusCallback         string `xml:"statusCallback,attr,omitempty"`
+	StatusCallbackMethod   string `xml:"statusCallbackMethod,attr,omitempty"`
+	StatusCallbackEvent    string `xml:"statusCallbackEvent,attr,omitempty"`
+	Name                   string `xml:",chardata"`
+}
+
+// TwiMLNumber representa el elemento Number de TwiML. Si tiene url, el agente escucha ese TwiML antes de conectarse.
+type TwiMLNumber struct {
+	URL    string `xml:"url,attr,omitempty"`
+	Method string `xml:"method,attr,omitempty"`
+	Value  string `xml:",chardata"`
+}
+
+// TwiMLRecord representa el elemento Record de TwiML
+type TwiMLRecord struct {
+	Action      string `xml:"action,attr,omitempty"`
+	Method      string `xml:"method,attr,omitempty"`
+	MaxLength   string `xml:"maxLength,attr,omitempty"`
+	PlayBeep    string `xml:"playBeep,attr,omitempty"`
+	FinishOnKey string `xml:"finishOnKey,attr,omitempty"`
 }
 
 // TwiMLHangup representa el elemento Hangup de TwiML
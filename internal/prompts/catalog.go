@@ -0,0 +1,155 @@
+package prompts
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// builtinFiles son los catálogos incluidos en el binario, uno por idioma
+//
+//go:embed locales/*.json
+var builtinFiles embed.FS
+
+// variablePattern reconoce las variables {{nombre}} de las plantillas
+var variablePattern = regexp.MustCompile(`{{\s*([A-Za-z0-9_]+)\s*}}`)
+
+// Catalog son las plantillas de los mensajes por idioma: idioma → ID del mensaje → plantilla
+type Catalog map[string]map[string]string
+
+// Builtin devuelve el catálogo incluido en el binario
+func Builtin() Catalog {
+	catalog, err := load(builtinFiles, "locales")
+	if err != nil {
+		// Los archivos incluidos son parte del código: un error aquí es un error de programación
+		panic(err)
+	}
+	return catalog
+}
+
+// LoadDir carga los archivos <idioma>.json de un directorio, cada uno con un objeto {"id": "plantilla"}.
+// Un directorio que no existe es un catálogo vacío.
+func LoadDir(dir string) (Catalog, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return Catalog{}, nil
+	}
+	return load(os.DirFS(dir), ".")
+}
+
+// load lee los archivos .json de un directorio de un sistema de archivos
+func load(fsys fs.FS, dir string) (Catalog, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error al leer el directorio de mensajes: %v", err)
+	}
+
+	catalog := Catalog{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, filepath.ToSlash(filepath.Join(dir, entry.Name())))
+		if err != nil {
+			return nil, fmt.Errorf("error al leer los mensajes %s: %v", entry.Name(), err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("error al parsear los mensajes %s: %v", entry.Name(), err)
+		}
+		catalog[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+	return catalog, nil
+}
+
+// Overlay devuelve un catálogo con los mensajes de c reemplazados por los de override
+func (c Catalog) Overlay(override Catalog) Catalog {
+	merged := Catalog{}
+	for _, catalog := range []Catalog{c, override} {
+		for locale, messages := range catalog {
+			if merged[locale] == nil {
+				merged[locale] = map[string]string{}
+			}
+			for id, template := range messages {
+				merged[locale][id] = template
+			}
+		}
+	}
+	return merged
+}
+
+// Lookup busca la plantilla de un mensaje en el primer idioma de la cadena que la tenga y devuelve
+// también ese idioma
+func (c Catalog) Lookup(id string, chain []string) (string, string, bool) {
+	for _, locale := range chain {
+		if template, ok := c[locale][id]; ok && template != "" {
+			return template, locale, true
+		}
+	}
+	return "", "", false
+}
+
+// Chain devuelve el orden en que se buscan los mensajes de un idioma: el idioma, los demás idiomas del
+// catálogo con la misma lengua (es-CL → es, es-MX) y luego los idiomas de respaldo
+func (c Catalog) Chain(locale string, fallbacks []string) []string {
+	chain := []string{locale}
+	seen := map[string]bool{locale: true}
+	add := func(locale string) {
+		if locale != "" && !seen[locale] {
+			seen[locale] = true
+			chain = append(chain, locale)
+		}
+	}
+
+	language := Language(locale)
+	add(language)
+	var related []string
+	for candidate := range c {
+		if Language(candidate) == language {
+			related = append(related, candidate)
+		}
+	}
+	sort.Strings(related)
+	for _, candidate := range related {
+		add(candidate)
+	}
+
+	for _, fallback := range fallbacks {
+		add(fallback)
+	}
+	return chain
+}
+
+// Language devuelve la lengua de un código de idioma: "es-CL" → "es"
+func Language(locale string) string {
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		return strings.ToLower(locale[:i])
+	}
+	return strings.ToLower(locale)
+}
+
+// Render reemplaza las variables {{nombre}} de una plantilla. Las variables sin valor se eliminan.
+func Render(template string, vars map[string]string) string {
+	return variablePattern.ReplaceAllStringFunc(template, func(match string) string {
+		return vars[variablePattern.FindStringSubmatch(match)[1]]
+	})
+}
+
+// Variables devuelve las variables que usa una plantilla, ordenadas
+func Variables(template string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, match := range variablePattern.FindAllStringSubmatch(template, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
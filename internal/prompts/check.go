@@ -0,0 +1,80 @@
+package prompts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tipos de problema que informa Check
+const (
+	IssueMissing   = "missing"
+	IssueVariables = "variables"
+	IssueUnknown   = "unknown"
+)
+
+// Issue es un problema de traducción de un catálogo
+type Issue struct {
+	Locale  string
+	ID      string
+	Kind    string
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s %s [%s]: %s", i.Locale, i.ID, i.Kind, i.Message)
+}
+
+// Check revisa que cada idioma del catálogo traduzca todos los mensajes ids, con las mismas variables
+// que el idioma de referencia, y que no defina mensajes que el servicio no usa
+func (c Catalog) Check(reference string, ids []string) []Issue {
+	known := map[string]bool{}
+	for _, id := range ids {
+		known[id] = true
+	}
+
+	locales := make([]string, 0, len(c))
+	for locale := range c {
+		locales = append(locales, locale)
+	}
+	if c[reference] == nil {
+		locales = append(locales, reference)
+	}
+	sort.Strings(locales)
+
+	var issues []Issue
+	for _, locale := range locales {
+		messages := c[locale]
+		for _, id := range ids {
+			template := messages[id]
+			if template == "" {
+				issues = append(issues, Issue{Locale: locale, ID: id, Kind: IssueMissing, Message: "falta la traducción"})
+				continue
+			}
+			if locale == reference || c[reference][id] == "" {
+				continue
+			}
+			want, got := Variables(c[reference][id]), Variables(template)
+			if strings.Join(want, ",") != strings.Join(got, ",") {
+				issues = append(issues, Issue{
+					Locale:  locale,
+					ID:      id,
+					Kind:    IssueVariables,
+					Message: fmt.Sprintf("usa las variables [%s] y %s usa [%s]", strings.Join(got, ", "), reference, strings.Join(want, ", ")),
+				})
+			}
+		}
+
+		var unknown []string
+		for id := range messages {
+			if !known[id] {
+				unknown = append(unknown, id)
+			}
+		}
+		sort.Strings(unknown)
+		for _, id := range unknown {
+			issues = append(issues, Issue{Locale: locale, ID: id, Kind: IssueUnknown, Message: "el servicio no usa este mensaje"})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,99 @@
+package prompts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultTenant es el directorio con los mensajes comunes a todos los tenants
+const DefaultTenant = "default"
+
+// Store entrega los mensajes de cada tenant. Los catálogos se leen de <dir>/<tenant>/<idioma>.json; un
+// tenant hereda los mensajes que no define del directorio default y, por último, del catálogo incluido.
+type Store struct {
+	dir       string
+	fallbacks []string
+
+	mu       sync.Mutex
+	catalogs map[string]Catalog
+}
+
+// NewStore crea el almacén de mensajes de un directorio. Con dir vacío solo se usa el catálogo incluido.
+// fallbacks son los idiomas que se prueban cuando un mensaje no existe en el idioma de la llamada ni en su lengua.
+func NewStore(dir string, fallbacks []string) *Store {
+	return &Store{dir: dir, fallbacks: fallbacks, catalogs: map[string]Catalog{}}
+}
+
+// Catalog devuelve el catálogo de un tenant, que se lee la primera vez y se conserva en memoria
+func (s *Store) Catalog(tenant string) (Catalog, error) {
+	if !validTenant(tenant) {
+		tenant = DefaultTenant
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if catalog, ok := s.catalogs[tenant]; ok {
+		return catalog, nil
+	}
+
+	catalog := Builtin()
+	if s.dir != "" {
+		tenants := []string{DefaultTenant}
+		if tenant != DefaultTenant {
+			tenants = append(tenants, tenant)
+		}
+		for _, name := range tenants {
+			override, err := LoadDir(filepath.Join(s.dir, name))
+			if err != nil {
+				return nil, fmt.Errorf("error al cargar los mensajes del tenant %s: %v", name, err)
+			}
+			catalog = catalog.Overlay(override)
+		}
+	}
+	s.catalogs[tenant] = catalog
+	return catalog, nil
+}
+
+// Text devuelve el mensaje de un tenant en un idioma, con las variables reemplazadas
+func (s *Store) Text(tenant, locale, id string, vars map[string]string) (string, error) {
+	catalog, err := s.Catalog(tenant)
+	if err != nil {
+		return "", err
+	}
+	template, _, ok := catalog.Lookup(id, catalog.Chain(locale, s.fallbacks))
+	if !ok {
+		return "", fmt.Errorf("el mensaje %s no existe en %s ni en sus idiomas de respaldo", id, locale)
+	}
+	return Render(template, vars), nil
+}
+
+// Tenants devuelve los tenants con mensajes propios en el directorio, incluido default
+func (s *Store) Tenants() ([]string, error) {
+	tenants := []string{DefaultTenant}
+	if s.dir == "" {
+		return tenants, nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return tenants, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error al leer el directorio de mensajes: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != DefaultTenant && validTenant(entry.Name()) {
+			tenants = append(tenants, entry.Name())
+		}
+	}
+	sort.Strings(tenants[1:])
+	return tenants, nil
+}
+
+// validTenant descarta los identificadores de tenant que no pueden ser un directorio
+func validTenant(tenant string) bool {
+	return tenant != "" && !strings.ContainsAny(tenant, `/\`) && !strings.HasPrefix(tenant, ".")
+}
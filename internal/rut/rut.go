@@ -0,0 +1,126 @@
+package rut
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxBody es el cuerpo más largo de un RUT: 99.999.999
+const maxBody = 99999999
+
+// RUT es un Rol Único Tributario chileno: el cuerpo numérico y el dígito verificador ("0" a "9" o "K")
+type RUT struct {
+	Body     int
+	Verifier string
+}
+
+// String devuelve el RUT en la forma canónica, sin puntos y con guion: "12345678-5"
+func (r RUT) String() string {
+	return fmt.Sprintf("%d-%s", r.Body, r.Verifier)
+}
+
+// Valid indica si el dígito verificador corresponde al cuerpo
+func (r RUT) Valid() bool {
+	return r.Body > 0 && r.Body <= maxBody && r.Verifier == VerifierDigit(r.Body)
+}
+
+// VerifierDigit calcula el dígito verificador de un cuerpo con el algoritmo módulo 11: los dígitos, de
+// derecha a izquierda, se multiplican por la serie 2, 3, 4, 5, 6, 7, 2, 3, ... y 11 menos el resto de la
+// suma es el dígito; 11 es "0" y 10 es "K"
+func VerifierDigit(body int) string {
+	sum, factor := 0, 2
+	for ; body > 0; body /= 10 {
+		sum += body % 10 * factor
+		factor++
+		if factor > 7 {
+			factor = 2
+		}
+	}
+	switch digit := 11 - sum%11; digit {
+	case 11:
+		return "0"
+	case 10:
+		return "K"
+	default:
+		return strconv.Itoa(digit)
+	}
+}
+
+// Parse lee un RUT escrito con o sin puntos y guion ("12.345.678-5", "12345678-5" o "123456785"). No
+// valida el dígito verificador; ver Valid.
+func Parse(value string) (RUT, error) {
+	compact := strings.ToUpper(strings.NewReplacer(".", "", "-", "", " ", "").Replace(value))
+	if len(compact) < 2 {
+		return RUT{}, fmt.Errorf("RUT incompleto: %q", value)
+	}
+	return split(compact[:len(compact)-1], compact[len(compact)-1:])
+}
+
+// FromDTMF lee un RUT marcado en el teclado: todos los dígitos, con el dígito verificador al final. El
+// dígito verificador K se marca con la tecla kKey (por ejemplo "*").
+func FromDTMF(digits, kKey string) (RUT, error) {
+	if len(digits) < 2 {
+		return RUT{}, fmt.Errorf("RUT incompleto: %q", digits)
+	}
+	verifier := digits[len(digits)-1:]
+	if kKey != "" && verifier == kKey {
+		verifier = "K"
+	}
+	return split(digits[:len(digits)-1], verifier)
+}
+
+// split arma un RUT a partir del cuerpo y el dígito verificador escritos
+func split(body, verifier string) (RUT, error) {
+	number, err := strconv.Atoi(body)
+	if err != nil || number <= 0 || number > maxBody || strings.Trim(body, "0123456789") != "" {
+		return RUT{}, fmt.Errorf("cuerpo de RUT inválido: %q", body)
+	}
+	if strings.Trim(verifier, "0123456789K") != "" {
+		return RUT{}, fmt.Errorf("dígito verificador inválido: %q", verifier)
+	}
+	return RUT{Body: number, Verifier: verifier}, nil
+}
+
+// Format devuelve el RUT con puntos y guion: "12.345.678-5"
+func (r RUT) Format() string {
+	body := strconv.Itoa(r.Body)
+	var formatted strings.Builder
+	for i, digit := range body {
+		if i > 0 && (len(body)-i)%3 == 0 {
+			formatted.WriteByte('.')
+		}
+		formatted.WriteRune(digit)
+	}
+	return formatted.String() + "-" + r.Verifier
+}
+
+// Normalize lee un RUT escrito de cualquier forma y lo devuelve en la forma canónica ("12345678-5").
+// Devuelve error si el RUT no se puede leer o su dígito verificador no corresponde.
+func Normalize(value string) (string, error) {
+	parsed, err := Parse(value)
+	if err != nil {
+		return "", err
+	}
+	if !parsed.Valid() {
+		return "", fmt.Errorf("dígito verificador incorrecto: %s", parsed)
+	}
+	return parsed.String(), nil
+}
+
+// Mask oculta un RUT para los registros y la transcripción: conserva los tres últimos dígitos del cuerpo y
+// el dígito verificador ("**.***.678-5"). Un valor que no es un RUT se oculta salvo sus tres últimos caracteres.
+func Mask(value string) string {
+	visible := 3
+	masked := []rune(value)
+	if parsed, err := Parse(value); err == nil {
+		masked = []rune(parsed.Format())
+		visible = 5
+	}
+	for i := 0; i < len(masked)-visible; i++ {
+		if masked[i] != '.' && masked[i] != '-' {
+			masked[i] = '*'
+		}
+	}
+	return string(masked)
+}
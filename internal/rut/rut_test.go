@@ -0,0 +1,287 @@
+package rut
+
+import "testing"
+
+func TestVerifierDigit(t *testing.T) {
+	tests := []struct {
+		body int
+		want string
+	}{
+		{body: 12345678, want: "5"},
+		{body: 11111111, want: "1"},
+		{body: 7654321, want: "6"},
+		{body: 1, want: "9"},
+		{body: 99999999, want: "9"},
+		{body: 6000000, want: "K"},
+		{body: 10000013, want: "K"},
+		{body: 10000004, want: "0"},
+		{body: 12000002, want: "0"},
+	}
+
+	for _, tt := range tests {
+		if got := VerifierDigit(tt.body); got != tt.want {
+			t.Errorf("VerifierDigit(%d) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		valid   bool
+		wantErr bool
+	}{
+		{value: "12.345.678-5", want: "12345678-5", valid: true},
+		{value: "12345678-5", want: "12345678-5", valid: true},
+		{value: "123456785", want: "12345678-5", valid: true},
+		{value: " 6.000.000-k ", want: "6000000-K", valid: true},
+		{value: "10000004-0", want: "10000004-0", valid: true},
+		{value: "12.345.678-4", want: "12345678-4", valid: false},
+		{value: "6000000-0", want: "6000000-0", valid: false},
+		{value: "", wantErr: true},
+		{value: "5", wantErr: true},
+		{value: "0-0", wantErr: true},
+		{value: "123.456.789-0", wantErr: true},
+		{value: "12.345.678-X", wantErr: true},
+		{value: "12a45678-5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := Parse(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %s, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.value, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+			if got.Valid() != tt.valid {
+				t.Errorf("Parse(%q).Valid() = %v, want %v", tt.value, got.Valid(), tt.valid)
+			}
+		})
+	}
+}
+
+func TestFromDTMF(t *testing.T) {
+	tests := []struct {
+		name    string
+		digits  string
+		kKey    string
+		want    string
+		wantErr bool
+	}{
+		{name: "digito", digits: "123456785", kKey: "*", want: "12345678-5"},
+		{name: "cero", digits: "100000040", kKey: "*", want: "10000004-0"},
+		{name: "tecla K", digits: "6000000*", kKey: "*", want: "6000000-K"},
+		{name: "otra tecla K", digits: "100000139", kKey: "9", want: "10000013-K"},
+		{name: "tecla K no configurada", digits: "6000000*", kKey: "", wantErr: true},
+		{name: "tecla distinta a la K", digits: "6000000#", kKey: "*", wantErr: true},
+		{name: "muy corto", digits: "5", kKey: "*", wantErr: true},
+		{name: "vacio", digits: "", kKey: "*", wantErr: true},
+		{name: "muy largo", digits: "1234567890", kKey: "*", wantErr: true},
+		{name: "tecla K en el cuerpo", digits: "6*000005", kKey: "*", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromDTMF(tt.digits, tt.kKey)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("FromDTMF(%q, %q) = %s, want error", tt.digits, tt.kKey, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromDTMF(%q, %q) error = %v", tt.digits, tt.kKey, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("FromDTMF(%q, %q) = %s, want %s", tt.digits, tt.kKey, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "12.345.678-5", want: "12345678-5"},
+		{value: "6000000k", want: "6000000-K"},
+		{value: "12.345.678-4", wantErr: true},
+		{value: "no es un rut", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Normalize(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Normalize(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		rut  RUT
+		want string
+	}{
+		{rut: RUT{Body: 12345678, Verifier: "5"}, want: "12.345.678-5"},
+		{rut: RUT{Body: 6000000, Verifier: "K"}, want: "6.000.000-K"},
+		{rut: RUT{Body: 123, Verifier: "6"}, want: "123-6"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rut.Format(); got != tt.want {
+			t.Errorf("Format(%s) = %q, want %q", tt.rut, got, tt.want)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "12.345.678-5", want: "**.***.678-5"},
+		{value: "123456785", want: "**.***.678-5"},
+		{value: "6000000-K", want: "*.***.000-K"},
+		{value: "abcdef", want: "***def"},
+		{value: "ab", want: "ab"},
+	}
+
+	for _, tt := range tests {
+		if got := Mask(tt.value); got != tt.want {
+			t.Errorf("Mask(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseSpoken(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "en palabras",
+			text: "doce millones trescientos cuarenta y cinco mil seiscientos setenta y ocho guion cinco",
+			want: "12345678-5",
+		},
+		{
+			name: "centenas separadas",
+			text: "mi RUT es doce millones tres cientos cuarenta y cinco mil seiscientos setenta y ocho raya cinco",
+			want: "12345678-5",
+		},
+		{
+			name: "por grupos",
+			text: "doce trescientos cuarenta y cinco seiscientos setenta y ocho guion K",
+			want: "12345678-K",
+		},
+		{
+			name: "digito a digito",
+			text: "uno dos tres cuatro cinco seis siete ocho cinco",
+			want: "12345678-5",
+		},
+		{
+			name: "digito a digito con ceros",
+			text: "uno cero cero cero cero cero cero cuatro guion cero",
+			want: "10000004-0",
+		},
+		{
+			name: "guion ka",
+			text: "seis millones guion ka",
+			want: "6000000-K",
+		},
+		{
+			name: "millones y unidades",
+			text: "diez millones trece guion ca",
+			want: "10000013-K",
+		},
+		{
+			name: "verificador cero",
+			text: "doce millones dos guion cero",
+			want: "12000002-0",
+		},
+		{
+			name: "K al final sin separador",
+			text: "seis millones k",
+			want: "6000000-K",
+		},
+		{
+			name: "en cifras",
+			text: "12.345.678-5",
+			want: "12345678-5",
+		},
+		{
+			name: "cifras y guion ka",
+			text: "12345678 guion ka",
+			want: "12345678-K",
+		},
+		{
+			name: "cifras con K pegada",
+			text: "12345678k",
+			want: "12345678-K",
+		},
+		{
+			name: "cifras y palabras",
+			text: "12 345 seiscientos setenta y ocho guion 5",
+			want: "12345678-5",
+		},
+		{
+			name: "tildes",
+			text: "dieciséis millones veintidós mil guion siete",
+			want: "16022000-7",
+		},
+		{
+			name:    "sin verificador",
+			text:    "doce millones guion",
+			wantErr: true,
+		},
+		{
+			name:    "incompleto",
+			text:    "cinco",
+			wantErr: true,
+		},
+		{
+			name:    "sin numeros",
+			text:    "no me acuerdo",
+			wantErr: true,
+		},
+		{
+			name:    "cuerpo muy largo",
+			text:    "uno dos tres cuatro cinco seis siete ocho nueve guion cinco",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSpoken(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSpoken(%q) = %s, want error", tt.text, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSpoken(%q) error = %v", tt.text, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("ParseSpoken(%q) = %s, want %s", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package rut
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// numberWords son los números en palabras que forman un RUT dicho en voz alta, sin tildes
+var numberWords = map[string]int{
+	"cero": 0, "uno": 1, "un": 1, "una": 1, "dos": 2, "tres": 3, "cuatro": 4, "cinco": 5, "seis": 6,
+	"siete": 7, "ocho": 8, "nueve": 9, "diez": 10, "once": 11, "doce": 12, "trece": 13, "catorce": 14,
+	"quince": 15, "dieciseis": 16, "diecisiete": 17, "dieciocho": 18, "diecinueve": 19, "veinte": 20,
+	"veintiuno": 21, "veintiun": 21, "veintidos": 22, "veintitres": 23, "veinticuatro": 24,
+	"veinticinco": 25, "veintiseis": 26, "veintisiete": 27, "veintiocho": 28, "veintinueve": 29,
+	"treinta": 30, "cuarenta": 40, "cincuenta": 50, "sesenta": 60, "setenta": 70, "ochenta": 80,
+	"noventa": 90, "cien": 100, "ciento": 100, "doscientos": 200, "trescientos": 300,
+	"cuatrocientos": 400, "quinientos": 500, "seiscientos": 600, "setecientos": 700, "ochocientos": 800,
+	"novecientos": 900,
+}
+
+// separatorWords separan el cuerpo del dígito verificador ("doce millones ... guion cinco")
+var separatorWords = map[string]bool{"guion": true, "raya": true, "menos": true, "verificador": true}
+
+// kWords son las formas en que se transcribe el dígito verificador K
+var kWords = map[string]bool{"k": true, "ka": true, "ca": true}
+
+// ParseSpoken lee un RUT dicho en voz alta tal como lo transcribe el reconocimiento de voz, en cualquiera de
+// las formas habituales:
+//   - en palabras: "doce millones trescientos cuarenta y cinco mil seiscientos setenta y ocho guion cinco"
+//     (también "tres cientos" separado)
+//   - por grupos: "doce trescientos cuarenta y cinco seiscientos setenta y ocho guion K"
+//   - dígito a dígito: "uno dos tres cuatro cinco seis siete ocho cinco"
+//   - en cifras: "12.345.678-5" o "12345678 guion ka"
+//
+// Sin separador ("guion", "raya", ...), el último dígito es el verificador. Las palabras que no son
+// números ("mi RUT es ...") se ignoran. No valida el dígito verificador; ver Valid.
+func ParseSpoken(text string) (RUT, error) {
+	tokens := spokenTokens(text)
+
+	separator := -1
+	for i, token := range tokens {
+		if separatorWords[token] {
+			separator = i
+		}
+	}
+
+	if separator >= 0 {
+		body, verifier := spokenDigits(tokens[:separator]), spokenVerifier(tokens[separator+1:])
+		if verifier == "" {
+			return RUT{}, fmt.Errorf("falta el dígito verificador en %q", text)
+		}
+		return split(body, verifier)
+	}
+
+	// Sin separador el verificador es el último dígito, o una K al final
+	if len(tokens) > 0 && kWords[tokens[len(tokens)-1]] {
+		return split(spokenDigits(tokens[:len(tokens)-1]), "K")
+	}
+	digits := spokenDigits(tokens)
+	if len(digits) < 2 {
+		return RUT{}, fmt.Errorf("RUT incompleto en %q", text)
+	}
+	return split(digits[:len(digits)-1], digits[len(digits)-1:])
+}
+
+// spokenTokens separa el texto en palabras en minúsculas y sin tildes. El guion se convierte en la palabra
+// "guion" y los puntos de las cifras se eliminan.
+func spokenTokens(text string) []string {
+	text = strings.NewReplacer("-", " guion ", ".", "", "á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u").
+		Replace(strings.ToLower(text))
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var tokens []string
+	for _, field := range fields {
+		// "12345678k" en una sola palabra
+		if len(field) > 1 && strings.HasSuffix(field, "k") && strings.Trim(field[:len(field)-1], "0123456789") == "" {
+			tokens = append(tokens, field[:len(field)-1], "guion", "k")
+			continue
+		}
+		tokens = append(tokens, field)
+	}
+	return tokens
+}
+
+// spokenDigits convierte en dígitos la parte del texto con el cuerpo del RUT. Los números en palabras se
+// agrupan como en castellano ("trescientos cuarenta y cinco" → 345) y cada grupo que no continúa al
+// anterior ("doce" "trescientos...") se escribe a continuación, igual que las cifras.
+func spokenDigits(tokens []string) string {
+	var digits strings.Builder
+	var group spokenNumber
+	flush := func() {
+		if group.words > 0 {
+			digits.WriteString(group.String())
+		}
+		group = spokenNumber{}
+	}
+
+	for _, token := range tokens {
+		switch {
+		case strings.Trim(token, "0123456789") == "":
+			flush()
+			digits.WriteString(token)
+		case token == "mil":
+			if !group.addThousand() {
+				flush()
+				group.addThousand()
+			}
+		case token == "millon" || token == "millones":
+			if !group.addMillion() {
+				flush()
+				group.addMillion()
+			}
+		case token == "cientos":
+			if !group.addHundreds() {
+				flush()
+			}
+		default:
+			value, ok := numberWords[token]
+			if !ok {
+				// "y", "mi", "rut", "es", ...
+				continue
+			}
+			if !group.add(value) {
+				flush()
+				group.add(value)
+			}
+		}
+	}
+	flush()
+	return digits.String()
+}
+
+// spokenVerifier lee el dígito verificador: una K o un solo dígito
+func spokenVerifier(tokens []string) string {
+	for _, token := range tokens {
+		if kWords[token] {
+			return "K"
+		}
+		if len(token) == 1 && unicode.IsDigit(rune(token[0])) {
+			return token
+		}
+		if value, ok := numberWords[token]; ok && value < 10 {
+			return strconv.Itoa(value)
+		}
+	}
+	return ""
+}
+
+// spokenNumber acumula un número dicho en palabras: los millones y miles ya cerrados en total y las
+// centenas, decenas y unidades en current
+type spokenNumber struct {
+	total   int
+	current int
+	words   int
+}
+
+// add suma un número menor que mil. Devuelve false si no continúa el número, por ejemplo una unidad
+// después de otra ("ocho cinco") o una centena después de una decena.
+func (n *spokenNumber) add(value int) bool {
+	switch {
+	case n.words == 0:
+	case value == 0 || n.total+n.current == 0:
+		// El cero no continúa un número ni se continúa ("uno cero cero")
+		return false
+	case value >= 100:
+		if n.current != 0 {
+			return false
+		}
+	case value < 10:
+		// Unidades después de una centena o de una decena de treinta en adelante ("treinta y cinco")
+		if rest := n.current % 100; rest != 0 && (rest < 30 || rest%10 != 0) {
+			return false
+		}
+	default:
+		// Decenas y números del diez al veintinueve después de una centena
+		if n.current%100 != 0 {
+			return false
+		}
+	}
+	n.current += value
+	n.words++
+	return true
+}
+
+// addHundreds multiplica por cien la unidad anterior ("tres cientos")
+func (n *spokenNumber) addHundreds() bool {
+	if n.current == 0 || n.current >= 10 {
+		return false
+	}
+	n.current *= 100
+	n.words++
+	return true
+}
+
+// addThousand cierra los miles ("trescientos cuarenta y cinco mil"); "mil" solo es mil
+func (n *spokenNumber) addThousand() bool {
+	if n.total%1000000 != 0 {
+		return false
+	}
+	if n.current == 0 {
+		n.current = 1
+	}
+	n.total += n.current * 1000
+	n.current = 0
+	n.words++
+	return true
+}
+
+// addMillion cierra los millones ("doce millones")
+func (n *spokenNumber) addMillion() bool {
+	if n.total != 0 || n.current == 0 {
+		return false
+	}
+	n.total = n.current * 1000000
+	n.current = 0
+	n.words++
+	return true
+}
+
+// String escribe el número en cifras
+func (n spokenNumber) String() string {
+	return strconv.Itoa(n.total + n.current)
+}
@@ -0,0 +1,107 @@
+package speech
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Límites de Twilio para el atributo hints de <Gather>
+const (
+	MaxHints      = 500
+	MaxHintLength = 100
+)
+
+// Hint es una frase que el reconocimiento de voz debe esperar. Boost es el peso de la frase en los modelos
+// de Google que admiten refuerzo de frases; 0 la deja sin peso.
+type Hint struct {
+	Phrase string
+	Boost  float64
+}
+
+// systemClasses son los tokens de clase de Google Speech-to-Text equivalentes a las entidades de sistema de
+// Dialogflow CX. Las entidades de sistema sin equivalente no generan hints.
+var systemClasses = map[string][]string{
+	"sys.number":         {"$OOV_CLASS_DIGIT_SEQUENCE"},
+	"sys.number-integer": {"$OOV_CLASS_DIGIT_SEQUENCE"},
+	"sys.cardinal":       {"$OOV_CLASS_DIGIT_SEQUENCE"},
+	"sys.phone-number":   {"$FULLPHONENUM"},
+	"sys.date":           {"$DAY", "$MONTH", "$YEAR"},
+	"sys.date-time":      {"$DAY", "$MONTH", "$TIME"},
+	"sys.time":           {"$TIME"},
+	"sys.percentage":     {"$PERCENT"},
+	"sys.unit-currency":  {"$MONEY"},
+	"sys.currency-name":  {"$MONEY"},
+	"sys.address":        {"$ADDRESSNUM", "$STREET"},
+	"sys.street-address": {"$ADDRESSNUM", "$STREET"},
+	"sys.zip-code":       {"$POSTALCODE"},
+}
+
+// IsSystemEntity indica si un tipo de entidad de Dialogflow CX es de sistema, por nombre corto
+// ("sys.date") o por nombre completo (".../entityTypes/sys.date")
+func IsSystemEntity(entityType string) bool {
+	return strings.HasPrefix(entityName(entityType), "sys.")
+}
+
+// SystemEntityHints devuelve los tokens de clase de una entidad de sistema, o nil si no tiene equivalente
+func SystemEntityHints(entityType string) []Hint {
+	var hints []Hint
+	for _, class := range systemClasses[entityName(entityType)] {
+		hints = append(hints, Hint{Phrase: class})
+	}
+	return hints
+}
+
+// entityName devuelve el último segmento del nombre de un tipo de entidad
+func entityName(entityType string) string {
+	return entityType[strings.LastIndex(entityType, "/")+1:]
+}
+
+// Merge une listas de hints en orden de prioridad: quita los espacios sobrantes y las comas, que separan
+// las frases en Twilio, descarta las frases vacías, demasiado largas o repetidas (sin distinguir
+// mayúsculas, conservando la primera) y deja como máximo MaxHints
+func Merge(lists ...[]Hint) []Hint {
+	seen := map[string]bool{}
+	var merged []Hint
+	for _, list := range lists {
+		for _, hint := range list {
+			hint.Phrase = strings.Join(strings.Fields(strings.ReplaceAll(hint.Phrase, ",", " ")), " ")
+			key := strings.ToLower(hint.Phrase)
+			if hint.Phrase == "" || utf8.RuneCountInString(hint.Phrase) > MaxHintLength || seen[key] {
+				continue
+			}
+			if len(merged) == MaxHints {
+				return merged
+			}
+			seen[key] = true
+			merged = append(merged, hint)
+		}
+	}
+	return merged
+}
+
+// Format genera el atributo hints de <Gather>. Con boost, las frases con peso llevan el sufijo ":peso"
+// del refuerzo de frases de Google; los tokens de clase nunca llevan peso.
+func Format(hints []Hint, boost bool) string {
+	phrases := make([]string, 0, len(hints))
+	for _, hint := range hints {
+		phrase := hint.Phrase
+		if boost && hint.Boost > 0 && !strings.HasPrefix(phrase, "$") {
+			// Una frase que con el peso supera el largo máximo queda sin peso
+			if suffix := ":" + strconv.FormatFloat(hint.Boost, 'f', -1, 64); utf8.RuneCountInString(phrase)+len(suffix) <= MaxHintLength {
+				phrase += suffix
+			}
+		}
+		phrases = append(phrases, phrase)
+	}
+	return strings.Join(phrases, ", ")
+}
+
+// Phrases convierte una lista de frases en hints con el mismo peso
+func Phrases(phrases []string, boost float64) []Hint {
+	hints := make([]Hint, 0, len(phrases))
+	for _, phrase := range phrases {
+		hints = append(hints, Hint{Phrase: phrase, Boost: boost})
+	}
+	return hints
+}
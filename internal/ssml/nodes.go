@@ -0,0 +1,167 @@
+package ssml
+
+import (
+	"encoding/xml"
+	"strings"
+	"unicode/utf8"
+)
+
+// Node es un nodo de un contenido SSML: texto o un elemento
+type Node interface {
+	ssmlNode()
+}
+
+// Text es texto plano dentro de un contenido SSML; se escapa al serializar
+type Text string
+
+// Break es una pausa: por intensidad ("none" a "x-strong") o por duración ("500ms", "1s")
+type Break struct {
+	XMLName  xml.Name `xml:"break"`
+	Strength string   `xml:"strength,attr,omitempty"`
+	Time     string   `xml:"time,attr,omitempty"`
+}
+
+// Emphasis destaca su contenido ("strong", "moderate" o "reduced")
+type Emphasis struct {
+	Level string
+	Nodes []Node
+}
+
+// Prosody cambia la velocidad, el tono o el volumen de su contenido
+type Prosody struct {
+	Rate   string
+	Pitch  string
+	Volume string
+	Nodes  []Node
+}
+
+// SayAs indica cómo leer su texto: "characters", "cardinal", "date", "telephone", etc.
+type SayAs struct {
+	XMLName     xml.Name `xml:"say-as"`
+	InterpretAs string   `xml:"interpret-as,attr"`
+	Format      string   `xml:"format,attr,omitempty"`
+	Value       string   `xml:",chardata"`
+}
+
+// Phoneme indica la pronunciación fonética de su texto
+type Phoneme struct {
+	XMLName  xml.Name `xml:"phoneme"`
+	Alphabet string   `xml:"alphabet,attr,omitempty"`
+	Ph       string   `xml:"ph,attr"`
+	Value    string   `xml:",chardata"`
+}
+
+// Sub lee Alias en lugar de su texto, por ejemplo una sigla
+type Sub struct {
+	XMLName xml.Name `xml:"sub"`
+	Alias   string   `xml:"alias,attr"`
+	Value   string   `xml:",chardata"`
+}
+
+// Word indica el rol gramatical de una palabra para desambiguar su pronunciación
+type Word struct {
+	XMLName xml.Name `xml:"w"`
+	Role    string   `xml:"role,attr,omitempty"`
+	Value   string   `xml:",chardata"`
+}
+
+// Lang lee su contenido en otro idioma
+type Lang struct {
+	Lang  string
+	Nodes []Node
+}
+
+// Paragraph es un párrafo
+type Paragraph struct {
+	Nodes []Node
+}
+
+// Sentence es una oración
+type Sentence struct {
+	Nodes []Node
+}
+
+// MarshalXML serializa el elemento con su contenido mixto
+func (n *Emphasis) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return EncodeElement(e, Element("emphasis", "level", n.Level), n.Nodes)
+}
+
+// MarshalXML serializa el elemento con su contenido mixto
+func (n *Prosody) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return EncodeElement(e, Element("prosody", "rate", n.Rate, "pitch", n.Pitch, "volume", n.Volume), n.Nodes)
+}
+
+// MarshalXML serializa el elemento con su contenido mixto
+func (n *Lang) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return EncodeElement(e, Element("lang", "xml:lang", n.Lang), n.Nodes)
+}
+
+// MarshalXML serializa el elemento con su contenido mixto
+func (n *Paragraph) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return EncodeElement(e, Element("p"), n.Nodes)
+}
+
+// MarshalXML serializa el elemento con su contenido mixto
+func (n *Sentence) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return EncodeElement(e, Element("s"), n.Nodes)
+}
+
+// Element crea la etiqueta de apertura con los atributos no vacíos, dados como pares nombre, valor
+func Element(name string, attrs ...string) xml.StartElement {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if attrs[i+1] != "" {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]})
+		}
+	}
+	return start
+}
+
+// EncodeElement serializa un elemento cuyo contenido mezcla texto y elementos SSML, respetando su orden
+func EncodeElement(e *xml.Encoder, start xml.StartElement, nodes []Node) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		var err error
+		if text, ok := node.(Text); ok {
+			err = e.EncodeToken(xml.CharData(text))
+		} else {
+			err = e.Encode(node)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// Render serializa el contenido como documento <speak>, el formato que recibe Google Text-to-Speech
+func Render(nodes []Node) (string, error) {
+	var markup strings.Builder
+	e := xml.NewEncoder(&markup)
+	if err := EncodeElement(e, Element("speak"), nodes); err != nil {
+		return "", err
+	}
+	if err := e.Flush(); err != nil {
+		return "", err
+	}
+	return markup.String(), nil
+}
+
+// TextLength cuenta los caracteres del texto que se lee, sin las etiquetas
+func TextLength(nodes []Node) int {
+	return utf8.RuneCountInString(strings.TrimSpace(plainText(nodes)))
+}
+
+func (Text) ssmlNode()       {}
+func (*Break) ssmlNode()     {}
+func (*Emphasis) ssmlNode()  {}
+func (*Prosody) ssmlNode()   {}
+func (*SayAs) ssmlNode()     {}
+func (*Phoneme) ssmlNode()   {}
+func (*Sub) ssmlNode()       {}
+func (*Word) ssmlNode()      {}
+func (*Lang) ssmlNode()      {}
+func (*Paragraph) ssmlNode() {}
+func (*Sentence) ssmlNode()  {}
@@ -0,0 +1,196 @@
+package ssml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	breakTimePattern = regexp.MustCompile(`^\d{1,5}(ms|s)$`)
+	breakStrengths   = []string{"none", "x-weak", "weak", "medium", "strong", "x-strong"}
+	emphasisLevels   = []string{"strong", "moderate", "reduced", "none"}
+)
+
+// Parse convierte un marcado SSML (con o sin <speak>) en nodos. Solo se conservan los elementos y
+// atributos que soportan tanto Twilio <Say> como Google Text-to-Speech; los demás elementos se
+// descartan conservando su texto, de modo que el resultado siempre se puede volver a serializar.
+func Parse(markup string) ([]Node, error) {
+	markup = strings.TrimSpace(markup)
+	if strings.HasPrefix(markup, "<?xml") {
+		if i := strings.Index(markup, "?>"); i >= 0 {
+			markup = strings.TrimSpace(markup[i+2:])
+		}
+	}
+	if !strings.HasPrefix(markup, "<speak") {
+		markup = "<speak>" + markup + "</speak>"
+	}
+
+	d := xml.NewDecoder(strings.NewReader(markup))
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("error al parsear el SSML: %v", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if start.Name.Local != "speak" {
+				return nil, fmt.Errorf("error al parsear el SSML: se esperaba <speak> y no <%s>", start.Name.Local)
+			}
+			return parseChildren(d)
+		}
+	}
+}
+
+// parseChildren lee el contenido de un elemento hasta su cierre
+func parseChildren(d *xml.Decoder) ([]Node, error) {
+	var nodes []Node
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("error al parsear el SSML: %v", err)
+		}
+		switch token := token.(type) {
+		case xml.CharData:
+			nodes = appendText(nodes, string(token))
+		case xml.StartElement:
+			children, err := parseElement(d, token)
+			if err != nil {
+				return nil, err
+			}
+			for _, child := range children {
+				if text, ok := child.(Text); ok {
+					nodes = appendText(nodes, string(text))
+				} else {
+					nodes = append(nodes, child)
+				}
+			}
+		case xml.EndElement:
+			return nodes, nil
+		}
+	}
+}
+
+// parseElement convierte un elemento en nodos. Un elemento no soportado, o con atributos inválidos,
+// se reemplaza por su contenido.
+func parseElement(d *xml.Decoder, start xml.StartElement) ([]Node, error) {
+	children, err := parseChildren(d)
+	if err != nil {
+		return nil, err
+	}
+	attr := func(name string) string {
+		for _, a := range start.Attr {
+			if a.Name.Local == name {
+				return strings.TrimSpace(a.Value)
+			}
+		}
+		return ""
+	}
+
+	switch start.Name.Local {
+	case "break":
+		node := &Break{Strength: attr("strength"), Time: attr("time")}
+		if node.Strength != "" && !oneOf(node.Strength, breakStrengths) {
+			node.Strength = ""
+		}
+		if node.Time != "" && !breakTimePattern.MatchString(node.Time) {
+			node.Time = ""
+		}
+		return []Node{node}, nil
+	case "say-as":
+		if interpretAs := attr("interpret-as"); interpretAs != "" {
+			return []Node{&SayAs{InterpretAs: interpretAs, Format: attr("format"), Value: plainText(children)}}, nil
+		}
+	case "phoneme":
+		if ph := attr("ph"); ph != "" {
+			return []Node{&Phoneme{Alphabet: attr("alphabet"), Ph: ph, Value: plainText(children)}}, nil
+		}
+	case "sub":
+		if alias := attr("alias"); alias != "" {
+			return []Node{&Sub{Alias: alias, Value: plainText(children)}}, nil
+		}
+	case "w":
+		return []Node{&Word{Role: attr("role"), Value: plainText(children)}}, nil
+	case "emphasis":
+		level := attr("level")
+		if level != "" && !oneOf(level, emphasisLevels) {
+			level = ""
+		}
+		return []Node{&Emphasis{Level: level, Nodes: children}}, nil
+	case "prosody":
+		return []Node{&Prosody{Rate: attr("rate"), Pitch: attr("pitch"), Volume: attr("volume"), Nodes: children}}, nil
+	case "lang":
+		if lang := attr("lang"); lang != "" {
+			return []Node{&Lang{Lang: lang, Nodes: children}}, nil
+		}
+	case "p":
+		return []Node{&Paragraph{Nodes: children}}, nil
+	case "s":
+		return []Node{&Sentence{Nodes: children}}, nil
+	}
+	return children, nil
+}
+
+// appendText agrega texto uniendo los textos consecutivos
+func appendText(nodes []Node, text string) []Node {
+	if text == "" {
+		return nodes
+	}
+	if n := len(nodes); n > 0 {
+		if previous, ok := nodes[n-1].(Text); ok {
+			nodes[n-1] = previous + Text(text)
+			return nodes
+		}
+	}
+	return append(nodes, Text(text))
+}
+
+// plainText devuelve el texto de los nodos, sin etiquetas
+func plainText(nodes []Node) string {
+	var text strings.Builder
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case Text:
+			text.WriteString(string(node))
+		case *Break:
+			text.WriteString(" ")
+		case *SayAs:
+			text.WriteString(node.Value)
+		case *Phoneme:
+			text.WriteString(node.Value)
+		case *Sub:
+			text.WriteString(node.Value)
+		case *Word:
+			text.WriteString(node.Value)
+		case *Emphasis:
+			text.WriteString(plainText(node.Nodes))
+		case *Prosody:
+			text.WriteString(plainText(node.Nodes))
+		case *Lang:
+			text.WriteString(plainText(node.Nodes))
+		case *Paragraph:
+			text.WriteString(plainText(node.Nodes))
+		case *Sentence:
+			text.WriteString(plainText(node.Nodes))
+		}
+	}
+	return text.String()
+}
+
+// PlainText devuelve el texto que se lee de un marcado SSML, por ejemplo para la transcripción
+func PlainText(markup string) (string, error) {
+	nodes, err := Parse(markup)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(plainText(nodes)), nil
+}
+
+func oneOf(value string, allowed []string) bool {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package ssml
+
+import (
+	"regexp"
+	"strings"
+)
+
+// textPattern reconoce en el texto de Dialogflow lo que los motores de voz leen mal como texto plano.
+// Las alternativas se evalúan en orden, por lo que las más específicas van primero.
+var textPattern = regexp.MustCompile(strings.Join([]string{
+	// Teléfonos en formato internacional: +56 9 1234 5678
+	`(?P<phone>\+\d{1,3}(?:[ -]?\d){7,12})`,
+	// Montos en pesos chilenos: $12.500, CLP 12.500, 12.500 pesos
+	`(?P<currency>(?:\$|\bCLP\$?)\s?(?P<prefixed>\d{1,3}(?:\.\d{3})+|\d+)|(?P<suffixed>\d{1,3}(?:\.\d{3})+|\d+)\s?(?:pesos|CLP)\b)`,
+	// Fechas: 18/10/2026, 18-10-2026 y 2026-10-18
+	`(?P<date>\b\d{1,2}[/-]\d{1,2}[/-]\d{4}\b)`,
+	`(?P<isodate>\b\d{4}-\d{2}-\d{2}\b)`,
+	// Números con separador de miles: 1.500
+	`(?P<number>\b\d{1,3}(?:\.\d{3})+\b)`,
+	// Códigos de cuenta, de producto o de seguimiento: AB-12345, 00123456
+	`(?P<code>\b[A-Za-z]{1,4}-?\d{3,}\b|\b\d{6,}\b)`,
+	// Pausas: puntos suspensivos y saltos de línea
+	`(?P<ellipsis>\.\.\.|…)`,
+	`(?P<newline>[ \t]*\n\s*)`,
+	// Énfasis con asteriscos: *importante* o **importante**
+	`(?P<emphasis>\*{1,2}(?P<emphasized>[^*\n]+)\*{1,2})`,
+}, "|"))
+
+// FromText convierte el texto de una respuesta en SSML: lee los montos como pesos, las fechas como fechas,
+// los teléfonos como teléfonos y los códigos carácter por carácter, y agrega pausas y énfasis.
+// El resto del texto se conserva tal cual y se escapa al serializar.
+func FromText(text string) []Node {
+	var nodes []Node
+	last := 0
+	for _, match := range textPattern.FindAllStringSubmatchIndex(text, -1) {
+		nodes = appendText(nodes, text[last:match[0]])
+		last = match[1]
+
+		group := func(name string) (string, bool) {
+			i := 2 * textPattern.SubexpIndex(name)
+			if match[i] < 0 {
+				return "", false
+			}
+			return text[match[i]:match[i+1]], true
+		}
+
+		if phone, ok := group("phone"); ok {
+			nodes = append(nodes, &SayAs{InterpretAs: "telephone", Value: phone})
+		} else if _, ok := group("currency"); ok {
+			amount, prefixed := group("prefixed")
+			if !prefixed {
+				amount, _ = group("suffixed")
+			}
+			nodes = append(nodes, &SayAs{InterpretAs: "cardinal", Value: strings.ReplaceAll(amount, ".", "")})
+			nodes = appendText(nodes, " pesos")
+		} else if date, ok := group("date"); ok {
+			nodes = append(nodes, &SayAs{InterpretAs: "date", Format: "dmy", Value: strings.ReplaceAll(date, "-", "/")})
+		} else if date, ok := group("isodate"); ok {
+			nodes = append(nodes, &SayAs{InterpretAs: "date", Format: "ymd", Value: date})
+		} else if number, ok := group("number"); ok {
+			nodes = append(nodes, &SayAs{InterpretAs: "cardinal", Value: strings.ReplaceAll(number, ".", "")})
+		} else if code, ok := group("code"); ok {
+			nodes = append(nodes, &SayAs{InterpretAs: "characters", Value: code})
+		} else if _, ok := group("ellipsis"); ok {
+			nodes = append(nodes, &Break{Time: "500ms"})
+		} else if _, ok := group("newline"); ok {
+			nodes = append(nodes, &Break{Strength: "strong"})
+		} else if emphasized, ok := group("emphasized"); ok {
+			nodes = append(nodes, &Emphasis{Level: "moderate", Nodes: []Node{Text(emphasized)}})
+		}
+	}
+	return appendText(nodes, text[last:])
+}
@@ -0,0 +1,108 @@
+package twiml
+
+import (
+	"kairosia/internal/models"
+	"kairosia/internal/ssml"
+)
+
+// FromModel convierte una respuesta de models.TwiMLResponse en un documento, con sus verbos
+// en el orden fijo del modelo: Say, Play, Gather, Dial, Enqueue, Record, Leave y Hangup
+func FromModel(response *models.TwiMLResponse) *Response {
+	doc := New()
+	if response == nil {
+		return doc
+	}
+
+	if response.Say != nil {
+		doc.Add(fromSay(response.Say))
+	}
+	if response.Play != nil {
+		doc.Add(&Play{Loop: response.Play.Loop, URL: response.Play.URL})
+	}
+	if g := response.Gather; g != nil {
+		gather := &Gather{
+			Input:               g.Input,
+			Timeout:             g.Timeout,
+			NumDigits:           g.NumDigits,
+			FinishOnKey:         g.FinishOnKey,
+			Action:              g.Action,
+			Method:              g.Method,
+			Language:            g.Language,
+			Hints:               g.Hints,
+			ProfanityFilter:     g.ProfanityFilter,
+			SpeechTimeout:       g.SpeechTimeout,
+			SpeechModel:         g.SpeechModel,
+			ActionOnEmptyResult: g.ActionOnEmptyResult,
+		}
+		if g.Say != nil {
+			gather.Verbs = append(gather.Verbs, fromSay(g.Say))
+		}
+		doc.Add(gather)
+	}
+	if response.Dial != nil {
+		doc.Add(fromDial(response.Dial))
+	}
+	if q := response.Enqueue; q != nil {
+		doc.Add(&Enqueue{Action: q.Action, Method: q.Method, WaitURL: q.WaitURL, WaitURLMethod: q.WaitURLMethod, Name: q.Name})
+	}
+	if r := response.Record; r != nil {
+		doc.Add(&Record{Action: r.Action, Method: r.Method, MaxLength: r.MaxLength, PlayBeep: r.PlayBeep, FinishOnKey: r.FinishOnKey})
+	}
+	if response.Leave != nil {
+		doc.Add(&Leave{})
+	}
+	if response.Hangup != nil {
+		doc.Add(&Hangup{})
+	}
+	return doc
+}
+
+// fromSay convierte un <Say>. Si tiene SSML se lee el SSML; si el SSML no se puede parsear, el texto.
+func fromSay(say *models.TwiMLSay) *Say {
+	if say.SSML != "" {
+		if nodes, err := ssml.Parse(say.SSML); err == nil {
+			return &Say{Voice: say.Voice, Language: say.Language, Nodes: nodes}
+		}
+	}
+	return SayText(say.Voice, say.Language, say.Value)
+}
+
+// fromDial convierte un <Dial> con sus sustantivos
+func fromDial(d *models.TwiMLDial) *Dial {
+	dial := &Dial{
+		Action:   d.Action,
+		Method:   d.Method,
+		Timeout:  d.Timeout,
+		CallerID: d.CallerId,
+		Record:   d.Record,
+	}
+	if n := d.Number; n != nil {
+		dial.Nouns = append(dial.Nouns, &Number{URL: n.URL, Method: n.Method, Value: n.Value})
+	}
+	if c := d.Conference; c != nil {
+		dial.Nouns = append(dial.Nouns, &Conference{
+			StartConferenceOnEnter: c.StartConferenceOnEnter,
+			EndConferenceOnExit:    c.EndConferenceOnExit,
+			Beep:                   c.Beep,
+			WaitURL:                c.WaitURL,
+			StatusCallback:         c.StatusCallback,
+			StatusCallbackMethod:   c.StatusCallbackMethod,
+			StatusCallbackEvent:    c.StatusCallbackEvent,
+			Name:                   c.Name,
+		})
+	}
+	if q := d.Queue; q != nil {
+		dial.Nouns = append(dial.Nouns, &Queue{URL: q.URL, Method: q.Method, Name: q.Name})
+	}
+	if s := d.Sip; s != nil {
+		dial.Nouns = append(dial.Nouns, &Sip{URL: s.URL, Method: s.Method, Username: s.Username, Password: s.Password, URI: s.URI})
+	}
+	if c := d.Client; c != nil {
+		client := &Client{URL: c.URL, Method: c.Method, Identity: c.Identity}
+		for _, parameter := range c.Parameters {
+			client.Parameters = append(client.Parameters, Parameter{Name: parameter.Name, Value: parameter.Value})
+		}
+		dial.Nouns = append(dial.Nouns, client)
+	}
+	return dial
+}
@@ -0,0 +1,73 @@
+package twiml
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// Verb es un verbo de TwiML. Los verbos se serializan en el orden en que se agregan al documento.
+type Verb interface {
+	verb()
+}
+
+// Noun es un sustantivo de TwiML dentro de un <Dial> o un <Connect>
+type Noun interface {
+	noun()
+}
+
+// Response es un documento TwiML con sus verbos en orden
+type Response struct {
+	XMLName xml.Name `xml:"Response"`
+	Verbs   []Verb
+}
+
+// New crea un documento con los verbos indicados
+func New(verbs ...Verb) *Response {
+	return &Response{Verbs: verbs}
+}
+
+// Add agrega verbos al final del documento
+func (r *Response) Add(verbs ...Verb) *Response {
+	r.Verbs = append(r.Verbs, verbs...)
+	return r
+}
+
+// Say agrega un <Say> con un texto
+func (r *Response) Say(voice, language, text string) *Response {
+	return r.Add(SayText(voice, language, text))
+}
+
+// Pause agrega un <Pause> de los segundos indicados
+func (r *Response) Pause(seconds int) *Response {
+	return r.Add(&Pause{Length: fmt.Sprint(seconds)})
+}
+
+// Play agrega un <Play> del audio indicado
+func (r *Response) Play(url string) *Response {
+	return r.Add(&Play{URL: url})
+}
+
+// Redirect agrega un <Redirect> a la URL indicada
+func (r *Response) Redirect(url string) *Response {
+	return r.Add(&Redirect{Method: "POST", URL: url})
+}
+
+// Hangup agrega un <Hangup>
+func (r *Response) Hangup() *Response {
+	return r.Add(&Hangup{})
+}
+
+// Reject agrega un <Reject> con el motivo indicado ("rejected" o "busy")
+func (r *Response) Reject(reason string) *Response {
+	return r.Add(&Reject{Reason: reason})
+}
+
+// Render serializa el documento con la cabecera XML. No se indenta para no agregar espacios
+// dentro del contenido SSML de los <Say>.
+func (r *Response) Render() (string, error) {
+	xmlData, err := xml.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("error al serializar el TwiML: %v", err)
+	}
+	return xml.Header + string(xmlData), nil
+}
@@ -0,0 +1,161 @@
+package twiml
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kairosia/internal/ssml"
+)
+
+// update reescribe los archivos golden con la salida actual: go test ./internal/twiml -update
+var update = flag.Bool("update", false, "reescribe los archivos golden de testdata")
+
+func TestRenderGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  *Response
+	}{
+		{
+			name: "say_ssml",
+			doc: New(&Say{
+				Voice:    "Polly.Lupe",
+				Language: "es-CL",
+				Nodes: []ssml.Node{
+					ssml.Text("Su número de cliente es "),
+					&ssml.SayAs{InterpretAs: "characters", Value: "A12"},
+					&ssml.Break{Time: "500ms"},
+					&ssml.Emphasis{Level: "strong", Nodes: []ssml.Node{ssml.Text("gracias & hasta luego")}},
+					&ssml.Prosody{Rate: "slow", Nodes: []ssml.Node{ssml.Text("adiós")}},
+				},
+			}),
+		},
+		{
+			name: "gather_nested",
+			doc: New(&Gather{
+				Input:         "speech dtmf",
+				Action:        "https://example.com/voice",
+				Method:        "POST",
+				Timeout:       "5",
+				SpeechTimeout: "auto",
+				Language:      "es-CL",
+				Hints:         "boleta,agente",
+				Verbs: []Verb{
+					SayText("Polly.Lupe", "es-CL", "¿En qué puedo ayudarle?"),
+					&Pause{Length: "1"},
+					&Play{URL: "https://example.com/beep.mp3"},
+				},
+			}).Redirect("https://example.com/voice?no_input=1"),
+		},
+		{
+			name: "dial_number",
+			doc: New(&Dial{
+				Action:   "https://example.com/dial-result",
+				Method:   "POST",
+				Timeout:  "30",
+				CallerID: "+56221234567",
+				Nouns:    []Noun{&Number{URL: "https://example.com/whisper", Method: "POST", Value: "+56912345678"}},
+			}),
+		},
+		{
+			name: "dial_sip",
+			doc: New(&Dial{
+				Nouns: []Noun{&Sip{Username: "agente", Password: "secreto", URI: "sip:agente@pbx.example.com?X-Call-Sid=CA123"}},
+			}),
+		},
+		{
+			name: "dial_client",
+			doc: New(&Dial{
+				Nouns: []Noun{&Client{
+					Identity:   "agente-1",
+					Parameters: []Parameter{{Name: "call_sid", Value: "CA123"}, {Name: "intent", Value: "billing"}},
+				}},
+			}),
+		},
+		{
+			name: "dial_conference",
+			doc: New(&Dial{
+				Nouns: []Noun{&Conference{
+					StartConferenceOnEnter: "true",
+					EndConferenceOnExit:    "false",
+					Beep:                   "false",
+					WaitURL:                "https://example.com/hold",
+					StatusCallback:         "https://example.com/conference-events",
+					StatusCallbackEvent:    "start end join leave",
+					Name:                   "kairosia-CA123",
+				}},
+			}),
+		},
+		{
+			name: "dial_queue",
+			doc:  New(&Dial{Nouns: []Noun{&Queue{URL: "https://example.com/whisper", Name: "billing"}}}),
+		},
+		{
+			name: "connect_stream",
+			doc: New(&Connect{
+				Action: "https://example.com/stream-ended",
+				Nouns: []Noun{&Stream{
+					URL:        "wss://example.com/media",
+					Track:      "inbound_track",
+					Parameters: []Parameter{{Name: "tenant_id", Value: "default"}},
+				}},
+			}),
+		},
+		{
+			name: "sms",
+			doc:  New(&Sms{To: "+56912345678", From: "+56221234567", Body: "Su número de atención es 123."}),
+		},
+		{
+			name: "message",
+			doc:  New(&Message{To: "+56912345678", Body: "Hola", Media: []string{"https://example.com/a.png"}}),
+		},
+		{
+			name: "record",
+			doc: New(&Record{Action: "https://example.com/voicemail", MaxLength: "120", PlayBeep: "true", FinishOnKey: "#"}).
+				Hangup(),
+		},
+		{
+			name: "enqueue_leave",
+			doc: New(&Enqueue{Action: "https://example.com/queue-result", WaitURL: "https://example.com/queue-wait", Name: "support"}).
+				Add(&Leave{}),
+		},
+		{
+			name: "say_pause_redirect",
+			doc: New().
+				Say("Polly.Lupe", "es-CL", "Gracias por esperar.").
+				Pause(2).
+				Say("Polly.Lupe", "es-CL", "Un agente le atenderá en breve.").
+				Play("https://example.com/music.mp3").
+				Redirect("https://example.com/queue-wait"),
+		},
+		{
+			name: "reject",
+			doc:  New().Reject("busy"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.doc.Render()
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+
+			path := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(path, []byte(got+"\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("no se pudo leer %s (use -update para crearlo): %v", path, err)
+			}
+			if got != strings.TrimSuffix(string(want), "\n") {
+				t.Errorf("Render() no coincide con %s\n got: %s\nwant: %s", path, got, want)
+			}
+		})
+	}
+}
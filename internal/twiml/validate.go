@@ -0,0 +1,359 @@
+package twiml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kairosia/internal/ssml"
+)
+
+// Límites de Twilio para los documentos TwiML
+const (
+	// MaxDocumentSize es el tamaño máximo de una respuesta TwiML, en bytes
+	MaxDocumentSize = 64 * 1024
+	// MaxSayLength es la cantidad máxima de caracteres de un <Say>
+	MaxSayLength = 4096
+	// maxDialNouns es la cantidad máxima de destinos simultáneos de un <Dial>
+	maxDialNouns = 10
+	// maxGatherHints y maxHintLength son la cantidad máxima de frases del atributo hints de <Gather> y su largo
+	maxGatherHints = 500
+	maxHintLength  = 100
+)
+
+// Violation es una regla de Twilio que el documento no cumple
+type Violation struct {
+	// Path ubica el elemento, por ejemplo "Response/Gather[1]/Say[0]"
+	Path string
+	// Rule identifica la regla incumplida, para contarlas
+	Rule string
+	// Message describe el problema
+	Message string
+}
+
+// String formatea la infracción para los logs
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s (%s)", v.Path, v.Message, v.Rule)
+}
+
+// validator acumula las infracciones de un documento
+type validator struct {
+	violations []Violation
+}
+
+func (v *validator) add(path, rule, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{Path: path, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate revisa el documento con las reglas de Twilio para TwiML de voz: anidamiento permitido,
+// valores de los atributos y límites de longitud. Devuelve nil si el documento es válido.
+func Validate(doc *Response) []Violation {
+	v := &validator{}
+	for i, verb := range doc.Verbs {
+		v.verb(fmt.Sprintf("Response/%s[%d]", verbName(verb), i), verb)
+	}
+	return v.violations
+}
+
+// verb valida un verbo del nivel superior del documento
+func (v *validator) verb(path string, verb Verb) {
+	switch verb := verb.(type) {
+	case *Say:
+		v.say(path, verb)
+	case *Play:
+		v.play(path, verb)
+	case *Pause:
+		v.nonNegative(path, "length", verb.Length)
+	case *Gather:
+		v.gather(path, verb)
+	case *Dial:
+		v.dial(path, verb)
+	case *Record:
+		v.method(path, verb.Method)
+		v.positive(path, "maxLength", verb.MaxLength)
+		v.positive(path, "timeout", verb.Timeout)
+		v.enum(path, "playBeep", verb.PlayBeep, "true", "false")
+		v.enum(path, "trim", verb.Trim, "trim-silence", "do-not-trim")
+		v.enum(path, "transcribe", verb.Transcribe, "true", "false")
+		v.finishOnKey(path, verb.FinishOnKey)
+	case *Redirect:
+		v.method(path, verb.Method)
+		v.required(path, "url", verb.URL)
+	case *Enqueue:
+		v.method(path, verb.Method)
+		v.method(path, verb.WaitURLMethod)
+		if verb.Name == "" && verb.WorkflowSid == "" {
+			v.add(path, "required", "falta el nombre de la cola")
+		}
+	case *Reject:
+		v.enum(path, "reason", verb.Reason, "rejected", "busy")
+	case *Sms:
+		v.method(path, verb.Method)
+		v.required(path, "body", verb.Body)
+	case *Connect:
+		v.method(path, verb.Method)
+		if len(verb.Nouns) != 1 {
+			v.add(path, "nesting", "<Connect> debe tener exactamente un sustantivo")
+		}
+		for i, noun := range verb.Nouns {
+			nounPath := fmt.Sprintf("%s/%s[%d]", path, nounName(noun), i)
+			if stream, ok := noun.(*Stream); ok {
+				v.stream(nounPath, stream)
+			} else {
+				v.add(nounPath, "nesting", "<Connect> no admite <%s>", nounName(noun))
+			}
+		}
+	case *Leave, *Hangup:
+	case *Message:
+		v.add(path, "nesting", "<Message> es TwiML de mensajería; en una llamada se usa <Sms>")
+	default:
+		v.add(path, "nesting", "verbo no soportado")
+	}
+}
+
+// say valida el texto de un <Say>: no vacío y dentro del límite de Twilio
+func (v *validator) say(path string, say *Say) {
+	v.nonNegative(path, "loop", say.Loop)
+	length := ssml.TextLength(say.Nodes)
+	if length == 0 {
+		v.add(path, "required", "<Say> sin texto")
+	} else if length > MaxSayLength {
+		v.add(path, "length", "<Say> de %d caracteres supera el máximo de %d", length, MaxSayLength)
+	}
+}
+
+// play valida un <Play>: una URL de audio o dígitos DTMF
+func (v *validator) play(path string, play *Play) {
+	v.nonNegative(path, "loop", play.Loop)
+	if play.URL == "" && play.Digits == "" {
+		v.add(path, "required", "<Play> sin URL ni dígitos")
+	}
+	if strings.Trim(play.Digits, "0123456789*#wW") != "" {
+		v.add(path, "enum", "dígitos inválidos: %q", play.Digits)
+	}
+}
+
+// hints valida la cantidad de frases del atributo hints de <Gather> y el largo de cada una
+func (v *validator) hints(path, hints string) {
+	if hints == "" {
+		return
+	}
+	phrases := strings.Split(hints, ",")
+	if len(phrases) > maxGatherHints {
+		v.add(path, "length", "hints con %d frases supera el máximo de %d", len(phrases), maxGatherHints)
+	}
+	for _, phrase := range phrases {
+		if length := len([]rune(strings.TrimSpace(phrase))); length > maxHintLength {
+			v.add(path, "length", "hint de %d caracteres supera el máximo de %d", length, maxHintLength)
+			return
+		}
+	}
+}
+
+// gather valida un <Gather> y sus verbos anidados, que solo pueden ser Say, Play y Pause
+func (v *validator) gather(path string, gather *Gather) {
+	switch gather.Input {
+	case "speech", "dtmf", "dtmf speech", "speech dtmf":
+	case "":
+		v.add(path, "required", "<Gather> sin input")
+	default:
+		v.add(path, "enum", "input inválido: %q", gather.Input)
+	}
+	v.method(path, gather.Method)
+	v.nonNegative(path, "timeout", gather.Timeout)
+	v.positive(path, "numDigits", gather.NumDigits)
+	v.positive(path, "maxSpeechTime", gather.MaxSpeechTime)
+	if gather.SpeechTimeout != "auto" {
+		v.nonNegative(path, "speechTimeout", gather.SpeechTimeout)
+	}
+	v.finishOnKey(path, gather.FinishOnKey)
+	v.hints(path, gather.Hints)
+	v.enum(path, "profanityFilter", gather.ProfanityFilter, "true", "false")
+	v.enum(path, "enhanced", gather.Enhanced, "true", "false")
+	v.enum(path, "actionOnEmptyResult", gather.ActionOnEmptyResult, "true", "false")
+	v.enum(path, "bargeIn", gather.BargeIn, "true", "false")
+
+	for i, verb := range gather.Verbs {
+		verbPath := fmt.Sprintf("%s/%s[%d]", path, verbName(verb), i)
+		switch verb.(type) {
+		case *Say, *Play, *Pause:
+			v.verb(verbPath, verb)
+		default:
+			v.add(verbPath, "nesting", "<Gather> no admite <%s>", verbName(verb))
+		}
+	}
+}
+
+// dial valida un <Dial>: al menos un destino, y Conference o Queue siempre solos
+func (v *validator) dial(path string, dial *Dial) {
+	v.method(path, dial.Method)
+	v.rangeInt(path, "timeout", dial.Timeout, 5, 600)
+	v.rangeInt(path, "timeLimit", dial.TimeLimit, 1, 14400)
+	v.enum(path, "record", dial.Record, "do-not-record", "record-from-answer", "record-from-ringing",
+		"record-from-answer-dual", "record-from-ringing-dual", "true", "false")
+	v.enum(path, "hangupOnStar", dial.HangupOnStar, "true", "false")
+	v.enum(path, "answerOnBridge", dial.AnswerOnBridge, "true", "false")
+
+	switch {
+	case len(dial.Nouns) == 0:
+		v.add(path, "required", "<Dial> sin destino")
+	case len(dial.Nouns) > maxDialNouns:
+		v.add(path, "length", "<Dial> con %d destinos supera el máximo de %d", len(dial.Nouns), maxDialNouns)
+	}
+
+	for i, noun := range dial.Nouns {
+		nounPath := fmt.Sprintf("%s/%s[%d]", path, nounName(noun), i)
+		switch noun := noun.(type) {
+		case *Number:
+			v.method(nounPath, noun.Method)
+			if !isPhoneNumber(noun.Value) {
+				v.add(nounPath, "format", "número inválido: %q", noun.Value)
+			}
+		case *Sip:
+			v.method(nounPath, noun.Method)
+			if !strings.HasPrefix(strings.ToLower(noun.URI), "sip:") {
+				v.add(nounPath, "format", "URI SIP inválida: %q", noun.URI)
+			}
+		case *Client:
+			v.method(nounPath, noun.Method)
+			v.required(nounPath, "Identity", noun.Identity)
+		case *Conference, *Queue:
+			if len(dial.Nouns) > 1 {
+				v.add(nounPath, "nesting", "<%s> debe ser el único destino del <Dial>", nounName(noun))
+			}
+			if conference, ok := noun.(*Conference); ok {
+				v.required(nounPath, "name", conference.Name)
+				v.method(nounPath, conference.WaitMethod)
+				v.enum(nounPath, "beep", conference.Beep, "true", "false", "onEnter", "onExit")
+			} else {
+				queue := noun.(*Queue)
+				v.required(nounPath, "name", queue.Name)
+				v.method(nounPath, queue.Method)
+			}
+		default:
+			v.add(nounPath, "nesting", "<Dial> no admite <%s>", nounName(noun))
+		}
+	}
+}
+
+// stream valida un <Stream>: la URL debe ser un WebSocket seguro
+func (v *validator) stream(path string, stream *Stream) {
+	if !strings.HasPrefix(stream.URL, "wss://") {
+		v.add(path, "format", "la URL del <Stream> debe comenzar con wss://: %q", stream.URL)
+	}
+	v.enum(path, "track", stream.Track, "inbound_track", "outbound_track", "both_tracks")
+	v.method(path, stream.StatusCallbackMethod)
+}
+
+// isPhoneNumber acepta números E.164 o locales, con o sin separadores; los dígitos DTMF van en sendDigits
+func isPhoneNumber(s string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(strings.TrimPrefix(strings.TrimSpace(s), "+"))
+	if digits == "" || len(digits) > 15 {
+		return false
+	}
+	return strings.Trim(digits, "0123456789") == ""
+}
+
+func (v *validator) required(path, attr, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(path, "required", "falta %s", attr)
+	}
+}
+
+func (v *validator) method(path, method string) {
+	v.enum(path, "method", method, "GET", "POST")
+}
+
+func (v *validator) finishOnKey(path, key string) {
+	if len(key) > 1 || strings.Trim(key, "0123456789*#") != "" {
+		v.add(path, "enum", "finishOnKey inválido: %q", key)
+	}
+}
+
+// enum valida un atributo opcional contra sus valores permitidos
+func (v *validator) enum(path, attr, value string, allowed ...string) {
+	if value == "" {
+		return
+	}
+	for _, candidate := range allowed {
+		if value == candidate {
+			return
+		}
+	}
+	v.add(path, "enum", "%s inválido: %q", attr, value)
+}
+
+func (v *validator) nonNegative(path, attr, value string) {
+	v.rangeInt(path, attr, value, 0, -1)
+}
+
+func (v *validator) positive(path, attr, value string) {
+	v.rangeInt(path, attr, value, 1, -1)
+}
+
+// rangeInt valida un atributo entero opcional; max negativo significa sin máximo
+func (v *validator) rangeInt(path, attr, value string, min, max int) {
+	if value == "" {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || (max >= 0 && n > max) {
+		v.add(path, "range", "%s fuera de rango: %q", attr, value)
+	}
+}
+
+// verbName devuelve el nombre del elemento de un verbo
+func verbName(verb Verb) string {
+	switch verb.(type) {
+	case *Say:
+		return "Say"
+	case *Play:
+		return "Play"
+	case *Pause:
+		return "Pause"
+	case *Gather:
+		return "Gather"
+	case *Dial:
+		return "Dial"
+	case *Record:
+		return "Record"
+	case *Redirect:
+		return "Redirect"
+	case *Enqueue:
+		return "Enqueue"
+	case *Leave:
+		return "Leave"
+	case *Hangup:
+		return "Hangup"
+	case *Reject:
+		return "Reject"
+	case *Sms:
+		return "Sms"
+	case *Message:
+		return "Message"
+	case *Connect:
+		return "Connect"
+	default:
+		return fmt.Sprintf("%T", verb)
+	}
+}
+
+// nounName devuelve el nombre del elemento de un sustantivo
+func nounName(noun Noun) string {
+	switch noun.(type) {
+	case *Number:
+		return "Number"
+	case *Sip:
+		return "Sip"
+	case *Client:
+		return "Client"
+	case *Conference:
+		return "Conference"
+	case *Queue:
+		return "Queue"
+	case *Stream:
+		return "Stream"
+	default:
+		return fmt.Sprintf("%T", noun)
+	}
+}
@@ -0,0 +1,194 @@
+package twiml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	say := SayText("Polly.Lupe", "es-CL", "Hola")
+
+	// violation omite el mensaje: las pruebas comparan la ubicación y la regla
+	type violation struct {
+		Path string
+		Rule string
+	}
+
+	tests := []struct {
+		name string
+		doc  *Response
+		want []violation
+	}{
+		{
+			name: "documento valido",
+			doc: New(&Gather{Input: "speech dtmf", Timeout: "5", SpeechTimeout: "auto", FinishOnKey: "#", Verbs: []Verb{say, &Pause{Length: "1"}}}).
+				Add(&Dial{Timeout: "30", Nouns: []Noun{&Number{Value: "+56 9 1234 5678"}}}).
+				Redirect("https://example.com/voice"),
+		},
+
+		// Anidamiento
+		{
+			name: "gather con dial anidado",
+			doc:  New(&Gather{Input: "speech", Verbs: []Verb{say, &Dial{Nouns: []Noun{&Number{Value: "+56912345678"}}}}}),
+			want: []violation{{Path: "Response/Gather[0]/Dial[1]", Rule: "nesting"}},
+		},
+		{
+			name: "conference con otro destino",
+			doc:  New(&Dial{Nouns: []Noun{&Number{Value: "+56912345678"}, &Conference{Name: "sala"}}}),
+			want: []violation{{Path: "Response/Dial[0]/Conference[1]", Rule: "nesting"}},
+		},
+		{
+			name: "connect sin sustantivo",
+			doc:  New(&Connect{}),
+			want: []violation{{Path: "Response/Connect[0]", Rule: "nesting"}},
+		},
+		{
+			name: "connect con number",
+			doc:  New(&Connect{Nouns: []Noun{&Number{Value: "+56912345678"}}}),
+			want: []violation{{Path: "Response/Connect[0]/Number[0]", Rule: "nesting"}},
+		},
+		{
+			name: "message en una llamada",
+			doc:  New().Say("Polly.Lupe", "es-CL", "Hola").Add(&Message{Body: "Hola"}),
+			want: []violation{{Path: "Response/Message[1]", Rule: "nesting"}},
+		},
+
+		// Valores permitidos
+		{
+			name: "input invalido",
+			doc:  New(&Gather{Input: "voice"}),
+			want: []violation{{Path: "Response/Gather[0]", Rule: "enum"}},
+		},
+		{
+			name: "metodo invalido en redirect",
+			doc:  New(&Redirect{Method: "PUT", URL: "https://example.com/voice"}),
+			want: []violation{{Path: "Response/Redirect[0]", Rule: "enum"}},
+		},
+		{
+			name: "finishOnKey invalido",
+			doc:  New(&Gather{Input: "dtmf", FinishOnKey: "##"}),
+			want: []violation{{Path: "Response/Gather[0]", Rule: "enum"}},
+		},
+		{
+			name: "reject y beep invalidos",
+			doc:  New(&Dial{Nouns: []Noun{&Conference{Name: "sala", Beep: "maybe"}}}).Reject("later"),
+			want: []violation{
+				{Path: "Response/Dial[0]/Conference[0]", Rule: "enum"},
+				{Path: "Response/Reject[1]", Rule: "enum"},
+			},
+		},
+		{
+			name: "digitos invalidos en play anidado",
+			doc:  New(&Gather{Input: "dtmf", Verbs: []Verb{&Play{Digits: "12x"}}}),
+			want: []violation{{Path: "Response/Gather[0]/Play[0]", Rule: "enum"}},
+		},
+
+		// Rangos numéricos
+		{
+			name: "timeout del dial bajo el minimo",
+			doc:  New(&Dial{Timeout: "4", Nouns: []Noun{&Number{Value: "+56912345678"}}}),
+			want: []violation{{Path: "Response/Dial[0]", Rule: "range"}},
+		},
+		{
+			name: "timeLimit del dial sobre el maximo",
+			doc:  New(&Dial{TimeLimit: "14401", Nouns: []Noun{&Number{Value: "+56912345678"}}}),
+			want: []violation{{Path: "Response/Dial[0]", Rule: "range"}},
+		},
+		{
+			name: "pausa negativa",
+			doc:  New(&Pause{Length: "-1"}),
+			want: []violation{{Path: "Response/Pause[0]", Rule: "range"}},
+		},
+		{
+			name: "numDigits cero y timeout no numerico",
+			doc:  New(&Gather{Input: "dtmf", NumDigits: "0", Timeout: "cinco"}),
+			want: []violation{
+				{Path: "Response/Gather[0]", Rule: "range"},
+				{Path: "Response/Gather[0]", Rule: "range"},
+			},
+		},
+		{
+			name: "maxLength del record",
+			doc:  New(&Record{MaxLength: "0"}),
+			want: []violation{{Path: "Response/Record[0]", Rule: "range"}},
+		},
+
+		// Longitudes
+		{
+			name: "say demasiado largo",
+			doc:  New(&Gather{Input: "speech", Verbs: []Verb{SayText("Polly.Lupe", "es-CL", strings.Repeat("a", MaxSayLength+1))}}),
+			want: []violation{{Path: "Response/Gather[0]/Say[0]", Rule: "length"}},
+		},
+		{
+			name: "say en el limite",
+			doc:  New(SayText("Polly.Lupe", "es-CL", strings.Repeat("ñ", MaxSayLength))),
+		},
+		{
+			name: "hint demasiado largo",
+			doc:  New(&Gather{Input: "speech", Hints: "boleta," + strings.Repeat("a", maxHintLength+1)}),
+			want: []violation{{Path: "Response/Gather[0]", Rule: "length"}},
+		},
+		{
+			name: "hint en el limite con tildes",
+			doc:  New(&Gather{Input: "speech", Hints: strings.Repeat("é", maxHintLength)}),
+		},
+		{
+			name: "demasiadas frases en hints",
+			doc:  New(&Gather{Input: "speech", Hints: strings.Repeat("a,", maxGatherHints) + "a"}),
+			want: []violation{{Path: "Response/Gather[0]", Rule: "length"}},
+		},
+		{
+			name: "dial con demasiados destinos",
+			doc:  New(&Dial{Nouns: repeatNoun(&Number{Value: "+56912345678"}, maxDialNouns+1)}),
+			want: []violation{{Path: "Response/Dial[0]", Rule: "length"}},
+		},
+
+		// Atributos requeridos y formatos
+		{
+			name: "say vacio y dial sin destino",
+			doc:  New(SayText("Polly.Lupe", "es-CL", "")).Add(&Dial{}),
+			want: []violation{
+				{Path: "Response/Say[0]", Rule: "required"},
+				{Path: "Response/Dial[1]", Rule: "required"},
+			},
+		},
+		{
+			name: "numero y uri sip invalidos",
+			doc:  New(&Dial{Nouns: []Noun{&Number{Value: "+56 9 abc"}, &Sip{URI: "agente@pbx.example.com"}}}),
+			want: []violation{
+				{Path: "Response/Dial[0]/Number[0]", Rule: "format"},
+				{Path: "Response/Dial[0]/Sip[1]", Rule: "format"},
+			},
+		},
+		{
+			name: "stream sin wss",
+			doc:  New(&Connect{Nouns: []Noun{&Stream{URL: "https://example.com/media"}}}),
+			want: []violation{{Path: "Response/Connect[0]/Stream[0]", Rule: "format"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []violation
+			for _, v := range Validate(tt.doc) {
+				if v.Message == "" {
+					t.Errorf("%s (%s) sin mensaje", v.Path, v.Rule)
+				}
+				got = append(got, violation{Path: v.Path, Rule: v.Rule})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// repeatNoun devuelve n copias del sustantivo
+func repeatNoun(noun Noun, n int) []Noun {
+	nouns := make([]Noun, n)
+	for i := range nouns {
+		nouns[i] = noun
+	}
+	return nouns
+}
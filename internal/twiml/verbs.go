@@ -0,0 +1,260 @@
+package twiml
+
+import (
+	"encoding/xml"
+
+	"kairosia/internal/ssml"
+)
+
+// Say lee un texto o contenido SSML con la voz indicada
+type Say struct {
+	Voice    string
+	Language string
+	Loop     string
+	Nodes    []ssml.Node
+}
+
+// SayText crea un <Say> con un texto sin SSML
+func SayText(voice, language, text string) *Say {
+	return &Say{Voice: voice, Language: language, Nodes: []ssml.Node{ssml.Text(text)}}
+}
+
+// MarshalXML serializa el <Say> con su contenido mixto de texto y elementos SSML
+func (s *Say) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return ssml.EncodeElement(e, ssml.Element("Say", "voice", s.Voice, "language", s.Language, "loop", s.Loop), s.Nodes)
+}
+
+// Play reproduce un audio o envía tonos DTMF
+type Play struct {
+	XMLName xml.Name `xml:"Play"`
+	Loop    string   `xml:"loop,attr,omitempty"`
+	Digits  string   `xml:"digits,attr,omitempty"`
+	URL     string   `xml:",chardata"`
+}
+
+// Pause espera en silencio los segundos indicados
+type Pause struct {
+	XMLName xml.Name `xml:"Pause"`
+	Length  string   `xml:"length,attr,omitempty"`
+}
+
+// Gather captura voz o dígitos; los verbos anidados (Say, Play y Pause) se escuchan mientras espera
+type Gather struct {
+	XMLName             xml.Name `xml:"Gather"`
+	Input               string   `xml:"input,attr,omitempty"`
+	Action              string   `xml:"action,attr,omitempty"`
+	Method              string   `xml:"method,attr,omitempty"`
+	Timeout             string   `xml:"timeout,attr,omitempty"`
+	SpeechTimeout       string   `xml:"speechTimeout,attr,omitempty"`
+	MaxSpeechTime       string   `xml:"maxSpeechTime,attr,omitempty"`
+	FinishOnKey         string   `xml:"finishOnKey,attr,omitempty"`
+	NumDigits           string   `xml:"numDigits,attr,omitempty"`
+	Language            string   `xml:"language,attr,omitempty"`
+	Hints               string   `xml:"hints,attr,omitempty"`
+	ProfanityFilter     string   `xml:"profanityFilter,attr,omitempty"`
+	SpeechModel         string   `xml:"speechModel,attr,omitempty"`
+	Enhanced            string   `xml:"enhanced,attr,omitempty"`
+	ActionOnEmptyResult string   `xml:"actionOnEmptyResult,attr,omitempty"`
+	BargeIn             string   `xml:"bargeIn,attr,omitempty"`
+	Verbs               []Verb
+}
+
+// Dial conecta la llamada con uno o más sustantivos (Number, Sip, Client, Conference o Queue)
+type Dial struct {
+	XMLName        xml.Name `xml:"Dial"`
+	Action         string   `xml:"action,attr,omitempty"`
+	Method         string   `xml:"method,attr,omitempty"`
+	Timeout        string   `xml:"timeout,attr,omitempty"`
+	CallerID       string   `xml:"callerId,attr,omitempty"`
+	Record         string   `xml:"record,attr,omitempty"`
+	TimeLimit      string   `xml:"timeLimit,attr,omitempty"`
+	HangupOnStar   string   `xml:"hangupOnStar,attr,omitempty"`
+	AnswerOnBridge string   `xml:"answerOnBridge,attr,omitempty"`
+	RingTone       string   `xml:"ringTone,attr,omitempty"`
+	Nouns          []Noun
+}
+
+// Number es un número de teléfono de un <Dial>. Si tiene url, el destino escucha ese TwiML antes de conectarse.
+type Number struct {
+	XMLName              xml.Name `xml:"Number"`
+	URL                  string   `xml:"url,attr,omitempty"`
+	Method               string   `xml:"method,attr,omitempty"`
+	SendDigits           string   `xml:"sendDigits,attr,omitempty"`
+	StatusCallback       string   `xml:"statusCallback,attr,omitempty"`
+	StatusCallbackMethod string   `xml:"statusCallbackMethod,attr,omitempty"`
+	StatusCallbackEvent  string   `xml:"statusCallbackEvent,attr,omitempty"`
+	Value                string   `xml:",chardata"`
+}
+
+// Sip es una URI SIP de un <Dial>; las cabeceras X- viajan como parámetros de la URI
+type Sip struct {
+	XMLName              xml.Name `xml:"Sip"`
+	URL                  string   `xml:"url,attr,omitempty"`
+	Method               string   `xml:"method,attr,omitempty"`
+	Username             string   `xml:"username,attr,omitempty"`
+	Password             string   `xml:"password,attr,omitempty"`
+	StatusCallback       string   `xml:"statusCallback,attr,omitempty"`
+	StatusCallbackMethod string   `xml:"statusCallbackMethod,attr,omitempty"`
+	StatusCallbackEvent  string   `xml:"statusCallbackEvent,attr,omitempty"`
+	URI                  string   `xml:",chardata"`
+}
+
+// Client es un agente de Twilio Voice SDK de un <Dial>
+type Client struct {
+	XMLName              xml.Name    `xml:"Client"`
+	URL                  string      `xml:"url,attr,omitempty"`
+	Method               string      `xml:"method,attr,omitempty"`
+	StatusCallback       string      `xml:"statusCallback,attr,omitempty"`
+	StatusCallbackMethod string      `xml:"statusCallbackMethod,attr,omitempty"`
+	StatusCallbackEvent  string      `xml:"statusCallbackEvent,attr,omitempty"`
+	Identity             string      `xml:"Identity"`
+	Parameters           []Parameter `xml:"Parameter,omitempty"`
+}
+
+// Conference es una sala de conferencia de un <Dial>
+type Conference struct {
+	XMLName                xml.Name `xml:"Conference"`
+	Muted                  string   `xml:"muted,attr,omitempty"`
+	Beep                   string   `xml:"beep,attr,omitempty"`
+	StartConferenceOnEnter string   `xml:"startConferenceOnEnter,attr,omitempty"`
+	EndConferenceOnExit    string   `xml:"endConferenceOnExit,attr,omitempty"`
+	WaitURL                string   `xml:"waitUrl,attr,omitempty"`
+	WaitMethod             string   `xml:"waitMethod,attr,omitempty"`
+	MaxParticipants        string   `xml:"maxParticipants,attr,omitempty"`
+	Record                 string   `xml:"record,attr,omitempty"`
+	Coach                  string   `xml:"coach,attr,omitempty"`
+	ParticipantLabel       string   `xml:"participantLabel,attr,omitempty"`
+	StatusCallback         string   `xml:"statusCallback,attr,omitempty"`
+	StatusCallbackMethod   string   `xml:"statusCallbackMethod,attr,omitempty"`
+	StatusCallbackEvent    string   `xml:"statusCallbackEvent,attr,omitempty"`
+	Name                   string   `xml:",chardata"`
+}
+
+// Queue conecta con el primer cliente de una cola
+type Queue struct {
+	XMLName xml.Name `xml:"Queue"`
+	URL     string   `xml:"url,attr,omitempty"`
+	Method  string   `xml:"method,attr,omitempty"`
+	Name    string   `xml:",chardata"`
+}
+
+// Record graba al que llama, por ejemplo para un buzón de voz
+type Record struct {
+	XMLName                       xml.Name `xml:"Record"`
+	Action                        string   `xml:"action,attr,omitempty"`
+	Method                        string   `xml:"method,attr,omitempty"`
+	Timeout                       string   `xml:"timeout,attr,omitempty"`
+	FinishOnKey                   string   `xml:"finishOnKey,attr,omitempty"`
+	MaxLength                     string   `xml:"maxLength,attr,omitempty"`
+	PlayBeep                      string   `xml:"playBeep,attr,omitempty"`
+	Trim                          string   `xml:"trim,attr,omitempty"`
+	RecordingStatusCallback       string   `xml:"recordingStatusCallback,attr,omitempty"`
+	RecordingStatusCallbackMethod string   `xml:"recordingStatusCallbackMethod,attr,omitempty"`
+	RecordingStatusCallbackEvent  string   `xml:"recordingStatusCallbackEvent,attr,omitempty"`
+	Transcribe                    string   `xml:"transcribe,attr,omitempty"`
+	TranscribeCallback            string   `xml:"transcribeCallback,attr,omitempty"`
+}
+
+// Redirect continúa la llamada con el TwiML de otra URL
+type Redirect struct {
+	XMLName xml.Name `xml:"Redirect"`
+	Method  string   `xml:"method,attr,omitempty"`
+	URL     string   `xml:",chardata"`
+}
+
+// Enqueue deja la llamada en una cola
+type Enqueue struct {
+	XMLName       xml.Name `xml:"Enqueue"`
+	Action        string   `xml:"action,attr,omitempty"`
+	Method        string   `xml:"method,attr,omitempty"`
+	WaitURL       string   `xml:"waitUrl,attr,omitempty"`
+	WaitURLMethod string   `xml:"waitUrlMethod,attr,omitempty"`
+	WorkflowSid   string   `xml:"workflowSid,attr,omitempty"`
+	Name          string   `xml:",chardata"`
+}
+
+// Leave saca la llamada de la cola en la que espera
+type Leave struct {
+	XMLName xml.Name `xml:"Leave"`
+}
+
+// Hangup termina la llamada
+type Hangup struct {
+	XMLName xml.Name `xml:"Hangup"`
+}
+
+// Reject rechaza una llamada entrante sin contestarla
+type Reject struct {
+	XMLName xml.Name `xml:"Reject"`
+	Reason  string   `xml:"reason,attr,omitempty"`
+}
+
+// Sms envía un SMS durante una llamada (verbo de voz)
+type Sms struct {
+	XMLName        xml.Name `xml:"Sms"`
+	To             string   `xml:"to,attr,omitempty"`
+	From           string   `xml:"from,attr,omitempty"`
+	Action         string   `xml:"action,attr,omitempty"`
+	Method         string   `xml:"method,attr,omitempty"`
+	StatusCallback string   `xml:"statusCallback,attr,omitempty"`
+	Body           string   `xml:",chardata"`
+}
+
+// Message responde con un mensaje (TwiML de mensajería)
+type Message struct {
+	XMLName        xml.Name `xml:"Message"`
+	To             string   `xml:"to,attr,omitempty"`
+	From           string   `xml:"from,attr,omitempty"`
+	Action         string   `xml:"action,attr,omitempty"`
+	Method         string   `xml:"method,attr,omitempty"`
+	StatusCallback string   `xml:"statusCallback,attr,omitempty"`
+	Body           string   `xml:"Body"`
+	Media          []string `xml:"Media,omitempty"`
+}
+
+// Connect conecta la llamada con un servicio, por ejemplo un <Stream> bidireccional de audio
+type Connect struct {
+	XMLName xml.Name `xml:"Connect"`
+	Action  string   `xml:"action,attr,omitempty"`
+	Method  string   `xml:"method,attr,omitempty"`
+	Nouns   []Noun
+}
+
+// Stream envía el audio de la llamada a un WebSocket
+type Stream struct {
+	XMLName              xml.Name    `xml:"Stream"`
+	URL                  string      `xml:"url,attr"`
+	Name                 string      `xml:"name,attr,omitempty"`
+	Track                string      `xml:"track,attr,omitempty"`
+	StatusCallback       string      `xml:"statusCallback,attr,omitempty"`
+	StatusCallbackMethod string      `xml:"statusCallbackMethod,attr,omitempty"`
+	Parameters           []Parameter `xml:"Parameter,omitempty"`
+}
+
+// Parameter es un parámetro personalizado de un <Client> o un <Stream>
+type Parameter struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+func (*Say) verb()      {}
+func (*Play) verb()     {}
+func (*Pause) verb()    {}
+func (*Gather) verb()   {}
+func (*Dial) verb()     {}
+func (*Record) verb()   {}
+func (*Redirect) verb() {}
+func (*Enqueue) verb()  {}
+func (*Leave) verb()    {}
+func (*Hangup) verb()   {}
+func (*Reject) verb()   {}
+func (*Sms) verb()      {}
+func (*Message) verb()  {}
+func (*Connect) verb()  {}
+
+func (*Number) noun()     {}
+func (*Sip) noun()        {}
+func (*Client) noun()     {}
+func (*Conference) noun() {}
+func (*Queue) noun()      {}
+func (*Stream) noun()     {}
@@ -1,9 +1,11 @@
 package utils
 
 import (
+	"math/rand"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 	"google.golang.org/protobuf/types/known/structpb"
@@ -42,6 +44,34 @@ func ParseFloat(s string, defaultValue float64) float64 {
 	return f
 }
 
+// ParseList convierte una lista separada por comas en un slice sin elementos vacíos
+func ParseList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParseMap convierte una lista "clave=valor,clave=valor" en un mapa. Devuelve además los elementos sin clave
+// o sin valor, que se omiten del mapa.
+func ParseMap(s string) (map[string]string, []string) {
+	values := map[string]string{}
+	var invalid []string
+	for _, item := range ParseList(s) {
+		key, value, ok := strings.Cut(item, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			invalid = append(invalid, item)
+			continue
+		}
+		values[key] = value
+	}
+	return values, invalid
+}
+
 // StructToProtoStruct convierte un mapa a un protobuf struct
 func StructToProtoStruct(m map[string]interface{}) (*structpb.Struct, error) {
 	return structpb.NewStruct(m)
@@ -61,7 +91,7 @@ func ProtoStructToMap(s *structpb.Struct) map[string]interface{} {
 
 // ProtoValueToInterface convierte un protobuf value a una interfaz
 func ProtoValueToInterface(v *structpb.Value) interface{} {
-	switch x := v.Kind.(type) {
+	switch v.Kind.(type) {
 	case *structpb.Value_NullValue:
 		return nil
 	case *structpb.Value_NumberValue:
@@ -128,3 +158,17 @@ func TruncateString(s string, maxLen int) string {
 func GenerateSessionID(callSid string) string {
 	return "twilio-" + callSid
 }
+
+// BackoffDelay calcula la espera antes de un reintento con backoff exponencial y jitter
+func BackoffDelay(attempt int, base, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := base << uint(attempt-1)
+	if delay <= 0 || delay > max {
+		delay = max
+	}
+	// Jitter de hasta un 20% para evitar reintentos sincronizados
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay - jitter
+}
@@ -89,12 +89,12 @@ run_local() {
   echo "Ejecutando servicios localmente..."
   
   # Ejecutar servicio de historial de conversaciones en segundo plano
-  cd conversation-history-service && go run main.go &
+  cd conversation-history-service && go run . &
   HISTORY_PID=$!
   cd ..
   
   # Ejecutar servicio de orquestación de voz en primer plano
-  cd voice-orchestration-service && go run main.go
+  cd voice-orchestration-service && go run .
   
   # Matar el proceso del servicio de historial al terminar
   kill $HISTORY_PID
@@ -16,7 +16,10 @@ resource "google_project_service" "required_apis" {
     "firestore.googleapis.com",
     "bigquery.googleapis.com",
     "aiplatform.googleapis.com",
-    "iam.googleapis.com"
+    "iam.googleapis.com",
+    "storage.googleapis.com",
+    "pubsub.googleapis.com",
+    "logging.googleapis.com"
   ])
   
   project = var.project_id
@@ -43,14 +46,25 @@ resource "google_service_account" "history_service_sa" {
   depends_on = [google_project_service.required_apis]
 }
 
+# Cuenta de servicio con la que Cloud Scheduler, los operadores y el escritorio del agente invocan la API de administración
+resource "google_service_account" "api_client_sa" {
+  account_id   = "voice-api-client-${var.service_account_suffix}"
+  display_name = "Voice Admin API Client Service Account"
+  description  = "Cuenta de servicio autorizada a invocar la API de administración del servicio de voz"
+  
+  depends_on = [google_project_service.required_apis]
+}
+
 # Asignar roles IAM a las cuentas de servicio
 resource "google_project_iam_member" "voice_orchestration_roles" {
   for_each = toset([
     "roles/dialogflow.client",
+    "roles/dialogflow.reader",
     "roles/speech.client",
     "roles/texttospeech.client",
     "roles/firestore.user",
-    "roles/aiplatform.user"
+    "roles/aiplatform.user",
+    "roles/pubsub.publisher"
   ])
   
   project = var.project_id
@@ -69,12 +83,6 @@ resource "google_project_iam_member" "history_service_roles" {
   member  = "serviceAccount:${google_service_account.history_service_sa.email}"
 }
 
-# Permitir que el servicio de orquestación invoque al servicio de historial
-resource "google_service_account_iam_member" "voice_orchestration_invoker" {
-  service_account_id = google_service_account.history_service_sa.name
-  role               = "roles/run.invoker"
-  member             = "serviceAccount:${google_service_account.voice_orchestration_sa.email}"
-}
 
 # Crear dataset de BigQuery
 resource "google_bigquery_dataset" "conversations_dataset" {
@@ -166,6 +174,12 @@ resource "google_bigquery_table" "conversation_transcripts" {
         "mode": "NULLABLE",
         "description": "Confianza de la transcripción"
       },
+      {
+        "name": "input_mode",
+        "type": "STRING",
+        "mode": "NULLABLE",
+        "description": "Forma en que respondió el cliente (speech o dtmf)"
+      },
       {
         "name": "embedding",
         "type": "FLOAT",
@@ -215,6 +229,12 @@ resource "google_bigquery_table" "conversation_transcripts" {
         "type": "STRING",
         "mode": "NULLABLE",
         "description": "ID de la página de Dialogflow CX"
+      },
+      {
+        "name": "match_type",
+        "type": "STRING",
+        "mode": "NULLABLE",
+        "description": "Tipo de coincidencia de Dialogflow CX (INTENT, NO_MATCH, NO_INPUT, ...)"
       }
     ]
   },
@@ -236,6 +256,84 @@ resource "google_bigquery_table" "conversation_transcripts" {
     "mode": "NULLABLE",
     "description": "Marca de tiempo de la transferencia a un agente humano"
   },
+  {
+    "name": "answered_by",
+    "type": "STRING",
+    "mode": "NULLABLE",
+    "description": "Quién contestó una llamada saliente según la detección de contestador de Twilio (AnsweredBy)"
+  },
+  {
+    "name": "machine_detection_outcome",
+    "type": "STRING",
+    "mode": "NULLABLE",
+    "description": "Acción tomada tras la detección de contestador: human, voicemail_left o retry_scheduled"
+  },
+  {
+    "name": "transfer_outcome",
+    "type": "STRING",
+    "mode": "NULLABLE",
+    "description": "Resultado de la transferencia a un agente: connected, callback_offered, voicemail_offered, voicemail o resumed"
+  },
+  {
+    "name": "transfer_duration_seconds",
+    "type": "INTEGER",
+    "mode": "NULLABLE",
+    "description": "Duración en segundos de la conversación con el agente"
+  },
+  {
+    "name": "language_code",
+    "type": "STRING",
+    "mode": "NULLABLE",
+    "description": "Idioma en que terminó la conversación (es-CL, en-US, pt-BR, ...)"
+  },
+  {
+    "name": "detected_language",
+    "type": "STRING",
+    "mode": "NULLABLE",
+    "description": "Idioma detectado en la primera respuesta del cliente"
+  },
+  {
+    "name": "no_input_count",
+    "type": "INTEGER",
+    "mode": "NULLABLE",
+    "description": "Turnos en que el cliente no respondió"
+  },
+  {
+    "name": "no_match_count",
+    "type": "INTEGER",
+    "mode": "NULLABLE",
+    "description": "Turnos en que Dialogflow no reconoció la respuesta del cliente"
+  },
+  {
+    "name": "speech_confidence_avg",
+    "type": "FLOAT",
+    "mode": "NULLABLE",
+    "description": "Confianza promedio del reconocimiento de voz de la llamada"
+  },
+  {
+    "name": "speech_confidence_min",
+    "type": "FLOAT",
+    "mode": "NULLABLE",
+    "description": "Confianza mínima del reconocimiento de voz de la llamada"
+  },
+  {
+    "name": "low_confidence_turns",
+    "type": "INTEGER",
+    "mode": "NULLABLE",
+    "description": "Transcripciones con confianza menor que el umbral de confirmación"
+  },
+  {
+    "name": "speech_confirmations",
+    "type": "INTEGER",
+    "mode": "NULLABLE",
+    "description": "Veces que se preguntó al cliente si dijo lo transcrito"
+  },
+  {
+    "name": "speech_confirmations_rejected",
+    "type": "INTEGER",
+    "mode": "NULLABLE",
+    "description": "Confirmaciones en que el cliente respondió que no"
+  },
   {
     "name": "embedding",
     "type": "FLOAT",
@@ -254,6 +352,68 @@ EOF
   depends_on = [google_bigquery_dataset.conversations_dataset]
 }
 
+# Crear bucket para la cola de mensajes fallidos de BigQuery
+resource "google_storage_bucket" "dead_letter" {
+  name                        = "${var.project_id}-${var.dead_letter_bucket_suffix}"
+  location                    = var.region
+  uniform_bucket_level_access = true
+  
+  depends_on = [google_project_service.required_apis]
+}
+
+# Permitir que el servicio de historial gestione los lotes fallidos
+resource "google_storage_bucket_iam_member" "history_dead_letter" {
+  bucket = google_storage_bucket.dead_letter.name
+  role   = "roles/storage.objectAdmin"
+  member = "serviceAccount:${google_service_account.history_service_sa.email}"
+}
+
+# Tópico de eventos del ciclo de vida de las conversaciones
+resource "google_pubsub_topic" "conversation_events" {
+  name = var.pubsub_topic
+  
+  depends_on = [google_project_service.required_apis]
+}
+
+# Cuenta de servicio con la que Pub/Sub firma las solicitudes push al servicio de historial
+resource "google_service_account" "pubsub_push_sa" {
+  account_id   = "pubsub-push-${var.service_account_suffix}"
+  display_name = "Pub/Sub Push Service Account"
+  description  = "Cuenta de servicio para las suscripciones push de Pub/Sub"
+  
+  depends_on = [google_project_service.required_apis]
+}
+
+resource "google_cloud_run_service_iam_member" "pubsub_push_invoker" {
+  location = google_cloud_run_service.conversation_history_service.location
+  project  = google_cloud_run_service.conversation_history_service.project
+  service  = google_cloud_run_service.conversation_history_service.name
+  role     = "roles/run.invoker"
+  member   = "serviceAccount:${google_service_account.pubsub_push_sa.email}"
+}
+
+# Suscripción push que entrega los eventos al servicio de historial
+resource "google_pubsub_subscription" "conversation_events_history" {
+  name                    = "${var.pubsub_topic}-history"
+  topic                   = google_pubsub_topic.conversation_events.name
+  enable_message_ordering = true
+  ack_deadline_seconds    = 60
+  
+  push_config {
+    push_endpoint = "${google_cloud_run_service.conversation_history_service.status[0].url}/conversation-events"
+    
+    oidc_token {
+      service_account_email = google_service_account.pubsub_push_sa.email
+      audience              = google_cloud_run_service.conversation_history_service.status[0].url
+    }
+  }
+  
+  retry_policy {
+    minimum_backoff = "10s"
+    maximum_backoff = "600s"
+  }
+}
+
 # Crear base de datos de Firestore
 resource "google_firestore_database" "database" {
   name        = "(default)"
@@ -263,6 +423,62 @@ resource "google_firestore_database" "database" {
   depends_on = [google_project_service.required_apis]
 }
 
+# Índice para que el despachador consulte los eventos pendientes de la cola de salida
+resource "google_firestore_index" "transcript_outbox_pending" {
+  collection = var.outbox_collection
+  
+  fields {
+    field_path = "status"
+    order      = "ASCENDING"
+  }
+  
+  fields {
+    field_path = "next_attempt_at"
+    order      = "ASCENDING"
+  }
+  
+  depends_on = [google_firestore_database.database]
+}
+
+# Índice para reservar los contactos pendientes de cada campaña
+resource "google_firestore_index" "campaign_contacts_pending" {
+  collection = var.campaign_contacts_collection
+  
+  fields {
+    field_path = "campaign_id"
+    order      = "ASCENDING"
+  }
+  
+  fields {
+    field_path = "status"
+    order      = "ASCENDING"
+  }
+  
+  fields {
+    field_path = "next_attempt_at"
+    order      = "ASCENDING"
+  }
+  
+  depends_on = [google_firestore_database.database]
+}
+
+# Índice para consultar las devoluciones de llamada vencidas
+resource "google_firestore_index" "callbacks_pending" {
+  collection = var.callback_collection
+  
+  fields {
+    field_path = "status"
+    order      = "ASCENDING"
+  }
+  
+  fields {
+    field_path = "next_attempt_at"
+    order      = "ASCENDING"
+  }
+  
+  depends_on = [google_firestore_database.database]
+}
+
 # Desplegar servicio de orquestación de voz en Cloud Run
 resource "google_cloud_run_service" "voice_orchestration_service" {
   name     = "voice-orchestration-service"
@@ -323,11 +539,216 @@ resource "google_cloud_run_service" "voice_orchestration_service" {
           value = "1.0"
         }
         
+        env {
+          name  = "SSML_ENABLED"
+          value = var.ssml_enabled
+        }
+        
+        env {
+          name  = "PROMPTS_DIR"
+          value = var.prompts_dir
+        }
+        
+        env {
+          name  = "PROMPT_DEFAULT_LOCALE"
+          value = var.prompt_default_locale
+        }
+        
+        env {
+          name  = "PROMPT_FALLBACK_LOCALES"
+          value = var.prompt_fallback_locales
+        }
+        
+        env {
+          name  = "LANGUAGE_DETECTION_ENABLED"
+          value = var.language_detection_enabled
+        }
+        
+        env {
+          name  = "LANGUAGE_DETECTION_MIN_SCORE"
+          value = var.language_detection_min_score
+        }
+        
+        env {
+          name  = "SUPPORTED_LANGUAGES"
+          value = var.supported_languages
+        }
+        
+        env {
+          name  = "LANGUAGE_VOICES"
+          value = var.language_voices
+        }
+        
+        env {
+          name  = "NO_INPUT_ESCALATION"
+          value = var.no_input_escalation
+        }
+        
+        env {
+          name  = "NO_MATCH_ESCALATION"
+          value = var.no_match_escalation
+        }
+        
+        env {
+          name  = "SPEECH_CONFIRMATION_THRESHOLD"
+          value = var.speech_confirmation_threshold
+        }
+        
+        env {
+          name  = "SPEECH_CONFIRMATION_MAX_ATTEMPTS"
+          value = var.speech_confirmation_max_attempts
+        }
+        
+        env {
+          name  = "SPEECH_HINTS_ENABLED"
+          value = var.speech_hints_enabled
+        }
+        
+        env {
+          name  = "SPEECH_HINTS"
+          value = var.speech_hints
+        }
+        
+        env {
+          name  = "SPEECH_HINT_BOOST"
+          value = var.speech_hint_boost
+        }
+        
+        env {
+          name  = "SPEECH_HINTS_REFRESH_SECONDS"
+          value = var.speech_hints_refresh_seconds
+        }
+        
+        env {
+          name  = "SPEECH_VOCABULARY_COLLECTION"
+          value = var.speech_vocabulary_collection
+        }
+        
+        env {
+          name  = "DTMF_TIMEOUT"
+          value = var.dtmf_timeout
+        }
+        
+        env {
+          name  = "DTMF_MAX_ATTEMPTS"
+          value = var.dtmf_max_attempts
+        }
+        
+        env {
+          name  = "RUT_DTMF_K_KEY"
+          value = var.rut_dtmf_k_key
+        }
+        
         env {
           name  = "FIRESTORE_COLLECTION"
           value = var.firestore_collection
         }
         
+        env {
+          name  = "OUTBOX_COLLECTION"
+          value = var.outbox_collection
+        }
+        
+        env {
+          name  = "EVENT_BUS"
+          value = "pubsub"
+        }
+        
+        env {
+          name  = "PUBSUB_TOPIC"
+          value = google_pubsub_topic.conversation_events.name
+        }
+        
+        env {
+          name  = "SERVICE_AUTH_MODE"
+          value = "idtoken"
+        }
+        
+        env {
+          name  = "CAMPAIGN_COLLECTION"
+          value = var.campaign_collection
+        }
+        
+        env {
+          name  = "CAMPAIGN_CONTACTS_COLLECTION"
+          value = var.campaign_contacts_collection
+        }
+        
+        env {
+          name  = "CALLBACK_COLLECTION"
+          value = var.callback_collection
+        }
+        
+        env {
+          name  = "ROUTING_COLLECTION"
+          value = var.routing_collection
+        }
+        
+        env {
+          name  = "TRANSFER_SIP_USERNAME"
+          value = var.transfer_sip_username
+        }
+        
+        env {
+          name  = "TRANSFER_SIP_PASSWORD"
+          value = var.transfer_sip_password
+        }
+        
+        env {
+          name  = "BUSINESS_HOURS_COLLECTION"
+          value = var.business_hours_collection
+        }
+        
+        env {
+          name  = "BUSINESS_HOURS"
+          value = var.business_hours
+        }
+        
+        env {
+          name  = "BUSINESS_HOURS_TIMEZONE"
+          value = var.business_hours_timezone
+        }
+        
+        env {
+          name  = "BUSINESS_HOURS_HOLIDAYS"
+          value = var.business_hours_holidays
+        }
+        
+        env {
+          name  = "AFTER_HOURS_HANDOFF"
+          value = var.after_hours_handoff
+        }
+        
+        env {
+          name  = "API_AUTH_ALLOWED_SERVICE_ACCOUNTS"
+          value = join(",", concat([google_service_account.api_client_sa.email], var.campaign_api_allowed_service_accounts))
+        }
+        
+        env {
+          name  = "VOICE_ORCHESTRATION_SERVICE_URL"
+          value = var.voice_service_url
+        }
+        
+        env {
+          name  = "TWILIO_ACCOUNT_SID"
+          value = var.twilio_account_sid
+        }
+        
+        env {
+          name  = "TWILIO_AUTH_TOKEN"
+          value = var.twilio_auth_token
+        }
+        
+        env {
+          name  = "TWILIO_PHONE_NUMBER"
+          value = var.twilio_phone_number
+        }
+        
+        env {
+          name  = "TWIML_VALIDATION_MODE"
+          value = var.twiml_validation_mode
+        }
+        
         env {
           name  = "VERTEX_AI_EMBEDDING_MODEL"
           value = "textembedding-gecko"
@@ -357,6 +778,36 @@ resource "google_cloud_run_service" "voice_orchestration_service" {
           name  = "TRANSFER_PHONE_NUMBER"
           value = "+56912345678" # Reemplazar con variable de entorno
         }
+        
+        env {
+          name  = "TRANSFER_ALTERNATE_NUMBERS"
+          value = var.transfer_alternate_numbers
+        }
+        
+        env {
+          name  = "HANDOFF_FALLBACK"
+          value = var.handoff_fallback
+        }
+        
+        env {
+          name  = "HANDOFF_MODE"
+          value = var.handoff_mode
+        }
+        
+        env {
+          name  = "QUEUE_ROUTES"
+          value = var.queue_routes
+        }
+        
+        env {
+          name  = "AGENT_DESKTOP_WEBHOOK_URL"
+          value = var.agent_desktop_webhook_url
+        }
+        
+        env {
+          name  = "AGENT_DESKTOP_WEBHOOK_SECRET"
+          value = var.agent_desktop_webhook_secret
+        }
       }
       
       service_account_name = google_service_account.voice_orchestration_sa.email
@@ -375,6 +826,32 @@ resource "google_cloud_run_service" "voice_orchestration_service" {
   ]
 }
 
+# Métrica basada en logs que cuenta las infracciones de TwiML de todas las instancias por regla y modo
+resource "google_logging_metric" "twiml_violations" {
+  name   = "twiml_violations"
+  filter = "resource.type=\"cloud_run_revision\" AND resource.labels.service_name=\"${google_cloud_run_service.voice_orchestration_service.name}\" AND jsonPayload.event=\"twiml_violation\""
+  
+  metric_descriptor {
+    metric_kind = "DELTA"
+    value_type  = "INT64"
+    
+    labels {
+      key        = "rule"
+      value_type = "STRING"
+    }
+    
+    labels {
+      key        = "mode"
+      value_type = "STRING"
+    }
+  }
+  
+  label_extractors = {
+    rule = "EXTRACT(jsonPayload.rule)"
+    mode = "EXTRACT(jsonPayload.mode)"
+  }
+}
+
 # Desplegar servicio de historial de conversaciones en Cloud Run
 resource "google_cloud_run_service" "conversation_history_service" {
   name     = "conversation-history-service"
@@ -405,6 +882,21 @@ resource "google_cloud_run_service" "conversation_history_service" {
           value = var.bigquery_table
         }
         
+        env {
+          name  = "DEAD_LETTER_BUCKET"
+          value = google_storage_bucket.dead_letter.name
+        }
+        
+        env {
+          name  = "SERVICE_AUTH_MODE"
+          value = "idtoken"
+        }
+        
+        env {
+          name  = "SERVICE_AUTH_ALLOWED_SERVICE_ACCOUNTS"
+          value = "${google_service_account.voice_orchestration_sa.email},${google_service_account.pubsub_push_sa.email}"
+        }
+        
         env {
           name  = "VERTEX_AI_EMBEDDING_MODEL"
           value = "textembedding-gecko"
@@ -428,7 +920,9 @@ resource "google_cloud_run_service" "conversation_history_service" {
   depends_on = [
     google_project_service.required_apis,
     google_service_account.history_service_sa,
-    google_bigquery_table.conversation_transcripts
+    google_bigquery_table.conversation_transcripts,
+    google_storage_bucket.dead_letter,
+    google_service_account.pubsub_push_sa
   ]
 }
 
@@ -442,13 +936,13 @@ resource "google_cloud_run_service_iam_member" "voice_orchestration_public" {
   member   = "allUsers"
 }
 
-resource "google_cloud_run_service_iam_member" "conversation_history_public" {
-  count    = var.allow_unauthenticated ? 1 : 0
+# El servicio de historial nunca es público: solo lo invocan el servicio de voz y las suscripciones push
+resource "google_cloud_run_service_iam_member" "voice_orchestration_history_invoker" {
   location = google_cloud_run_service.conversation_history_service.location
   project  = google_cloud_run_service.conversation_history_service.project
   service  = google_cloud_run_service.conversation_history_service.name
   role     = "roles/run.invoker"
-  member   = "allUsers"
+  member   = "serviceAccount:${google_service_account.voice_orchestration_sa.email}"
 }
 
 # Nota conceptual sobre Vertex AI Vector Search
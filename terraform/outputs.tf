@@ -13,6 +13,11 @@ output "voice_orchestration_service_account" {
   value       = google_service_account.voice_orchestration_sa.email
 }
 
+output "api_client_service_account" {
+  description = "Cuenta de servicio autorizada a invocar la API de administración del servicio de voz"
+  value       = google_service_account.api_client_sa.email
+}
+
 output "history_service_service_account" {
   description = "Cuenta de servicio para el servicio de historial de conversaciones"
   value       = google_service_account.history_service_sa.email
@@ -32,3 +37,8 @@ output "firestore_database" {
   description = "Base de datos de Firestore"
   value       = google_firestore_database.database.name
 }
+
+output "conversation_events_topic" {
+  description = "Tópico de Pub/Sub de los eventos de conversación"
+  value       = google_pubsub_topic.conversation_events.name
+}
@@ -33,12 +33,165 @@ variable "bigquery_table" {
   default     = "conversation_transcripts"
 }
 
+variable "dead_letter_bucket_suffix" {
+  description = "Sufijo del bucket de Cloud Storage para la cola de mensajes fallidos de BigQuery"
+  type        = string
+  default     = "kairosia-dead-letter"
+}
+
 variable "firestore_collection" {
   description = "Nombre de la colección de Firestore para almacenar el estado de las conversaciones"
   type        = string
   default     = "conversation_states"
 }
 
+variable "outbox_collection" {
+  description = "Nombre de la colección de Firestore para la cola de salida de eventos de transcripción"
+  type        = string
+  default     = "transcript_outbox"
+}
+
+variable "campaign_collection" {
+  description = "Nombre de la colección de Firestore para las campañas salientes"
+  type        = string
+  default     = "campaigns"
+}
+
+variable "campaign_contacts_collection" {
+  description = "Nombre de la colección de Firestore para los contactos de las campañas"
+  type        = string
+  default     = "campaign_contacts"
+}
+
+variable "callback_collection" {
+  description = "Nombre de la colección de Firestore para las devoluciones de llamada"
+  type        = string
+  default     = "callbacks"
+}
+
+variable "transfer_alternate_numbers" {
+  description = "Números alternativos (separados por comas) para las transferencias a agentes"
+  type        = string
+  default     = ""
+}
+
+variable "handoff_fallback" {
+  description = "Alternativa cuando ningún agente contesta la transferencia: callback, voicemail o resume"
+  type        = string
+  default     = "callback"
+}
+
+variable "handoff_mode" {
+  description = "Modo de transferencia a los agentes: dial, conference o queue"
+  type        = string
+  default     = "dial"
+}
+
+variable "queue_routes" {
+  description = "Colas de Twilio por intención (intent=cola, separadas por comas) para HANDOFF_MODE=queue"
+  type        = string
+  default     = "billing_inquiry=billing,technical_support=support"
+}
+
+variable "routing_collection" {
+  description = "Nombre de la colección de Firestore para la tabla de enrutamiento de las transferencias"
+  type        = string
+  default     = "routing_rules"
+}
+
+variable "transfer_sip_username" {
+  description = "Usuario para autenticarse ante la central SIP de los agentes"
+  type        = string
+  default     = ""
+}
+
+variable "transfer_sip_password" {
+  description = "Contraseña para autenticarse ante la central SIP de los agentes"
+  type        = string
+  default     = ""
+  sensitive   = true
+}
+
+variable "business_hours_collection" {
+  description = "Nombre de la colección de Firestore para los horarios de atención de los tenants"
+  type        = string
+  default     = "business_hours"
+}
+
+variable "business_hours" {
+  description = "Horario de atención predeterminado (por ejemplo \"1-5 09:00-18:00;6 10:00-14:00\"); vacío lo desactiva"
+  type        = string
+  default     = ""
+}
+
+variable "business_hours_timezone" {
+  description = "Zona horaria de los horarios de atención que no indican una"
+  type        = string
+  default     = "America/Santiago"
+}
+
+variable "business_hours_holidays" {
+  description = "Calendario de feriados del horario de atención predeterminado (CL o vacío)"
+  type        = string
+  default     = "CL"
+}
+
+variable "after_hours_handoff" {
+  description = "Alternativa a las transferencias fuera de horario (callback, voicemail, resume o transfer)"
+  type        = string
+  default     = "callback"
+}
+
+variable "agent_desktop_webhook_url" {
+  description = "URL del CRM o escritorio del agente que recibe el contexto de las transferencias"
+  type        = string
+  default     = ""
+}
+
+variable "agent_desktop_webhook_secret" {
+  description = "Secreto para firmar los envíos al escritorio del agente"
+  type        = string
+  default     = ""
+  sensitive   = true
+}
+
+variable "twilio_account_sid" {
+  description = "SID de la cuenta de Twilio usada para las llamadas salientes"
+  type        = string
+  default     = ""
+}
+
+variable "twilio_auth_token" {
+  description = "Token de autenticación de Twilio"
+  type        = string
+  default     = ""
+  sensitive   = true
+}
+
+variable "twilio_phone_number" {
+  description = "Número de Twilio usado como origen por defecto de las campañas"
+  type        = string
+  default     = ""
+}
+
+variable "voice_service_url" {
+  description = "URL pública del servicio de orquestación de voz para los webhooks de las llamadas salientes (se conoce tras el primer despliegue)"
+  type        = string
+  default     = ""
+}
+
+variable "campaign_api_allowed_service_accounts" {
+  description = "Cuentas de servicio adicionales autorizadas a usar la API de administración del servicio de voz. La cuenta api_client_sa siempre está autorizada."
+  type        = list(string)
+  default     = []
+}
+
+variable "pubsub_topic" {
+  description = "Nombre del tópico de Pub/Sub para los eventos del ciclo de vida de las conversaciones"
+  type        = string
+  default     = "conversation-events"
+}
+
 variable "vector_search_index_id" {
   description = "ID del índice de Vector Search"
   type        = string
@@ -58,7 +211,133 @@ variable "vector_search_distance_measure" {
 }
 
 variable "allow_unauthenticated" {
-  description = "Permitir acceso no autenticado a los servicios de Cloud Run (solo para desarrollo)"
+  description = "Permitir acceso no autenticado al servicio de orquestación de voz (necesario para los webhooks de Twilio). El servicio de historial siempre requiere autenticación."
   type        = bool
   default     = true
 }
+
+variable "twiml_validation_mode" {
+  description = "Qué hacer con el TwiML inválido: enforce (responder la respuesta segura) o log (solo registrarlo)"
+  type        = string
+  default     = "enforce"
+}
+
+variable "ssml_enabled" {
+  description = "Convertir en SSML el texto de las respuestas (montos, fechas, teléfonos, códigos, pausas y énfasis)"
+  type        = string
+  default     = "true"
+}
+
+variable "prompts_dir" {
+  description = "Directorio con los mensajes de los tenants (<tenant>/<idioma>.json); vacío usa solo el catálogo incluido"
+  type        = string
+  default     = ""
+}
+
+variable "prompt_default_locale" {
+  description = "Idioma predeterminado de los mensajes del servicio"
+  type        = string
+  default     = "es-CL"
+}
+
+variable "prompt_fallback_locales" {
+  description = "Idiomas de respaldo de los mensajes, separados por comas"
+  type        = string
+  default     = "es-CL"
+}
+
+variable "language_detection_enabled" {
+  description = "Detectar el idioma en la primera respuesta del cliente y cambiar la llamada a ese idioma"
+  type        = string
+  default     = "true"
+}
+
+variable "language_detection_min_score" {
+  description = "Proporción mínima (0 a 1) de palabras del idioma detectado para cambiar de idioma"
+  type        = string
+  default     = "0.7"
+}
+
+variable "supported_languages" {
+  description = "Idiomas a los que puede cambiar la llamada, separados por comas"
+  type        = string
+  default     = "es-CL,en-US,pt-BR"
+}
+
+variable "language_voices" {
+  description = "Voz de Twilio de cada idioma (idioma=voz, separados por comas)"
+  type        = string
+  default     = "es-CL=Polly.Lupe,en-US=Polly.Joanna,pt-BR=Polly.Camila"
+}
+
+variable "no_input_escalation" {
+  description = "Pasos de la política de reintentos cuando el cliente no responde (reprompt, rephrase, dtmf, transfer, hangup)"
+  type        = string
+  default     = "reprompt,rephrase,dtmf,hangup"
+}
+
+variable "no_match_escalation" {
+  description = "Pasos de la política de reintentos cuando Dialogflow no reconoce la respuesta"
+  type        = string
+  default     = "reprompt,rephrase,dtmf,transfer"
+}
+
+variable "speech_confirmation_threshold" {
+  description = "Confianza mínima del reconocimiento de voz para no confirmar la transcripción con el cliente (0 la desactiva)"
+  type        = string
+  default     = "0.5"
+}
+
+variable "speech_confirmation_max_attempts" {
+  description = "Confirmaciones seguidas de la transcripción como máximo"
+  type        = string
+  default     = "2"
+}
+
+variable "speech_hints_enabled" {
+  description = "Agregar al reconocimiento de voz los hints de Dialogflow y del vocabulario de los tenants"
+  type        = string
+  default     = "true"
+}
+
+variable "speech_hints" {
+  description = "Hints de reconocimiento de voz comunes a todos los tenants, separados por comas"
+  type        = string
+  default     = ""
+}
+
+variable "speech_hint_boost" {
+  description = "Peso del refuerzo de frases de los modelos googlev2_*"
+  type        = string
+  default     = "10"
+}
+
+variable "speech_hints_refresh_seconds" {
+  description = "Segundos que se guardan en memoria los hints de cada página y tenant"
+  type        = string
+  default     = "600"
+}
+
+variable "speech_vocabulary_collection" {
+  description = "Colección de Firestore con el vocabulario de reconocimiento de voz de los tenants"
+  type        = string
+  default     = "speech_vocabulary"
+}
+
+variable "dtmf_timeout" {
+  description = "Segundos que espera el Gather entre dígitos en una captura por teclado"
+  type        = string
+  default     = "10"
+}
+
+variable "dtmf_max_attempts" {
+  description = "Intentos para marcar una opción de menú o un número válido"
+  type        = string
+  default     = "3"
+}
+
+variable "rut_dtmf_k_key" {
+  description = "Tecla con que se marca el dígito verificador K del RUT"
+  type        = string
+  default     = "*"
+}
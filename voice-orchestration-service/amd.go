@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+
+	"kairosia/internal/models"
+	"kairosia/internal/twiml"
+)
+
+const (
+	// amdStatusPath es la ruta del callback de la detección de contestador asíncrona
+	amdStatusPath = "/amd-status"
+
+	amdOutcomeHuman         = "human"
+	amdOutcomeVoicemailLeft = "voicemail_left"
+	amdOutcomeRetry         = "retry_scheduled"
+)
+
+// applyMachineDetection configura la detección de contestador (AMD) de una llamada saliente.
+// Se usa DetectMessageEnd para que el mensaje de voz se deje después del tono del buzón.
+// En modo asíncrono la llamada se conecta de inmediato y el resultado llega a callbackURL.
+func applyMachineDetection(params *twilioApi.CreateCallParams, callbackURL string) {
+	if !amdEnabled {
+		return
+	}
+
+	params.SetMachineDetection("DetectMessageEnd")
+	params.SetMachineDetectionTimeout(amdTimeout)
+	if amdAsync {
+		params.SetAsyncAmd("true")
+		params.SetAsyncAmdStatusCallback(callbackURL)
+		params.SetAsyncAmdStatusCallbackMethod("POST")
+	}
+}
+
+// isMachineAnswer indica si el valor AnsweredBy de Twilio corresponde a un contestador o un fax
+func isMachineAnswer(answeredBy string) bool {
+	switch answeredBy {
+	case "machine_start", "machine_end_beep", "machine_end_silence", "machine_end_other", "fax":
+		return true
+	}
+	return false
+}
+
+// recordHumanAnswer registra en el estado que la llamada la contestó una persona (o que AMD no pudo determinarlo)
+func recordHumanAnswer(state *models.ConversationState, answeredBy string) {
+	if answeredBy == "" {
+		return
+	}
+	state.AnsweredBy = answeredBy
+	state.MachineDetectionOutcome = amdOutcomeHuman
+}
+
+// handleMachineAnswer decide qué hacer ante un contestador y lo registra en el estado.
+// Si hay un mensaje de voz configurado se deja y se cuelga; si no, se cuelga para reintentar más tarde.
+func handleMachineAnswer(state *models.ConversationState, answeredBy string) *models.TwiMLResponse {
+	now := time.Now()
+	state.AnsweredBy = answeredBy
+	state.LastUpdateTimestamp = now
+
+	message := voicemailMessage(state)
+	if answeredBy == "fax" || message == "" {
+		state.MachineDetectionOutcome = amdOutcomeRetry
+		return &models.TwiMLResponse{Hangup: &models.TwiMLHangup{}}
+	}
+
+	state.MachineDetectionOutcome = amdOutcomeVoicemailLeft
+	state.RecentTurns = append(state.RecentTurns, models.TranscriptEntry{
+		Speaker:    "ai",
+		Text:       message,
+		Timestamp:  now,
+		Confidence: 1.0,
+	})
+	return &models.TwiMLResponse{
+		Say: &models.TwiMLSay{
+			Voice:    sayVoice(state),
+			Language: sayLanguage(state),
+			Value:    message,
+		},
+		Hangup: &models.TwiMLHangup{},
+	}
+}
+
+// voicemailMessage devuelve el mensaje de voz que corresponde a la llamada, o vacío si no se deja mensaje.
+// Las devoluciones de llamada dejan el mensaje del catálogo con el número al que el cliente puede llamar.
+func voicemailMessage(state *models.ConversationState) string {
+	if state.Campaign != nil {
+		return state.Campaign.VoicemailMessage
+	}
+	if state.Callback != nil {
+		return prompt(state, promptCallbackVoicemail, map[string]string{"phone_number": spokenPhoneNumber(businessNumber(state))})
+	}
+	return ""
+}
+
+// spokenPhoneNumber separa los dígitos de un número para que el TTS los lea uno a uno
+func spokenPhoneNumber(number string) string {
+	digits := make([]string, 0, len(number))
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, string(r))
+		}
+	}
+	return strings.Join(digits, " ")
+}
+
+// HandleAMDStatus recibe el resultado de la detección de contestador asíncrona.
+// Si contestó una máquina, redirige la llamada en curso al mensaje de voz o la cuelga.
+func HandleAMDStatus(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	callSid := r.FormValue("CallSid")
+	answeredBy := r.FormValue("AnsweredBy")
+	if callSid == "" {
+		http.Error(w, "Falta el parámetro CallSid", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	state, err := getConversationState(ctx, callSid)
+	if err != nil {
+		log.Printf("Error al obtener el estado de la conversación %s: %v", callSid, err)
+		http.Error(w, "Error al obtener el estado de la conversación", http.StatusInternalServerError)
+		return
+	}
+	if state == nil {
+		log.Printf("Resultado de AMD para una llamada sin estado: %s (%s)", callSid, answeredBy)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !isMachineAnswer(answeredBy) {
+		recordHumanAnswer(state, answeredBy)
+		if err := saveMachineDetection(ctx, state, nil); err != nil {
+			log.Printf("Error al registrar el resultado de AMD de %s: %v", callSid, err)
+			http.Error(w, "Error al registrar el resultado de AMD", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	turnsBefore := len(state.RecentTurns)
+	response := handleMachineAnswer(state, answeredBy)
+	xmlString, err := renderTwiML(response, twiml.New().Hangup())
+	if err != nil {
+		log.Printf("Error al serializar el TwiML: %v", err)
+		http.Error(w, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+
+	// Redirigir la llamada en curso al nuevo TwiML
+	params := &twilioApi.UpdateCallParams{}
+	params.SetTwiml(xmlString)
+	if _, err := getTwilioClient().Api.UpdateCall(callSid, params); err != nil {
+		log.Printf("Error al redirigir la llamada %s tras detectar un contestador: %v", callSid, err)
+		http.Error(w, "Error al redirigir la llamada", http.StatusInternalServerError)
+		return
+	}
+
+	if err := saveMachineDetection(ctx, state, state.RecentTurns[turnsBefore:]); err != nil {
+		log.Printf("Error al registrar el resultado de AMD de %s: %v", callSid, err)
+		http.Error(w, "Error al registrar el resultado de AMD", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveMachineDetection guarda solo los campos de AMD y las entradas nuevas de la transcripción,
+// para no pisar un turno de la conversación que se esté guardando al mismo tiempo.
+func saveMachineDetection(ctx context.Context, state *models.ConversationState, newEntries []models.TranscriptEntry) error {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	updates := []firestore.Update{
+		{Path: "answered_by", Value: state.AnsweredBy},
+		{Path: "machine_detection_outcome", Value: state.MachineDetectionOutcome},
+		{Path: "last_update_timestamp", Value: time.Now()},
+	}
+	if len(newEntries) > 0 {
+		entries := make([]interface{}, len(newEntries))
+		for i, entry := range newEntries {
+			entries[i] = entry
+		}
+		updates = append(updates, firestore.Update{Path: "recent_turns", Value: firestore.ArrayUnion(entries...)})
+	}
+
+	if _, err := client.Collection(firestoreCollection).Doc(state.CallSid).Update(ctx, updates); err != nil {
+		return fmt.Errorf("error al guardar el resultado de AMD: %v", err)
+	}
+	return nil
+}
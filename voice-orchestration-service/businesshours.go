@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"kairosia/internal/businesshours"
+	"kairosia/internal/models"
+	"kairosia/internal/twiml"
+)
+
+const (
+	// afterHoursHandoffTransfer transfiere igual fuera de horario; la tabla de enrutamiento puede
+	// dirigir la llamada a un destino de guardia con el criterio business_hours "closed".
+	// Las demás alternativas son las de HANDOFF_FALLBACK.
+	afterHoursHandoffTransfer = "transfer"
+
+	// Valores del criterio business_hours de las reglas de enrutamiento
+	routingBusinessHoursOpen   = "open"
+	routingBusinessHoursClosed = "closed"
+)
+
+// clock es el reloj del servicio para el horario de atención y el enrutamiento; se reemplaza para probarlos
+var clock = time.Now
+
+// evaluateBusinessHours evalúa el horario de atención del tenant al inicio de la llamada y lo guarda en el estado.
+// Sin horario configurado el estado no cambia y la llamada se atiende como dentro de horario.
+func evaluateBusinessHours(ctx context.Context, state *models.ConversationState) {
+	hours, err := loadBusinessHours(ctx, state.TenantID)
+	if err != nil {
+		log.Printf("Error al cargar el horario de atención del tenant %s: %v", state.TenantID, err)
+		return
+	}
+	if hours == nil {
+		return
+	}
+
+	result, err := businesshours.Evaluate(*hours, clock())
+	if err != nil {
+		log.Printf("Error al evaluar el horario de atención del tenant %s: %v", state.TenantID, err)
+		return
+	}
+
+	state.BusinessHours = &models.BusinessHoursStatus{
+		Open:               result.Open,
+		Holiday:            result.Holiday,
+		AfterHoursGreeting: hours.AfterHoursGreeting,
+		AfterHoursHandoff:  hours.AfterHoursHandoff,
+	}
+	if state.BusinessHours.AfterHoursGreeting == "" {
+		state.BusinessHours.AfterHoursGreeting = afterHoursGreeting
+	}
+	if state.BusinessHours.AfterHoursGreeting == "" {
+		state.BusinessHours.AfterHoursGreeting = prompt(state, promptAfterHoursGreeting, nil)
+	}
+	if state.BusinessHours.AfterHoursHandoff == "" {
+		state.BusinessHours.AfterHoursHandoff = afterHoursHandoff
+	}
+}
+
+// loadBusinessHours obtiene el horario de atención del tenant (documento con el ID del tenant).
+// Si el tenant no tiene uno, usa el de BUSINESS_HOURS; devuelve nil si tampoco está configurado.
+func loadBusinessHours(ctx context.Context, tenantID string) (*models.BusinessHours, error) {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	doc, err := client.Collection(businessHoursCollection).Doc(tenantID).Get(ctx)
+	if err == nil {
+		var hours models.BusinessHours
+		if err := doc.DataTo(&hours); err != nil {
+			return nil, fmt.Errorf("error al parsear el horario de atención: %v", err)
+		}
+		if hours.Timezone == "" {
+			hours.Timezone = businessHoursTimezone
+		}
+		return &hours, nil
+	}
+	if status.Code(err) != codes.NotFound {
+		return nil, fmt.Errorf("error al obtener el horario de atención: %v", err)
+	}
+
+	if len(businessHoursWindows) == 0 {
+		return nil, nil
+	}
+	return &models.BusinessHours{
+		TenantID:        tenantID,
+		Timezone:        businessHoursTimezone,
+		Windows:         businessHoursWindows,
+		HolidayCalendar: businessHoursHolidays,
+	}, nil
+}
+
+// isAfterHours indica si la llamada comenzó fuera del horario de atención o en un feriado
+func isAfterHours(state *models.ConversationState) bool {
+	return state.BusinessHours != nil && !state.BusinessHours.Open
+}
+
+// handoffClosed indica si la llamada está fuera de horario y el tenant no transfiere fuera de horario
+func handoffClosed(state *models.ConversationState) bool {
+	return isAfterHours(state) && state.BusinessHours.AfterHoursHandoff != afterHoursHandoffTransfer
+}
+
+// afterHoursHandoffDocument responde a una solicitud de transferencia fuera de horario con la alternativa
+// del tenant (AFTER_HOURS_HANDOFF): devolución de llamada, buzón de voz o seguir con la IA
+func afterHoursHandoffDocument(ctx context.Context, state *models.ConversationState) *twiml.Response {
+	log.Printf("Transferencia solicitada fuera del horario de atención en la llamada %s; se aplica %s", state.CallSid, state.BusinessHours.AfterHoursHandoff)
+	return handoffFallbackDocument(ctx, state, state.BusinessHours.AfterHoursHandoff)
+}
+
+// businessHoursSessionParameters informa a Dialogflow si la llamada está dentro del horario de atención,
+// para que el agente dirija las llamadas fuera de horario a sus flujos y no ofrezca hablar con un agente
+func businessHoursSessionParameters(state *models.ConversationState) map[string]interface{} {
+	if state.BusinessHours == nil {
+		return nil
+	}
+	params := map[string]interface{}{
+		"business_hours_open": state.BusinessHours.Open,
+		"handoff_available":   state.BusinessHours.Open || state.BusinessHours.AfterHoursHandoff == afterHoursHandoffTransfer,
+	}
+	if state.BusinessHours.Holiday != "" {
+		params["holiday"] = state.BusinessHours.Holiday
+	}
+	return params
+}
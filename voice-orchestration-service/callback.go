@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"kairosia/internal/models"
+)
+
+const (
+	// callbackCallStatusPath es la ruta del status callback de las devoluciones de llamada
+	callbackCallStatusPath = "/callback-call-status"
+
+	callbackStatusPending   = "pending"
+	callbackStatusDialing   = "dialing"
+	callbackStatusCompleted = "completed"
+	callbackStatusFailed    = "failed"
+
+	// callbackContextTurns es el número de turnos de la llamada original que se conservan en la devolución
+	callbackContextTurns = 10
+
+	callbackDefaultReason = "Devolución de llamada solicitada por el cliente"
+)
+
+// HandleCallbackCallStatus recibe el status callback de las devoluciones de llamada
+func HandleCallbackCallStatus(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	callbackID := r.FormValue("callback_id")
+	callSid := r.FormValue("CallSid")
+	callStatus := r.FormValue("CallStatus")
+	if callbackID == "" || callSid == "" {
+		http.Error(w, "Faltan los parámetros de la devolución de llamada", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	// Si la llamada terminó, cerrar primero la conversación para obtener su resultado
+	var state *models.ConversationState
+	if isFinalCallStatus(callStatus) {
+		var err error
+		state, err = recordCallEnded(ctx, callSid, callStatus, r.FormValue("CallDuration"))
+		if err != nil {
+			log.Printf("Error al guardar el fin de la llamada %s: %v", callSid, err)
+			http.Error(w, "Error al guardar el fin de la llamada", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := recordCallbackCallStatus(ctx, callbackID, callSid, callStatus, r.FormValue("AnsweredBy"), state); err != nil {
+		log.Printf("Error al registrar el resultado de la devolución de llamada %s: %v", callbackID, err)
+		http.Error(w, "Error al registrar el resultado de la devolución de llamada", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DispatchCallbacks realiza las devoluciones de llamada vencidas bajo demanda (por ejemplo, desde Cloud Scheduler)
+func DispatchCallbacks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	placed, err := dispatchCallbacks(r.Context())
+	if err != nil {
+		log.Printf("Error al despachar las devoluciones de llamada: %v", err)
+		http.Error(w, "Error al despachar las devoluciones de llamada", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"placed": placed})
+}
+
+// runCallbackDispatcher realiza periódicamente las devoluciones de llamada vencidas
+func runCallbackDispatcher() {
+	ticker := time.NewTicker(callbackDispatchInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := dispatchCallbacks(context.Background()); err != nil {
+			log.Printf("Error al despachar las devoluciones de llamada: %v", err)
+		}
+	}
+}
+
+// dispatchCallbacks llama a los clientes cuya devolución de llamada ya venció.
+// Antes libera las devoluciones que quedaron marcándose sin recibir su estado final.
+func dispatchCallbacks(ctx context.Context) (int, error) {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return 0, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	if err := releaseStaleCallbacks(ctx, client); err != nil {
+		log.Printf("Error al liberar las devoluciones de llamada sin estado final: %v", err)
+	}
+
+	docs, err := client.Collection(callbackCollection).
+		Where("status", "==", callbackStatusPending).
+		Where("next_attempt_at", "<=", time.Now()).
+		OrderBy("next_attempt_at", firestore.Asc).
+		Limit(callbackBatchSize).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("error al consultar las devoluciones de llamada pendientes: %v", err)
+	}
+
+	placed := 0
+	for _, doc := range docs {
+		callback, err := claimCallback(ctx, client, doc.Ref)
+		if err != nil {
+			log.Printf("Error al reservar la devolución de llamada %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if callback == nil {
+			// Otra instancia ya la reservó
+			continue
+		}
+
+		if err := placeCallbackCall(ctx, client, callback); err != nil {
+			log.Printf("Error al realizar la devolución de llamada %s: %v", callback.ID, err)
+			continue
+		}
+		placed++
+	}
+
+	return placed, nil
+}
+
+// releaseStaleCallbacks libera las devoluciones marcadas hace más de CALLBACK_STALE_CALL_MINUTES que Twilio
+// ya no tiene en curso, por ejemplo si el proceso terminó antes de crear la llamada o se perdió el status
+// callback. Si Twilio dio la llamada por completada se registra como tal; si no, se reprograma.
+func releaseStaleCallbacks(ctx context.Context, client *firestore.Client) error {
+	docs, err := client.Collection(callbackCollection).
+		Where("status", "==", callbackStatusDialing).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("error al consultar las devoluciones en curso: %v", err)
+	}
+
+	cutoff := time.Now().Add(-callbackStaleCallTimeout)
+	for _, doc := range docs {
+		var callback models.CallbackRequest
+		if err := doc.DataTo(&callback); err != nil {
+			log.Printf("Error al convertir la devolución de llamada %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if callback.DialedAt.After(cutoff) {
+			continue
+		}
+		callStatus, err := fetchOutboundCallStatus(callback.CallSid)
+		if err != nil {
+			log.Printf("Error al consultar en Twilio la llamada %s de la devolución %s: %v", callback.CallSid, callback.ID, err)
+			continue
+		}
+		if callStatus != "" && !isFinalCallStatus(callStatus) {
+			continue
+		}
+		if err := releaseStaleCallback(ctx, client, doc.Ref, callback.CallSid, callStatus); err != nil {
+			log.Printf("Error al liberar la devolución de llamada %s: %v", callback.ID, err)
+		}
+	}
+	return nil
+}
+
+// releaseStaleCallback registra el estado final informado por Twilio en una devolución sin status callback,
+// siempre que siga marcándose con la misma llamada
+func releaseStaleCallback(ctx context.Context, client *firestore.Client, ref *firestore.DocumentRef, callSid, callStatus string) error {
+	return client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var callback models.CallbackRequest
+		if err := doc.DataTo(&callback); err != nil {
+			return err
+		}
+		if callback.Status != callbackStatusDialing || callback.CallSid != callSid {
+			return nil
+		}
+
+		now := time.Now()
+		callback.LastCallStatus = callStatus
+		if callStatus == "completed" {
+			callback.Status = callbackStatusCompleted
+			callback.LastError = ""
+			callback.UpdatedAt = now
+		} else {
+			callback.LastError = "no se recibió el estado final de la llamada"
+			scheduleCallbackRetry(&callback, now)
+		}
+		return tx.Set(ref, &callback)
+	})
+}
+
+// claimCallback reserva una devolución de llamada vencida. Devuelve nil si ya no está disponible.
+func claimCallback(ctx context.Context, client *firestore.Client, ref *firestore.DocumentRef) (*models.CallbackRequest, error) {
+	var claimed *models.CallbackRequest
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = nil
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+
+		var callback models.CallbackRequest
+		if err := doc.DataTo(&callback); err != nil {
+			return err
+		}
+		now := time.Now()
+		if callback.Status != callbackStatusPending || callback.NextAttemptAt.After(now) {
+			return nil
+		}
+
+		callback.Status = callbackStatusDialing
+		callback.Attempts++
+		callback.CallSid = ""
+		callback.LastCallStatus = ""
+		callback.DialedAt = now
+		callback.UpdatedAt = now
+		claimed = &callback
+		return tx.Set(ref, &callback)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// placeCallbackCall inicia la llamada de devolución. Al contestar, Twilio solicita HandleVoiceRequest
+// con el ID de la devolución, que restaura el contexto de la llamada original.
+func placeCallbackCall(ctx context.Context, client *firestore.Client, callback *models.CallbackRequest) error {
+	query := url.Values{}
+	query.Set("callback_id", callback.ID)
+
+	fromNumber := callback.FromNumber
+	if fromNumber == "" {
+		fromNumber = twilioPhoneNumber
+	}
+
+	params := &twilioApi.CreateCallParams{}
+	params.SetTo(callback.PhoneNumber)
+	params.SetFrom(fromNumber)
+	params.SetUrl(voiceWebhookURL("", query))
+	params.SetMethod("POST")
+	params.SetStatusCallback(voiceWebhookURL(callbackCallStatusPath, query))
+	params.SetStatusCallbackMethod("POST")
+	params.SetStatusCallbackEvent([]string{"answered", "completed"})
+	params.SetTimeout(callbackRingTimeout)
+	applyMachineDetection(params, voiceWebhookURL(amdStatusPath, query))
+
+	ref := client.Collection(callbackCollection).Doc(callback.ID)
+	call, err := getTwilioClient().Api.CreateCall(params)
+	if err != nil {
+		callback.LastError = fmt.Sprintf("error al crear la llamada: %v", err)
+		scheduleCallbackRetry(callback, time.Now())
+		if _, setErr := ref.Set(ctx, callback); setErr != nil {
+			log.Printf("Error al reprogramar la devolución de llamada %s: %v", callback.ID, setErr)
+		}
+		return err
+	}
+
+	if call.Sid != nil {
+		if err := storeOutboundCallSid(ctx, client, ref, *call.Sid); err != nil {
+			log.Printf("Error al guardar el CallSid de la devolución de llamada %s: %v", callback.ID, err)
+		}
+	}
+	return nil
+}
+
+// recordCallbackCallStatus registra el estado de una devolución de llamada.
+// Las llamadas no contestadas, o contestadas por una máquina, se reprograman hasta agotar los intentos.
+func recordCallbackCallStatus(ctx context.Context, callbackID, callSid, callStatus, answeredBy string, state *models.ConversationState) error {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	ref := client.Collection(callbackCollection).Doc(callbackID)
+	return client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			log.Printf("Status callback para una devolución de llamada inexistente: %s", callbackID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var callback models.CallbackRequest
+		if err := doc.DataTo(&callback); err != nil {
+			return err
+		}
+
+		// Ignorar callbacks de intentos anteriores o repetidos
+		if callback.CallSid != "" && callback.CallSid != callSid {
+			return nil
+		}
+		if callback.Status != callbackStatusDialing {
+			return nil
+		}
+
+		now := time.Now()
+		callback.CallSid = callSid
+		callback.LastCallStatus = callStatus
+		callback.UpdatedAt = now
+		if answeredBy != "" {
+			callback.AnsweredBy = answeredBy
+		}
+
+		if !isFinalCallStatus(callStatus) {
+			return tx.Set(ref, &callback)
+		}
+
+		machineRetry := state != nil && state.MachineDetectionOutcome == amdOutcomeRetry
+		switch {
+		case callStatus == "completed" && !machineRetry:
+			callback.Status = callbackStatusCompleted
+			callback.LastError = ""
+		case machineRetry:
+			callback.LastError = "contestó un contestador automático"
+			scheduleCallbackRetry(&callback, now)
+		default:
+			callback.LastError = fmt.Sprintf("la llamada terminó con estado %s", callStatus)
+			scheduleCallbackRetry(&callback, now)
+		}
+		return tx.Set(ref, &callback)
+	})
+}
+
+// scheduleCallbackRetry deja la devolución pendiente para un nuevo intento o fallida si se agotaron los intentos
+func scheduleCallbackRetry(callback *models.CallbackRequest, now time.Time) {
+	callback.UpdatedAt = now
+	if callback.Attempts >= callbackMaxAttempts {
+		callback.Status = callbackStatusFailed
+		return
+	}
+	callback.Status = callbackStatusPending
+	callback.NextAttemptAt = now.Add(callbackRetryDelay)
+}
+
+// isScheduleCallbackAction indica si Dialogflow capturó el horario de una devolución de llamada
+func isScheduleCallbackAction(result *models.DialogflowQueryResult) bool {
+	if result.CustomPayload == nil {
+		return false
+	}
+	action, _ := result.CustomPayload["action"].(string)
+	return action == "ScheduleCallback"
+}
+
+// scheduleCallback guarda la devolución de llamada con el horario capturado por Dialogflow.
+// El ID se deriva de la llamada original, por lo que reprogramarla reemplaza el horario anterior.
+func scheduleCallback(ctx context.Context, state *models.ConversationState, result *models.DialogflowQueryResult) (*models.CallbackRequest, error) {
+	scheduledAt, err := parseCallbackTime(result)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if scheduledAt.Before(now) {
+		scheduledAt = now
+	}
+
+	reason := state.HandoffReason
+	if reason == "" {
+		reason = callbackDefaultReason
+	}
+
+	callback := &models.CallbackRequest{
+		ID:              fmt.Sprintf("%s-callback", state.CallSid),
+		TenantID:        state.TenantID,
+		OriginalCallSid: state.CallSid,
+		PhoneNumber:     customerNumber(state),
+		FromNumber:      businessNumber(state),
+		Reason:          reason,
+		ContextTurns:    lastTurnsWithoutEmbeddings(state.RecentTurns, callbackContextTurns),
+		ScheduledAt:     scheduledAt,
+		Status:          callbackStatusPending,
+		NextAttemptAt:   scheduledAt,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Collection(callbackCollection).Doc(callback.ID).Set(ctx, callback); err != nil {
+		return nil, fmt.Errorf("error al guardar la devolución de llamada: %v", err)
+	}
+
+	log.Printf("Devolución de llamada %s programada para %s", callback.ID, scheduledAt.Format(time.RFC3339))
+	return callback, nil
+}
+
+// parseCallbackTime obtiene el horario de la devolución desde el payload (callbackTime, RFC 3339)
+// o desde el parámetro callback_time de Dialogflow (texto RFC 3339 o un @sys.date-time).
+func parseCallbackTime(result *models.DialogflowQueryResult) (time.Time, error) {
+	if value, ok := result.CustomPayload["callbackTime"].(string); ok && value != "" {
+		return time.Parse(time.RFC3339, value)
+	}
+
+	location, err := time.LoadLocation(callbackTimezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("zona horaria de devoluciones inválida: %s", callbackTimezone)
+	}
+
+	switch value := result.Parameters["callback_time"].(type) {
+	case string:
+		if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+			return parsed, nil
+		}
+		return time.ParseInLocation("2006-01-02T15:04:05", value, location)
+	case map[string]interface{}:
+		number := func(key string) int {
+			n, _ := value[key].(float64)
+			return int(n)
+		}
+		if number("year") == 0 || number("month") == 0 || number("day") == 0 {
+			return time.Time{}, fmt.Errorf("el parámetro callback_time no incluye la fecha")
+		}
+		return time.Date(number("year"), time.Month(number("month")), number("day"), number("hours"), number("minutes"), 0, 0, location), nil
+	}
+
+	return time.Time{}, fmt.Errorf("Dialogflow no devolvió el horario de la devolución de llamada")
+}
+
+// loadCallbackContext restaura el contexto de la llamada original en una devolución de llamada contestada
+func loadCallbackContext(ctx context.Context, callbackID string) (*models.CallbackContext, string, error) {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, "", fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	doc, err := client.Collection(callbackCollection).Doc(callbackID).Get(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("error al obtener la devolución de llamada %s: %v", callbackID, err)
+	}
+	var callback models.CallbackRequest
+	if err := doc.DataTo(&callback); err != nil {
+		return nil, "", fmt.Errorf("error al convertir el documento a CallbackRequest: %v", err)
+	}
+
+	return &models.CallbackContext{
+		CallbackID:      callback.ID,
+		OriginalCallSid: callback.OriginalCallSid,
+		Reason:          callback.Reason,
+		PreviousTurns:   callback.ContextTurns,
+	}, callback.TenantID, nil
+}
+
+// startCallbackHandoff saluda al cliente en una devolución de llamada y lo transfiere al agente
+// con el motivo de la llamada original
+func startCallbackHandoff(ctx context.Context, state *models.ConversationState) *models.TwiMLResponse {
+	handoffPayload := &models.LiveAgentHandoffPayload{
+		Action:           "LiveAgentHandoff",
+		TransferNumber:   transferPhoneNumber,
+		Reason:           state.Callback.Reason,
+		PreserveContext:  true,
+		AlternateNumbers: transferAlternateNumbers,
+	}
+	return startHandoff(ctx, state, handoffPayload, prompt(state, promptCallbackGreeting, nil))
+}
+
+// callbackSessionParameters devuelve el contexto de la devolución de llamada como parámetros de sesión de Dialogflow
+func callbackSessionParameters(state *models.ConversationState) map[string]interface{} {
+	params := map[string]interface{}{}
+	if state.CallbackOffered {
+		params["callback_offered"] = true
+	}
+	if state.Callback != nil {
+		params["callback_id"] = state.Callback.CallbackID
+		params["original_call_sid"] = state.Callback.OriginalCallSid
+		params["callback_reason"] = state.Callback.Reason
+
+		var previous strings.Builder
+		for _, turn := range state.Callback.PreviousTurns {
+			previous.WriteString(fmt.Sprintf("%s: %s\n", turn.Speaker, turn.Text))
+		}
+		params["previous_conversation"] = previous.String()
+	}
+	return params
+}
+
+// lastTurnsWithoutEmbeddings copia los últimos turnos sin sus embeddings, para guardarlos como contexto
+func lastTurnsWithoutEmbeddings(turns []models.TranscriptEntry, limit int) []models.TranscriptEntry {
+	if len(turns) > limit {
+		turns = turns[len(turns)-limit:]
+	}
+	copied := make([]models.TranscriptEntry, len(turns))
+	for i, turn := range turns {
+		turn.Embedding = nil
+		copied[i] = turn
+	}
+	return copied
+}
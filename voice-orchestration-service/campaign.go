@@ -0,0 +1,1074 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/twilio/twilio-go"
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"kairosia/internal/models"
+	"kairosia/internal/utils"
+)
+
+const (
+	campaignStatusDraft     = "draft"
+	campaignStatusActive    = "active"
+	campaignStatusPaused    = "paused"
+	campaignStatusCompleted = "completed"
+
+	contactStatusPending    = "pending"
+	contactStatusDialing    = "dialing"
+	contactStatusInProgress = "in_progress"
+	contactStatusCompleted  = "completed"
+	contactStatusFailed     = "failed"
+
+	// campaignCallStatusPath es la ruta del status callback de las llamadas de campaña
+	campaignCallStatusPath = "/campaign-call-status"
+)
+
+var (
+	twilioClientOnce sync.Once
+	twilioClient     *twilio.RestClient
+
+	// e164Pattern valida números de teléfono en formato E.164
+	e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+	// templateVariablePattern reconoce las variables {{nombre}} de las plantillas de campaña
+	templateVariablePattern = regexp.MustCompile(`{{\s*([A-Za-z0-9_]+)\s*}}`)
+)
+
+// createCampaignRequest representa la solicitud de creación de una campaña con sus contactos iniciales
+type createCampaignRequest struct {
+	models.Campaign
+	Contacts []campaignContactInput `json:"contacts,omitempty"`
+}
+
+// campaignContactInput representa un contacto cargado en una campaña
+type campaignContactInput struct {
+	PhoneNumber string            `json:"phone_number"`
+	Variables   map[string]string `json:"variables,omitempty"`
+}
+
+// contactUploadResult resume el resultado de una carga de contactos
+type contactUploadResult struct {
+	Added      int      `json:"added"`
+	Duplicates int      `json:"duplicates"`
+	Invalid    []string `json:"invalid,omitempty"`
+}
+
+// getTwilioClient obtiene el cliente REST de Twilio.
+// Las credenciales se leen de TWILIO_ACCOUNT_SID y TWILIO_AUTH_TOKEN.
+func getTwilioClient() *twilio.RestClient {
+	twilioClientOnce.Do(func() {
+		twilioClient = twilio.NewRestClient()
+	})
+	return twilioClient
+}
+
+// CreateCampaign crea una campaña de llamadas salientes, opcionalmente con sus contactos
+func CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request createCampaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error al decodificar la campaña: %v", err)
+		http.Error(w, "Error al decodificar la campaña", http.StatusBadRequest)
+		return
+	}
+
+	campaign := request.Campaign
+	if err := normalizeCampaign(&campaign); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Printf("Error al crear el cliente de Firestore: %v", err)
+		http.Error(w, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	defer client.Close()
+
+	now := time.Now()
+	ref := client.Collection(campaignCollection).NewDoc()
+	campaign.ID = ref.ID
+	campaign.CreatedAt = now
+	campaign.UpdatedAt = now
+	if _, err := ref.Create(ctx, &campaign); err != nil {
+		log.Printf("Error al guardar la campaña: %v", err)
+		http.Error(w, "Error al guardar la campaña", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := addCampaignContacts(ctx, client, &campaign, request.Contacts)
+	if err != nil {
+		log.Printf("Error al guardar los contactos de la campaña %s: %v", campaign.ID, err)
+		http.Error(w, "Error al guardar los contactos de la campaña", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"campaign": campaign,
+		"contacts": result,
+	})
+}
+
+// UploadCampaignContacts agrega contactos a una campaña existente.
+// Acepta JSON ({"contacts": [...]}) o CSV con una columna phone_number; el resto de columnas son variables del contacto.
+func UploadCampaignContacts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	campaignID := r.URL.Query().Get("campaign_id")
+	if campaignID == "" {
+		http.Error(w, "Falta el parámetro campaign_id", http.StatusBadRequest)
+		return
+	}
+
+	var inputs []campaignContactInput
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		parsed, err := parseContactsCSV(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		inputs = parsed
+	} else {
+		var body struct {
+			Contacts []campaignContactInput `json:"contacts"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			log.Printf("Error al decodificar los contactos: %v", err)
+			http.Error(w, "Error al decodificar los contactos", http.StatusBadRequest)
+			return
+		}
+		inputs = body.Contacts
+	}
+
+	ctx := r.Context()
+
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Printf("Error al crear el cliente de Firestore: %v", err)
+		http.Error(w, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	defer client.Close()
+
+	campaign, err := getCampaign(ctx, client, campaignID)
+	if err != nil {
+		log.Printf("Error al obtener la campaña %s: %v", campaignID, err)
+		http.Error(w, "Error al obtener la campaña", http.StatusInternalServerError)
+		return
+	}
+	if campaign == nil {
+		http.Error(w, "Campaña no encontrada", http.StatusNotFound)
+		return
+	}
+
+	result, err := addCampaignContacts(ctx, client, campaign, inputs)
+	if err != nil {
+		log.Printf("Error al guardar los contactos de la campaña %s: %v", campaignID, err)
+		http.Error(w, "Error al guardar los contactos de la campaña", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// SetCampaignStatus activa o pausa una campaña
+func SetCampaignStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	campaignID := r.URL.Query().Get("campaign_id")
+	newStatus := r.URL.Query().Get("status")
+	if campaignID == "" || (newStatus != campaignStatusActive && newStatus != campaignStatusPaused) {
+		http.Error(w, "Se requieren campaign_id y status (active o paused)", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Printf("Error al crear el cliente de Firestore: %v", err)
+		http.Error(w, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	defer client.Close()
+
+	_, err = client.Collection(campaignCollection).Doc(campaignID).Update(ctx, []firestore.Update{
+		{Path: "status", Value: newStatus},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	if status.Code(err) == codes.NotFound {
+		http.Error(w, "Campaña no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error al actualizar el estado de la campaña %s: %v", campaignID, err)
+		http.Error(w, "Error al actualizar el estado de la campaña", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetCampaign devuelve una campaña con el número de contactos en cada estado
+func GetCampaign(w http.ResponseWriter, r *http.Request) {
+	campaignID := r.URL.Query().Get("campaign_id")
+	if campaignID == "" {
+		http.Error(w, "Falta el parámetro campaign_id", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Printf("Error al crear el cliente de Firestore: %v", err)
+		http.Error(w, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+	defer client.Close()
+
+	campaign, err := getCampaign(ctx, client, campaignID)
+	if err != nil {
+		log.Printf("Error al obtener la campaña %s: %v", campaignID, err)
+		http.Error(w, "Error al obtener la campaña", http.StatusInternalServerError)
+		return
+	}
+	if campaign == nil {
+		http.Error(w, "Campaña no encontrada", http.StatusNotFound)
+		return
+	}
+
+	summary := &models.CampaignSummary{Campaign: campaign, Contacts: map[string]int64{}}
+	for _, contactStatus := range []string{contactStatusPending, contactStatusDialing, contactStatusInProgress, contactStatusCompleted, contactStatusFailed} {
+		count, err := countCampaignContacts(ctx, client, campaignID, contactStatus)
+		if err != nil {
+			log.Printf("Error al contar los contactos de la campaña %s: %v", campaignID, err)
+			http.Error(w, "Error al contar los contactos de la campaña", http.StatusInternalServerError)
+			return
+		}
+		summary.Contacts[contactStatus] = count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// DispatchCampaigns realiza las llamadas pendientes de las campañas activas bajo demanda.
+// Igual que DispatchOutbox, en Cloud Run se invoca periódicamente con Cloud Scheduler.
+func DispatchCampaigns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	placed, err := dispatchCampaignCalls(r.Context())
+	if err != nil {
+		log.Printf("Error al despachar las campañas: %v", err)
+		http.Error(w, "Error al despachar las campañas", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"placed": placed})
+}
+
+// HandleCampaignCallStatus recibe el status callback de las llamadas de campaña y registra el resultado del contacto
+func HandleCampaignCallStatus(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	campaignID := r.FormValue("campaign_id")
+	contactID := r.FormValue("contact_id")
+	callSid := r.FormValue("CallSid")
+	callStatus := r.FormValue("CallStatus")
+	if campaignID == "" || contactID == "" || callSid == "" {
+		http.Error(w, "Faltan los parámetros de la campaña", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	// Si la llamada terminó, cerrar primero la conversación para obtener su resultado
+	var state *models.ConversationState
+	if isFinalCallStatus(callStatus) {
+		var err error
+		state, err = recordCallEnded(ctx, callSid, callStatus, r.FormValue("CallDuration"))
+		if err != nil {
+			log.Printf("Error al guardar el fin de la llamada %s: %v", callSid, err)
+			http.Error(w, "Error al guardar el fin de la llamada", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := recordCampaignCallStatus(ctx, campaignID, contactID, callSid, callStatus, r.FormValue("CallDuration"), r.FormValue("AnsweredBy"), state); err != nil {
+		log.Printf("Error al registrar el resultado del contacto %s: %v", contactID, err)
+		http.Error(w, "Error al registrar el resultado del contacto", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runCampaignDispatcher realiza periódicamente las llamadas pendientes de las campañas activas
+func runCampaignDispatcher() {
+	ticker := time.NewTicker(campaignDispatchInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := dispatchCampaignCalls(context.Background()); err != nil {
+			log.Printf("Error al despachar las campañas: %v", err)
+		}
+	}
+}
+
+// dispatchCampaignCalls realiza las llamadas de las campañas activas que están dentro de su horario
+func dispatchCampaignCalls(ctx context.Context) (int, error) {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return 0, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	docs, err := client.Collection(campaignCollection).Where("status", "==", campaignStatusActive).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("error al consultar las campañas activas: %v", err)
+	}
+
+	placed := 0
+	now := time.Now()
+	for _, doc := range docs {
+		var campaign models.Campaign
+		if err := doc.DataTo(&campaign); err != nil {
+			log.Printf("Error al convertir la campaña %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if !withinCallingHours(campaign.CallingHours, now) {
+			continue
+		}
+
+		contacts, err := claimCampaignContacts(ctx, client, &campaign)
+		if err != nil {
+			log.Printf("Error al reservar los contactos de la campaña %s: %v", campaign.ID, err)
+			continue
+		}
+		if len(contacts) == 0 {
+			if err := completeCampaignIfDone(ctx, client, &campaign); err != nil {
+				log.Printf("Error al verificar el fin de la campaña %s: %v", campaign.ID, err)
+			}
+			continue
+		}
+
+		for _, contact := range contacts {
+			if err := placeCampaignCall(ctx, client, &campaign, contact); err != nil {
+				log.Printf("Error al llamar al contacto %s de la campaña %s: %v", contact.ID, campaign.ID, err)
+				continue
+			}
+			placed++
+		}
+	}
+
+	return placed, nil
+}
+
+// claimCampaignContacts reserva tantos contactos pendientes como llamadas simultáneas permita la campaña.
+// El conteo de llamadas en curso y la reserva ocurren en la misma transacción, por lo que varias
+// instancias del despachador no superan el límite. Las llamadas marcadas hace más de
+// CAMPAIGN_STALE_CALL_MINUTES que Twilio ya no tiene en curso se liberan y se reprograman.
+func claimCampaignContacts(ctx context.Context, client *firestore.Client, campaign *models.Campaign) ([]*models.CampaignContact, error) {
+	contacts := client.Collection(campaignContactsCollection)
+
+	finished, err := finishedStaleCampaignCalls(ctx, client, campaign)
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []*models.CampaignContact
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = nil
+		now := time.Now()
+
+		activeDocs, err := tx.Documents(contacts.
+			Where("campaign_id", "==", campaign.ID).
+			Where("status", "in", []string{contactStatusDialing, contactStatusInProgress})).GetAll()
+		if err != nil {
+			return err
+		}
+
+		busy := 0
+		var stale []*models.CampaignContact
+		for _, doc := range activeDocs {
+			var contact models.CampaignContact
+			if err := doc.DataTo(&contact); err != nil {
+				return err
+			}
+			// Solo se libera la llamada que Twilio dio por terminada, y si el contacto no cambió desde la consulta
+			if call, ok := finished[contact.ID]; ok && call.callSid == contact.CallSid {
+				contact.LastCallStatus = call.status
+				stale = append(stale, &contact)
+				continue
+			}
+			busy++
+		}
+
+		var pendingDocs []*firestore.DocumentSnapshot
+		if slots := campaign.MaxConcurrentCalls - busy; slots > 0 {
+			pendingDocs, err = tx.Documents(contacts.
+				Where("campaign_id", "==", campaign.ID).
+				Where("status", "==", contactStatusPending).
+				Where("next_attempt_at", "<=", now).
+				OrderBy("next_attempt_at", firestore.Asc).
+				Limit(slots)).GetAll()
+			if err != nil {
+				return err
+			}
+		}
+
+		// Todas las lecturas de la transacción ocurren antes de las escrituras
+		for _, contact := range stale {
+			if contact.LastCallStatus == "completed" {
+				// La llamada terminó normalmente, pero se perdió su status callback: no se vuelve a llamar
+				contact.Status = contactStatusCompleted
+				contact.Outcome = contact.LastCallStatus
+				contact.LastError = ""
+				contact.UpdatedAt = now
+			} else {
+				contact.LastError = "no se recibió el estado final de la llamada"
+				scheduleContactRetry(campaign, contact, now)
+			}
+			if err := tx.Set(contacts.Doc(contact.ID), contact); err != nil {
+				return err
+			}
+		}
+
+		for _, doc := range pendingDocs {
+			var contact models.CampaignContact
+			if err := doc.DataTo(&contact); err != nil {
+				return err
+			}
+			contact.Status = contactStatusDialing
+			contact.Attempts++
+			contact.CallSid = ""
+			contact.LastCallStatus = ""
+			contact.DialedAt = now
+			contact.UpdatedAt = now
+			if err := tx.Set(doc.Ref, &contact); err != nil {
+				return err
+			}
+			claimed = append(claimed, &contact)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// staleCall identifica una llamada saliente marcada hace más del tiempo límite y su estado en Twilio
+type staleCall struct {
+	callSid string
+	status  string
+}
+
+// finishedStaleCampaignCalls devuelve, por ID de contacto, las llamadas de la campaña marcadas hace más de
+// CAMPAIGN_STALE_CALL_MINUTES que Twilio ya no tiene en curso. Una llamada contestada que dura más que el
+// límite sigue en curso en Twilio y no se libera. Twilio se consulta fuera de la transacción de la reserva.
+func finishedStaleCampaignCalls(ctx context.Context, client *firestore.Client, campaign *models.Campaign) (map[string]staleCall, error) {
+	docs, err := client.Collection(campaignContactsCollection).
+		Where("campaign_id", "==", campaign.ID).
+		Where("status", "in", []string{contactStatusDialing, contactStatusInProgress}).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error al consultar las llamadas en curso: %v", err)
+	}
+
+	finished := make(map[string]staleCall)
+	cutoff := time.Now().Add(-campaignStaleCallTimeout)
+	for _, doc := range docs {
+		var contact models.CampaignContact
+		if err := doc.DataTo(&contact); err != nil {
+			return nil, err
+		}
+		if contact.DialedAt.After(cutoff) {
+			continue
+		}
+		callStatus, err := fetchOutboundCallStatus(contact.CallSid)
+		if err != nil {
+			log.Printf("Error al consultar en Twilio la llamada %s del contacto %s: %v", contact.CallSid, contact.ID, err)
+			continue
+		}
+		if callStatus != "" && !isFinalCallStatus(callStatus) {
+			continue
+		}
+		finished[contact.ID] = staleCall{callSid: contact.CallSid, status: callStatus}
+	}
+	return finished, nil
+}
+
+// fetchOutboundCallStatus consulta en Twilio el estado de una llamada saliente.
+// Devuelve una cadena vacía si la llamada no llegó a crearse.
+func fetchOutboundCallStatus(callSid string) (string, error) {
+	if callSid == "" {
+		return "", nil
+	}
+	call, err := getTwilioClient().Api.FetchCall(callSid, nil)
+	if err != nil {
+		return "", err
+	}
+	if call.Status == nil {
+		return "", fmt.Errorf("Twilio no informó el estado de la llamada")
+	}
+	return *call.Status, nil
+}
+
+// placeCampaignCall inicia la llamada saliente a un contacto reservado.
+// Al contestar, Twilio solicita HandleVoiceRequest con el contexto de la campaña en la URL.
+func placeCampaignCall(ctx context.Context, client *firestore.Client, campaign *models.Campaign, contact *models.CampaignContact) error {
+	params := &twilioApi.CreateCallParams{}
+	params.SetTo(contact.PhoneNumber)
+	params.SetFrom(campaign.FromNumber)
+	params.SetUrl(campaignCallbackURL("", campaign.ID, contact.ID))
+	params.SetMethod("POST")
+	params.SetStatusCallback(campaignCallbackURL(campaignCallStatusPath, campaign.ID, contact.ID))
+	params.SetStatusCallbackMethod("POST")
+	params.SetStatusCallbackEvent([]string{"answered", "completed"})
+	params.SetTimeout(campaignRingTimeout)
+	applyMachineDetection(params, campaignCallbackURL(amdStatusPath, campaign.ID, contact.ID))
+
+	ref := client.Collection(campaignContactsCollection).Doc(contact.ID)
+	call, err := getTwilioClient().Api.CreateCall(params)
+	if err != nil {
+		now := time.Now()
+		contact.LastError = fmt.Sprintf("error al crear la llamada: %v", err)
+		scheduleContactRetry(campaign, contact, now)
+		if _, setErr := ref.Set(ctx, contact); setErr != nil {
+			log.Printf("Error al reprogramar el contacto %s: %v", contact.ID, setErr)
+		}
+		return err
+	}
+
+	if call.Sid != nil {
+		if err := storeOutboundCallSid(ctx, client, ref, *call.Sid); err != nil {
+			log.Printf("Error al guardar el CallSid del contacto %s: %v", contact.ID, err)
+		}
+	}
+	return nil
+}
+
+// storeOutboundCallSid guarda el CallSid de una llamada saliente en su documento (contacto o devolución de llamada).
+// Solo se completa si el status callback no lo registró antes.
+func storeOutboundCallSid(ctx context.Context, client *firestore.Client, ref *firestore.DocumentRef, callSid string) error {
+	return client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if current, _ := doc.DataAt("call_sid"); current != nil && current != "" {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{{Path: "call_sid", Value: callSid}})
+	})
+}
+
+// recordCampaignCallStatus registra el estado de una llamada de campaña en su contacto.
+// Las llamadas no completadas, o contestadas por una máquina sin dejar mensaje, se reprograman
+// hasta agotar los intentos de la campaña.
+func recordCampaignCallStatus(ctx context.Context, campaignID, contactID, callSid, callStatus, callDuration, answeredBy string, state *models.ConversationState) error {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	campaignRef := client.Collection(campaignCollection).Doc(campaignID)
+	contactRef := client.Collection(campaignContactsCollection).Doc(contactID)
+
+	return client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		contactDoc, err := tx.Get(contactRef)
+		if status.Code(err) == codes.NotFound {
+			log.Printf("Status callback para un contacto inexistente: %s", contactID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var contact models.CampaignContact
+		if err := contactDoc.DataTo(&contact); err != nil {
+			return err
+		}
+
+		// Ignorar callbacks de intentos anteriores o repetidos
+		if contact.CallSid != "" && contact.CallSid != callSid {
+			return nil
+		}
+		if contact.Status != contactStatusDialing && contact.Status != contactStatusInProgress {
+			return nil
+		}
+
+		campaignDoc, err := tx.Get(campaignRef)
+		if err != nil {
+			return err
+		}
+		var campaign models.Campaign
+		if err := campaignDoc.DataTo(&campaign); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		contact.CallSid = callSid
+		contact.LastCallStatus = callStatus
+		contact.UpdatedAt = now
+		if answeredBy != "" {
+			contact.AnsweredBy = answeredBy
+		}
+
+		if !isFinalCallStatus(callStatus) {
+			if callStatus == "in-progress" {
+				contact.Status = contactStatusInProgress
+			}
+			return tx.Set(contactRef, &contact)
+		}
+
+		contact.Outcome = callStatus
+		contact.CallDurationSeconds = utils.Atoi(callDuration, 0)
+		if state != nil {
+			contact.Disposition = campaignDisposition(state)
+		}
+		machineRetry := state != nil && state.MachineDetectionOutcome == amdOutcomeRetry
+		if callStatus == "completed" && !machineRetry {
+			contact.Status = contactStatusCompleted
+			contact.LastError = ""
+		} else if machineRetry {
+			contact.LastError = "contestó un contestador automático"
+			scheduleContactRetry(&campaign, &contact, now)
+		} else {
+			contact.LastError = fmt.Sprintf("la llamada terminó con estado %s", callStatus)
+			scheduleContactRetry(&campaign, &contact, now)
+		}
+		return tx.Set(contactRef, &contact)
+	})
+}
+
+// completeCampaignIfDone marca la campaña como completada cuando no quedan contactos por llamar ni llamadas en curso
+func completeCampaignIfDone(ctx context.Context, client *firestore.Client, campaign *models.Campaign) error {
+	query := client.Collection(campaignContactsCollection).
+		Where("campaign_id", "==", campaign.ID).
+		Where("status", "in", []string{contactStatusPending, contactStatusDialing, contactStatusInProgress})
+	result, err := query.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return fmt.Errorf("error al contar los contactos abiertos: %v", err)
+	}
+	count, ok := result["count"].(*firestorepb.Value)
+	if !ok || count.GetIntegerValue() > 0 {
+		return nil
+	}
+
+	log.Printf("La campaña %s no tiene contactos pendientes; se marca como completada", campaign.ID)
+	_, err = client.Collection(campaignCollection).Doc(campaign.ID).Update(ctx, []firestore.Update{
+		{Path: "status", Value: campaignStatusCompleted},
+		{Path: "updated_at", Value: time.Now()},
+	})
+	return err
+}
+
+// scheduleContactRetry deja el contacto pendiente para un nuevo intento o fallido si se agotaron los intentos
+func scheduleContactRetry(campaign *models.Campaign, contact *models.CampaignContact, now time.Time) {
+	contact.UpdatedAt = now
+	if contact.Attempts >= campaign.MaxAttempts {
+		contact.Status = contactStatusFailed
+		return
+	}
+	contact.Status = contactStatusPending
+	contact.NextAttemptAt = now.Add(time.Duration(campaign.RetryDelayMinutes) * time.Minute)
+}
+
+// addCampaignContacts normaliza y guarda los contactos de una campaña.
+// El ID del contacto se deriva del número, por lo que volver a cargar un número no lo duplica.
+func addCampaignContacts(ctx context.Context, client *firestore.Client, campaign *models.Campaign, inputs []campaignContactInput) (*contactUploadResult, error) {
+	result := &contactUploadResult{}
+	if len(inputs) == 0 {
+		return result, nil
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	bulkWriter := client.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	for _, input := range inputs {
+		phoneNumber := normalizePhoneNumber(input.PhoneNumber)
+		if !e164Pattern.MatchString(phoneNumber) {
+			result.Invalid = append(result.Invalid, input.PhoneNumber)
+			continue
+		}
+
+		contactID := fmt.Sprintf("%s-%s", campaign.ID, strings.TrimPrefix(phoneNumber, "+"))
+		if seen[contactID] {
+			result.Duplicates++
+			continue
+		}
+		seen[contactID] = true
+
+		contact := &models.CampaignContact{
+			ID:            contactID,
+			CampaignID:    campaign.ID,
+			TenantID:      campaign.TenantID,
+			PhoneNumber:   phoneNumber,
+			Variables:     input.Variables,
+			Status:        contactStatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		job, err := bulkWriter.Create(client.Collection(campaignContactsCollection).Doc(contactID), contact)
+		if err != nil {
+			bulkWriter.End()
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	bulkWriter.End()
+
+	for _, job := range jobs {
+		_, err := job.Results()
+		switch {
+		case err == nil:
+			result.Added++
+		case status.Code(err) == codes.AlreadyExists:
+			result.Duplicates++
+		default:
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// parseContactsCSV lee contactos desde un CSV con cabecera. La columna phone_number es obligatoria.
+func parseContactsCSV(r io.Reader) ([]campaignContactInput, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error al leer la cabecera del CSV: %v", err)
+	}
+	phoneColumn := -1
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+		if header[i] == "phone_number" {
+			phoneColumn = i
+		}
+	}
+	if phoneColumn < 0 {
+		return nil, fmt.Errorf("el CSV no tiene la columna phone_number")
+	}
+
+	var inputs []campaignContactInput
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error al leer el CSV: %v", err)
+		}
+
+		input := campaignContactInput{PhoneNumber: record[phoneColumn], Variables: map[string]string{}}
+		for i, value := range record {
+			if i != phoneColumn && header[i] != "" {
+				input.Variables[header[i]] = value
+			}
+		}
+		inputs = append(inputs, input)
+	}
+
+	return inputs, nil
+}
+
+// normalizeCampaign valida la campaña y completa los valores por defecto
+func normalizeCampaign(campaign *models.Campaign) error {
+	if campaign.Name == "" {
+		return fmt.Errorf("la campaña requiere un nombre")
+	}
+	if campaign.TenantID == "" {
+		campaign.TenantID = "default"
+	}
+	if campaign.FromNumber == "" {
+		campaign.FromNumber = twilioPhoneNumber
+	}
+	if !e164Pattern.MatchString(campaign.FromNumber) {
+		return fmt.Errorf("número de origen inválido: %q", campaign.FromNumber)
+	}
+
+	switch campaign.Status {
+	case "":
+		campaign.Status = campaignStatusDraft
+	case campaignStatusDraft, campaignStatusActive:
+	default:
+		return fmt.Errorf("estado inicial de campaña no soportado: %s", campaign.Status)
+	}
+
+	hours := &campaign.CallingHours
+	if hours.Timezone == "" {
+		hours.Timezone = "America/Santiago"
+	}
+	if _, err := time.LoadLocation(hours.Timezone); err != nil {
+		return fmt.Errorf("zona horaria inválida: %s", hours.Timezone)
+	}
+	if hours.StartHour == 0 && hours.EndHour == 0 {
+		hours.StartHour, hours.EndHour = 9, 21
+	}
+	if hours.StartHour < 0 || hours.EndHour > 24 || hours.StartHour >= hours.EndHour {
+		return fmt.Errorf("horario de llamadas inválido: %d-%d", hours.StartHour, hours.EndHour)
+	}
+	for _, day := range hours.Weekdays {
+		if day < 0 || day > 6 {
+			return fmt.Errorf("día de la semana inválido: %d", day)
+		}
+	}
+
+	if campaign.MaxConcurrentCalls <= 0 {
+		campaign.MaxConcurrentCalls = 5
+	}
+	if campaign.MaxAttempts <= 0 {
+		campaign.MaxAttempts = 3
+	}
+	if campaign.RetryDelayMinutes <= 0 {
+		campaign.RetryDelayMinutes = 60
+	}
+	return nil
+}
+
+// withinCallingHours indica si el instante está dentro del horario de llamadas, en la zona horaria de la campaña.
+// Los días se expresan como en time.Weekday (0 es domingo); una lista vacía permite todos los días.
+func withinCallingHours(hours models.CallingHours, now time.Time) bool {
+	location, err := time.LoadLocation(hours.Timezone)
+	if err != nil {
+		log.Printf("Zona horaria inválida en el horario de llamadas: %s", hours.Timezone)
+		return false
+	}
+	local := now.In(location)
+
+	if len(hours.Weekdays) > 0 {
+		allowed := false
+		for _, day := range hours.Weekdays {
+			if time.Weekday(day) == local.Weekday() {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	return local.Hour() >= hours.StartHour && local.Hour() < hours.EndHour
+}
+
+// getCampaign obtiene una campaña. Devuelve nil si no existe.
+func getCampaign(ctx context.Context, client *firestore.Client, campaignID string) (*models.Campaign, error) {
+	doc, err := client.Collection(campaignCollection).Doc(campaignID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var campaign models.Campaign
+	if err := doc.DataTo(&campaign); err != nil {
+		return nil, fmt.Errorf("error al convertir el documento a Campaign: %v", err)
+	}
+	return &campaign, nil
+}
+
+// countCampaignContacts cuenta los contactos de una campaña con un estado dado
+func countCampaignContacts(ctx context.Context, client *firestore.Client, campaignID, contactStatus string) (int64, error) {
+	query := client.Collection(campaignContactsCollection).
+		Where("campaign_id", "==", campaignID).
+		Where("status", "==", contactStatus)
+	result, err := query.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count, ok := result["count"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("resultado de conteo inesperado para el estado %s", contactStatus)
+	}
+	return count.GetIntegerValue(), nil
+}
+
+// loadCampaignContext construye el contexto de campaña de una llamada saliente contestada
+func loadCampaignContext(ctx context.Context, campaignID, contactID string) (*models.CampaignContext, string, error) {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, "", fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	campaign, err := getCampaign(ctx, client, campaignID)
+	if err != nil {
+		return nil, "", err
+	}
+	if campaign == nil {
+		return nil, "", fmt.Errorf("la campaña %s no existe", campaignID)
+	}
+
+	doc, err := client.Collection(campaignContactsCollection).Doc(contactID).Get(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("error al obtener el contacto %s: %v", contactID, err)
+	}
+	var contact models.CampaignContact
+	if err := doc.DataTo(&contact); err != nil {
+		return nil, "", fmt.Errorf("error al convertir el documento a CampaignContact: %v", err)
+	}
+
+	voicemail := ""
+	if campaign.VoicemailTemplate != "" {
+		voicemail = renderCampaignTemplate(campaign.VoicemailTemplate, contact.Variables)
+	}
+
+	// Sin plantilla propia se usa el saludo de campañas del catálogo del tenant
+	greeting := campaign.GreetingTemplate
+	if greeting == "" {
+		greeting = tenantPrompt(campaign.TenantID, promptDefaultLocale, promptCampaignGreeting, contact.Variables)
+	}
+
+	return &models.CampaignContext{
+		CampaignID:       campaign.ID,
+		ContactID:        contact.ID,
+		Variables:        contact.Variables,
+		Greeting:         renderCampaignTemplate(greeting, contact.Variables),
+		VoicemailMessage: voicemail,
+	}, campaign.TenantID, nil
+}
+
+// renderCampaignTemplate reemplaza las variables {{nombre}} con las del contacto. Las variables sin valor se eliminan.
+func renderCampaignTemplate(template string, variables map[string]string) string {
+	return templateVariablePattern.ReplaceAllStringFunc(template, func(match string) string {
+		name := templateVariablePattern.FindStringSubmatch(match)[1]
+		return variables[name]
+	})
+}
+
+// campaignSessionParameters devuelve las variables de la campaña como parámetros de sesión de Dialogflow
+func campaignSessionParameters(state *models.ConversationState) map[string]interface{} {
+	if state.Campaign == nil {
+		return nil
+	}
+
+	params := map[string]interface{}{
+		"campaign_id": state.Campaign.CampaignID,
+		"contact_id":  state.Campaign.ContactID,
+	}
+	for name, value := range state.Campaign.Variables {
+		params[name] = value
+	}
+	return params
+}
+
+// campaignDisposition resume el resultado de la conversación de una llamada de campaña
+func campaignDisposition(state *models.ConversationState) string {
+	switch {
+	case state.MachineDetectionOutcome == amdOutcomeVoicemailLeft:
+		return "voicemail"
+	case state.MachineDetectionOutcome == amdOutcomeRetry:
+		return "machine"
+	case state.HandoffOccurred:
+		return "handoff"
+	case state.LastDialogflowResult != nil && state.LastDialogflowResult.IntentName != "":
+		return state.LastDialogflowResult.IntentName
+	case state.CurrentTurnIndex == 0:
+		return "no_interaction"
+	default:
+		return "conversation"
+	}
+}
+
+// customerNumber devuelve el número del cliente: el destino en las llamadas salientes y el origen en las entrantes
+func customerNumber(state *models.ConversationState) string {
+	if isOutboundCall(state) {
+		return state.ToNumber
+	}
+	return state.FromNumber
+}
+
+// businessNumber devuelve el número de Twilio de la llamada: el origen en las salientes y el destino en las entrantes
+func businessNumber(state *models.ConversationState) string {
+	if isOutboundCall(state) {
+		return state.FromNumber
+	}
+	return state.ToNumber
+}
+
+// isOutboundCall indica si la llamada la inició el sistema (campaña o devolución de llamada)
+func isOutboundCall(state *models.ConversationState) bool {
+	return state.Campaign != nil || state.Callback != nil
+}
+
+// normalizePhoneNumber elimina separadores y lleva el número a formato E.164
+func normalizePhoneNumber(phone string) string {
+	phone = strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '+' {
+			return r
+		}
+		return -1
+	}, phone)
+	return utils.FormatPhoneNumber(phone)
+}
+
+// campaignCallbackURL construye la URL pública de un webhook de Twilio con el contexto de la campaña
+func campaignCallbackURL(path, campaignID, contactID string) string {
+	query := url.Values{}
+	query.Set("campaign_id", campaignID)
+	query.Set("contact_id", contactID)
+	return voiceWebhookURL(path, query)
+}
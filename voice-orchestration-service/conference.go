@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+
+	"kairosia/internal/models"
+	"kairosia/internal/twiml"
+	"kairosia/internal/utils"
+)
+
+const (
+	// Modos de transferencia a un agente (HANDOFF_MODE)
+	handoffModeDial       = "dial"
+	handoffModeConference = "conference"
+
+	// conferenceEventsPath es la ruta del status callback de la conferencia
+	conferenceEventsPath = "/conference-events"
+	// conferenceAgentStatusPath es la ruta del status callback de la llamada al agente
+	conferenceAgentStatusPath = "/conference-agent-status"
+
+	// Modos de supervisión de una conferencia
+	supervisorModeListen  = "listen"
+	supervisorModeWhisper = "whisper"
+	supervisorModeBarge   = "barge"
+
+	conferenceRoleCustomer   = "customer"
+	conferenceRoleAgent      = "agent"
+	conferenceRoleSupervisor = "supervisor"
+)
+
+// superviseConferenceRequest es el cuerpo de la API de supervisión. Si se indica supervisor_call_sid,
+// se cambia el modo de un supervisor que ya está en la conferencia; si no, se llama a supervisor_number.
+type superviseConferenceRequest struct {
+	CallSid           string `json:"call_sid"`
+	Mode              string `json:"mode"`
+	SupervisorNumber  string `json:"supervisor_number,omitempty"`
+	SupervisorCallSid string `json:"supervisor_call_sid,omitempty"`
+}
+
+// conferenceName devuelve el nombre de la conferencia de la transferencia de una llamada
+func conferenceName(callSid string) string {
+	return "kairosia-" + callSid
+}
+
+// generateConferenceDial genera el <Dial><Conference> que deja al cliente esperando al agente.
+// El agente se llama cuando el cliente entra a la conferencia (ver HandleConferenceEvents).
+func generateConferenceDial(state *models.ConversationState) *models.TwiMLDial {
+	return &models.TwiMLDial{
+		Conference: &models.TwiMLConference{
+			StartConferenceOnEnter: "false",
+			EndConferenceOnExit:    "true",
+			Beep:                   "false",
+			StatusCallback:         voiceWebhookURL(conferenceEventsPath, url.Values{"call_sid": {state.CallSid}}),
+			StatusCallbackMethod:   "POST",
+			StatusCallbackEvent:    "start end join leave mute hold",
+			Name:                   state.ConferenceName,
+		},
+	}
+}
+
+// HandleConferenceEvents registra los eventos de la conferencia en la conversación y llama al agente
+// cuando el cliente entra a la conferencia
+func HandleConferenceEvents(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	callSid := r.FormValue("call_sid")
+	conferenceSid := r.FormValue("ConferenceSid")
+	participantCallSid := r.FormValue("CallSid")
+
+	ctx := r.Context()
+	state, err := getConversationState(ctx, callSid)
+	if err != nil {
+		log.Printf("Error al obtener el estado de la conversación %s: %v", callSid, err)
+		http.Error(w, "Error al obtener el estado de la conversación", http.StatusInternalServerError)
+		return
+	}
+	if state == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	timestamp, err := time.Parse(time.RFC1123Z, r.FormValue("Timestamp"))
+	if err != nil {
+		timestamp = time.Now()
+	}
+	event := models.ConferenceEvent{
+		Event:              r.FormValue("StatusCallbackEvent"),
+		ParticipantCallSid: participantCallSid,
+		Role:               conferenceParticipantRole(state, participantCallSid),
+		Timestamp:          timestamp,
+	}
+
+	updates := []firestore.Update{
+		{Path: "conference_sid", Value: conferenceSid},
+		{Path: "conference_events", Value: firestore.ArrayUnion(event)},
+		{Path: "last_update_timestamp", Value: time.Now()},
+	}
+
+	// Cuando el cliente entra a la conferencia, se llama al agente
+	if event.Event == "participant-join" && event.Role == conferenceRoleCustomer && state.AgentCallSid == "" {
+		state.ConferenceSid = conferenceSid
+		agentCallSid, err := dialConferenceAgent(state)
+		if err != nil {
+			log.Printf("Error al llamar al agente de la conferencia %s: %v", state.ConferenceName, err)
+		} else {
+			updates = append(updates, firestore.Update{Path: "agent_call_sid", Value: agentCallSid})
+		}
+	}
+
+	if err := saveConferenceUpdates(ctx, callSid, updates); err != nil {
+		log.Printf("Error al registrar el evento de la conferencia %s: %v", conferenceSid, err)
+		http.Error(w, "Error al registrar el evento de la conferencia", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// conferenceParticipantRole identifica si un participante es el cliente, el agente o un supervisor
+func conferenceParticipantRole(state *models.ConversationState, participantCallSid string) string {
+	switch participantCallSid {
+	case "":
+		return ""
+	case state.CallSid:
+		return conferenceRoleCustomer
+	case state.AgentCallSid:
+		return conferenceRoleAgent
+	}
+	return conferenceRoleSupervisor
+}
+
+// dialConferenceAgent llama al agente actual de la transferencia y lo une a la conferencia.
+// En una transferencia cálida el agente escucha el resumen antes de entrar.
+func dialConferenceAgent(state *models.ConversationState) (string, error) {
+	response := &models.TwiMLResponse{
+		Dial: &models.TwiMLDial{
+			Conference: &models.TwiMLConference{
+				StartConferenceOnEnter: "true",
+				EndConferenceOnExit:    "true",
+				Beep:                   "false",
+				Name:                   state.ConferenceName,
+			},
+		},
+	}
+	if warmTransferEnabled && state.HandoffPreserveContext && state.HandoffSummary != "" {
+		response.Say = &models.TwiMLSay{
+			Voice:    sayVoice(state),
+			Language: sayLanguage(state),
+			Value:    state.HandoffSummary,
+		}
+	}
+
+	// Si el TwiML del agente es inválido, la llamada al agente cuelga y la transferencia sigue con el siguiente destino
+	xmlString, err := renderTwiML(response, twiml.New().Hangup())
+	if err != nil {
+		return "", err
+	}
+
+	params := &twilioApi.CreateCallParams{}
+	params.SetTo(agentCallAddress(state))
+	params.SetFrom(businessNumber(state))
+	params.SetTwiml(xmlString)
+	params.SetTimeout(callbackOfferAfter)
+	params.SetStatusCallback(voiceWebhookURL(conferenceAgentStatusPath, url.Values{"call_sid": {state.CallSid}}))
+	params.SetStatusCallbackMethod("POST")
+
+	call, err := getTwilioClient().Api.CreateCall(params)
+	if err != nil {
+		return "", fmt.Errorf("error al llamar al agente %s: %v", state.CurrentTransferNumber, err)
+	}
+	if call.Sid == nil {
+		return "", fmt.Errorf("Twilio no devolvió el SID de la llamada al agente")
+	}
+	return *call.Sid, nil
+}
+
+// HandleConferenceAgentStatus recibe el resultado de la llamada al agente de la conferencia.
+// Si el agente no contestó, llama al siguiente número alternativo o redirige al cliente a HANDOFF_FALLBACK.
+func HandleConferenceAgentStatus(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	callSid := r.FormValue("call_sid")
+	agentCallStatus := r.FormValue("CallStatus")
+	if !isFinalCallStatus(agentCallStatus) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ctx := r.Context()
+	state, err := getConversationState(ctx, callSid)
+	if err != nil {
+		log.Printf("Error al obtener el estado de la conversación %s: %v", callSid, err)
+		http.Error(w, "Error al obtener el estado de la conversación", http.StatusInternalServerError)
+		return
+	}
+	if state == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	duration := utils.Atoi(r.FormValue("CallDuration"), 0)
+	attempt := models.TransferAttempt{
+		Number:          state.CurrentTransferNumber,
+		DialCallSid:     r.FormValue("CallSid"),
+		DialCallStatus:  agentCallStatus,
+		DurationSeconds: duration,
+		Timestamp:       time.Now(),
+	}
+	updates := []firestore.Update{
+		{Path: "transfer_attempts", Value: firestore.ArrayUnion(attempt)},
+		{Path: "last_update_timestamp", Value: time.Now()},
+	}
+
+	switch {
+	case agentCallStatus == "completed":
+		// El agente contestó y la conversación con el agente ya terminó
+		updates = append(updates,
+			firestore.Update{Path: "transfer_outcome", Value: transferOutcomeConnected},
+			firestore.Update{Path: "transfer_duration_seconds", Value: duration},
+		)
+	case isFinalCallStatus(state.CallStatus):
+		// El cliente colgó mientras esperaba: no hay a quién transferir
+	case len(state.PendingTransferNumbers) > 0:
+		log.Printf("El agente %s de la conferencia %s no contestó (%s), intentando otro destino", state.CurrentTransferNumber, state.ConferenceName, agentCallStatus)
+		doc := modelDocument(advanceTransfer(state))
+		agentCallSid := ""
+		if state.ConferenceName != "" {
+			// El cliente sigue en la conferencia: basta con llamar al siguiente agente
+			if agentCallSid, err = dialConferenceAgent(state); err != nil {
+				log.Printf("Error al llamar al agente de la conferencia %s: %v", state.ConferenceName, err)
+			}
+		} else if err := redirectCall(state.CallSid, doc, conversationFallback(state)); err != nil {
+			// El siguiente destino es una cola: se saca al cliente de la conferencia
+			log.Printf("Error al redirigir la llamada %s al siguiente destino: %v", state.CallSid, err)
+		}
+		updates = append(updates,
+			firestore.Update{Path: "current_transfer_number", Value: state.CurrentTransferNumber},
+			firestore.Update{Path: "pending_transfer_numbers", Value: state.PendingTransferNumbers},
+			firestore.Update{Path: "conference_name", Value: state.ConferenceName},
+			firestore.Update{Path: "queue_name", Value: state.QueueName},
+			firestore.Update{Path: "agent_call_sid", Value: agentCallSid},
+		)
+	default:
+		log.Printf("Ningún agente contestó la conferencia %s (%s)", state.ConferenceName, agentCallStatus)
+		if err := redirectCall(state.CallSid, transferFallbackDocument(ctx, state), conversationFallback(state)); err != nil {
+			log.Printf("Error al redirigir la llamada %s tras la transferencia fallida: %v", state.CallSid, err)
+		}
+		updates = append(updates,
+			firestore.Update{Path: "transfer_outcome", Value: state.TransferOutcome},
+			firestore.Update{Path: "callback_offered", Value: state.CallbackOffered},
+		)
+	}
+
+	if err := saveConferenceUpdates(ctx, callSid, updates); err != nil {
+		log.Printf("Error al registrar el resultado de la llamada al agente de %s: %v", callSid, err)
+		http.Error(w, "Error al registrar el resultado de la llamada al agente", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SuperviseConference une a un supervisor a la conferencia de una transferencia o cambia su modo:
+// listen (solo escucha), whisper (habla solo con el agente) o barge (habla con ambos)
+func SuperviseConference(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req superviseConferenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Cuerpo de la solicitud inválido", http.StatusBadRequest)
+		return
+	}
+	if req.Mode != supervisorModeListen && req.Mode != supervisorModeWhisper && req.Mode != supervisorModeBarge {
+		http.Error(w, "El modo debe ser listen, whisper o barge", http.StatusBadRequest)
+		return
+	}
+	if req.SupervisorNumber == "" && req.SupervisorCallSid == "" {
+		http.Error(w, "Falta supervisor_number o supervisor_call_sid", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	state, err := getConversationState(ctx, req.CallSid)
+	if err != nil {
+		log.Printf("Error al obtener el estado de la conversación %s: %v", req.CallSid, err)
+		http.Error(w, "Error al obtener el estado de la conversación", http.StatusInternalServerError)
+		return
+	}
+	if state == nil || state.ConferenceSid == "" {
+		http.Error(w, "La llamada no tiene una conferencia activa", http.StatusNotFound)
+		return
+	}
+	if req.Mode == supervisorModeWhisper && state.AgentCallSid == "" {
+		http.Error(w, "La conferencia no tiene un agente al que susurrar", http.StatusConflict)
+		return
+	}
+
+	supervisorCallSid, err := superviseParticipant(state, req)
+	if err != nil {
+		log.Printf("Error al supervisar la conferencia %s: %v", state.ConferenceSid, err)
+		http.Error(w, "Error al supervisar la conferencia", http.StatusBadGateway)
+		return
+	}
+
+	event := models.ConferenceEvent{
+		Event:              "supervisor-" + req.Mode,
+		ParticipantCallSid: supervisorCallSid,
+		Role:               conferenceRoleSupervisor,
+		Timestamp:          time.Now(),
+	}
+	if err := saveConferenceUpdates(ctx, state.CallSid, []firestore.Update{
+		{Path: "conference_events", Value: firestore.ArrayUnion(event)},
+		{Path: "last_update_timestamp", Value: time.Now()},
+	}); err != nil {
+		log.Printf("Error al registrar la supervisión de la conferencia %s: %v", state.ConferenceSid, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"supervisor_call_sid": supervisorCallSid,
+		"mode":                req.Mode,
+	})
+}
+
+// superviseParticipant agrega al supervisor a la conferencia o actualiza su modo, y devuelve su CallSid
+func superviseParticipant(state *models.ConversationState, req superviseConferenceRequest) (string, error) {
+	muted := req.Mode == supervisorModeListen
+	coaching := req.Mode == supervisorModeWhisper
+
+	if req.SupervisorCallSid != "" {
+		params := &twilioApi.UpdateParticipantParams{}
+		params.SetMuted(muted)
+		params.SetCoaching(coaching)
+		if coaching {
+			params.SetCallSidToCoach(state.AgentCallSid)
+		}
+		if _, err := getTwilioClient().Api.UpdateParticipant(state.ConferenceSid, req.SupervisorCallSid, params); err != nil {
+			return "", fmt.Errorf("error al actualizar al supervisor %s: %v", req.SupervisorCallSid, err)
+		}
+		return req.SupervisorCallSid, nil
+	}
+
+	params := &twilioApi.CreateParticipantParams{}
+	params.SetFrom(businessNumber(state))
+	params.SetTo(req.SupervisorNumber)
+	params.SetMuted(muted)
+	params.SetCoaching(coaching)
+	if coaching {
+		params.SetCallSidToCoach(state.AgentCallSid)
+	}
+	params.SetBeep("false")
+	params.SetStartConferenceOnEnter(false)
+	params.SetEndConferenceOnExit(false)
+
+	participant, err := getTwilioClient().Api.CreateParticipant(state.ConferenceSid, params)
+	if err != nil {
+		return "", fmt.Errorf("error al llamar al supervisor %s: %v", req.SupervisorNumber, err)
+	}
+	if participant.CallSid == nil {
+		return "", fmt.Errorf("Twilio no devolvió el SID de la llamada al supervisor")
+	}
+	return *participant.CallSid, nil
+}
+
+// redirectCall reemplaza el TwiML de una llamada en curso; fallback es la respuesta segura si el TwiML es inválido
+func redirectCall(callSid string, doc, fallback *twiml.Response) error {
+	xmlString, err := renderValidatedTwiML(doc, fallback)
+	if err != nil {
+		return err
+	}
+
+	params := &twilioApi.UpdateCallParams{}
+	params.SetTwiml(xmlString)
+	if _, err := getTwilioClient().Api.UpdateCall(callSid, params); err != nil {
+		return fmt.Errorf("error al redirigir la llamada %s: %v", callSid, err)
+	}
+	return nil
+}
+
+// saveConferenceUpdates guarda solo los campos indicados, ya que los eventos de la conferencia
+// llegan en paralelo y no deben pisarse entre sí
+func saveConferenceUpdates(ctx context.Context, callSid string, updates []firestore.Update) error {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Collection(firestoreCollection).Doc(callSid).Update(ctx, updates); err != nil {
+		return fmt.Errorf("error al actualizar la conferencia de la llamada %s: %v", callSid, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"kairosia/internal/language"
+	"kairosia/internal/models"
+)
+
+// speechConfidence lee la confianza que Twilio informa junto con SpeechResult. Algunos modelos de
+// reconocimiento no la informan; en ese caso devuelve false y la transcripción no se confirma.
+func speechConfidence(value string) (float64, bool) {
+	if value == "" {
+		return 0, false
+	}
+	confidence, err := strconv.ParseFloat(value, 64)
+	if err != nil || confidence <= 0 || confidence > 1 {
+		return 0, false
+	}
+	return confidence, true
+}
+
+// confirmSpeech decide qué hacer con una respuesta de voz antes de enviarla a Dialogflow. Si se estaba
+// confirmando una transcripción, la respuesta del cliente la acepta o la rechaza; si la nueva transcripción
+// tiene una confianza menor que SPEECH_CONFIRMATION_THRESHOLD, se pregunta al cliente si dijo eso.
+// Devuelve el texto y la confianza que siguen a Dialogflow, o el TwiML de la pregunta.
+func confirmSpeech(state *models.ConversationState, speech string, confidence float64, known bool) (string, float64, *models.TwiMLResponse) {
+	if pending := state.PendingConfirmation; pending != nil {
+		state.PendingConfirmation = nil
+		switch language.Confirmation(speech, promptLocale(state)) {
+		case language.AnswerYes:
+			state.ConfirmationAttempts = 0
+			return pending.Text, pending.Confidence, nil
+		case language.AnswerNo:
+			state.SpeechStats.ConfirmationsRejected++
+			return "", 0, generateResponseTwiML(state, prompt(state, promptConfirmRetry, nil))
+		}
+		// El cliente no respondió sí ni no: la respuesta es una transcripción nueva
+	}
+
+	if !known {
+		state.ConfirmationAttempts = 0
+		return speech, 0, nil
+	}
+	recordSpeechConfidence(state, confidence)
+
+	if confidence < speechConfirmationThreshold && state.ConfirmationAttempts < speechConfirmationMaxAttempts {
+		log.Printf("Transcripción de baja confianza en la llamada %s (%.2f), se confirma con el cliente", state.CallSid, confidence)
+		state.ConfirmationAttempts++
+		state.SpeechStats.Confirmations++
+		state.PendingConfirmation = &models.SpeechConfirmation{Text: speech, Confidence: confidence}
+		return "", 0, generateResponseTwiML(state, prompt(state, promptConfirmSpeech, map[string]string{"text": speech}))
+	}
+
+	state.ConfirmationAttempts = 0
+	return speech, confidence, nil
+}
+
+// recordSpeechConfidence acumula la confianza de una transcripción en las estadísticas de la llamada
+func recordSpeechConfidence(state *models.ConversationState, confidence float64) {
+	stats := &state.SpeechStats
+	if stats.Turns == 0 || confidence < stats.ConfidenceMin {
+		stats.ConfidenceMin = confidence
+	}
+	stats.Turns++
+	stats.ConfidenceSum += confidence
+	if confidence < speechConfirmationThreshold {
+		stats.LowConfidenceTurns++
+	}
+}
+
+// speechConfidenceAverage devuelve la confianza promedio de las transcripciones de la llamada
+func speechConfidenceAverage(stats models.SpeechStats) float64 {
+	if stats.Turns == 0 {
+		return 0
+	}
+	return stats.ConfidenceSum / float64(stats.Turns)
+}
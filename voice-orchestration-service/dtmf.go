@@ -0,0 +1,232 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"kairosia/internal/models"
+	"kairosia/internal/rut"
+)
+
+const (
+	// Acciones del payload de Dialogflow para el teclado. Un menú envía la opción de la tecla marcada en el
+	// parámetro menu_option; una captura de dígitos envía el número en el parámetro indicado y si es válido
+	// en <parámetro>_valid:
+	//   {"action": "DTMFMenu", "options": {"1": "billing", "2": "technical_support", "0": "agent"}}
+	//   {"action": "CollectDigits", "parameter": "rut", "type": "rut"}
+	//   {"action": "CollectDigits", "parameter": "account_number", "type": "digits", "numDigits": 8}
+	dtmfMenuAction      = "DTMFMenu"
+	collectDigitsAction = "CollectDigits"
+
+	// Tipos de captura de dígitos: un RUT con dígito verificador o un número de largo fijo o acotado
+	digitsTypeRUT    = "rut"
+	digitsTypeDigits = "digits"
+
+	// menuOptionParameter es el parámetro de Dialogflow con la opción elegida del menú
+	menuOptionParameter = "menu_option"
+
+	// defaultFinishOnKey es la tecla que termina la captura de dígitos si el payload no indica otra
+	defaultFinishOnKey = "#"
+)
+
+// dialogflowInput es la entrada de un turno para Dialogflow: texto, o dígitos marcados con los parámetros
+// tipados que se obtuvieron de ellos. Transcript es el texto que queda en la transcripción de la llamada,
+// con los RUT ocultos.
+type dialogflowInput struct {
+	Text       string
+	Digits     string
+	Parameters map[string]interface{}
+	Transcript string
+}
+
+// startDTMFAction registra en el estado el menú o la captura de dígitos que pide la respuesta de Dialogflow
+func startDTMFAction(state *models.ConversationState, response *models.DialogflowQueryResult) {
+	if response.CustomPayload == nil {
+		return
+	}
+	payload := response.CustomPayload
+	action, _ := payload["action"].(string)
+
+	switch action {
+	case dtmfMenuAction:
+		options := map[string]string{}
+		if raw, ok := payload["options"].(map[string]interface{}); ok {
+			for digits, option := range raw {
+				if value, ok := option.(string); ok && digits != "" && value != "" {
+					options[digits] = value
+				}
+			}
+		}
+		if len(options) == 0 {
+			log.Printf("Menú por teclado sin opciones en la llamada %s", state.CallSid)
+			return
+		}
+		state.DTMFMenu = &models.DTMFMenu{Options: options, Prompt: response.ResponseText}
+
+	case collectDigitsAction:
+		parameter, _ := payload["parameter"].(string)
+		if parameter == "" {
+			log.Printf("Captura de dígitos sin parámetro en la llamada %s", state.CallSid)
+			return
+		}
+		collection := &models.DigitCollection{
+			Parameter:   parameter,
+			Type:        digitsTypeDigits,
+			NumDigits:   payloadInt(payload, "numDigits"),
+			MinDigits:   payloadInt(payload, "minDigits"),
+			MaxDigits:   payloadInt(payload, "maxDigits"),
+			FinishOnKey: defaultFinishOnKey,
+			Prompt:      response.ResponseText,
+		}
+		if digitsType, ok := payload["type"].(string); ok && digitsType != "" {
+			collection.Type = digitsType
+		}
+		if finishOnKey, ok := payload["finishOnKey"].(string); ok {
+			collection.FinishOnKey = finishOnKey
+		}
+		state.DigitCollection = collection
+	}
+}
+
+// payloadInt lee un número del payload de Dialogflow, que llega como float64 desde el struct de protobuf
+func payloadInt(payload map[string]interface{}, name string) int {
+	switch value := payload[name].(type) {
+	case float64:
+		return int(value)
+	case string:
+		number, _ := strconv.Atoi(value)
+		return number
+	}
+	return 0
+}
+
+// applyDTMFGather ajusta el <Gather> de la respuesta al menú o a la captura de dígitos pendiente. El
+// cliente igual puede responder por voz.
+func applyDTMFGather(state *models.ConversationState, twiml *models.TwiMLResponse) {
+	if twiml == nil || twiml.Gather == nil || state.EscalationMenu {
+		return
+	}
+
+	if collection := state.DigitCollection; collection != nil {
+		twiml.Gather.Input = "dtmf speech"
+		twiml.Gather.Timeout = strconv.Itoa(dtmfTimeout)
+		twiml.Gather.FinishOnKey = collection.FinishOnKey
+		if collection.NumDigits > 0 {
+			twiml.Gather.NumDigits = strconv.Itoa(collection.NumDigits)
+		}
+	} else if menu := state.DTMFMenu; menu != nil {
+		// Si todas las opciones tienen una tecla, el <Gather> termina con la primera
+		longest := 0
+		for digits := range menu.Options {
+			if len(digits) > longest {
+				longest = len(digits)
+			}
+		}
+		twiml.Gather.Input = "dtmf speech"
+		twiml.Gather.NumDigits = strconv.Itoa(longest)
+	}
+}
+
+// handleDigits convierte los dígitos marcados en la entrada de Dialogflow. Si había un menú o una captura
+// de dígitos pendiente, valida la entrada y, si no es válida, devuelve el TwiML para volver a pedirla hasta
+// DTMF_MAX_ATTEMPTS veces; después se envía a Dialogflow como inválida.
+func handleDigits(state *models.ConversationState, digits string) (dialogflowInput, *models.TwiMLResponse) {
+	input := dialogflowInput{Digits: digits, Transcript: digits}
+
+	if state.DigitCollection != nil {
+		value, valid := collectedDigits(state.DigitCollection, digits)
+		return input, finishCollection(state, &input, value, valid)
+	}
+
+	if menu := state.DTMFMenu; menu != nil {
+		option, ok := menu.Options[digits]
+		if !ok && menu.Attempts+1 < dtmfMaxAttempts {
+			menu.Attempts++
+			return input, repromptDTMF(state, promptMenuInvalid, nil, menu.Prompt)
+		}
+		state.DTMFMenu = nil
+		if ok {
+			input.Parameters = map[string]interface{}{menuOptionParameter: option}
+		}
+	}
+	return input, nil
+}
+
+// finishCollection cierra la captura de dígitos pendiente con el valor marcado o dicho por el cliente. Si no
+// es válido, devuelve el TwiML para volver a pedirlo; después del último intento el valor se envía igual,
+// con <parámetro>_valid en false.
+func finishCollection(state *models.ConversationState, input *dialogflowInput, value string, valid bool) *models.TwiMLResponse {
+	collection := state.DigitCollection
+	if !valid && collection.Attempts+1 < dtmfMaxAttempts {
+		collection.Attempts++
+		return repromptDTMF(state, invalidDigitsPrompt(collection), dtmfKeyVars(state, collection), collection.Prompt)
+	}
+
+	state.DigitCollection = nil
+	input.Parameters = map[string]interface{}{
+		collection.Parameter:            value,
+		collection.Parameter + "_valid": valid,
+	}
+	if collection.Type == digitsTypeRUT {
+		input.Transcript = rut.Mask(value)
+	}
+	return nil
+}
+
+// repromptDTMF vuelve a pedir la entrada por teclado después de un mensaje de entrada inválida
+func repromptDTMF(state *models.ConversationState, invalidID string, vars map[string]string, text string) *models.TwiMLResponse {
+	twiml := generateResponseTwiML(state, strings.TrimSpace(prompt(state, invalidID, vars)+" "+text))
+	applyDTMFGather(state, twiml)
+	return twiml
+}
+
+// dtmfKeyVars nombra en el idioma de la llamada la tecla de la K del RUT (RUT_DTMF_K_KEY) y la que termina
+// la captura, para el mensaje de entrada inválida
+func dtmfKeyVars(state *models.ConversationState, collection *models.DigitCollection) map[string]string {
+	return map[string]string{
+		"k_key":      keyName(state, rutKKey),
+		"finish_key": keyName(state, collection.FinishOnKey),
+	}
+}
+
+// keyName devuelve el nombre de una tecla del teléfono; los dígitos se dicen tal cual
+func keyName(state *models.ConversationState, key string) string {
+	switch key {
+	case "*":
+		return prompt(state, promptKeyStar, nil)
+	case "#":
+		return prompt(state, promptKeyPound, nil)
+	default:
+		return key
+	}
+}
+
+// collectedDigits valida los dígitos de una captura y devuelve el valor para Dialogflow: el RUT en forma
+// canónica ("12345678-5") o los dígitos marcados
+func collectedDigits(collection *models.DigitCollection, digits string) (string, bool) {
+	if collection.Type == digitsTypeRUT {
+		value, err := rut.FromDTMF(digits, rutKKey)
+		if err != nil {
+			return digits, false
+		}
+		return value.String(), value.Valid()
+	}
+
+	length := len(digits)
+	if digits == "" || strings.Trim(digits, "0123456789") != "" ||
+		(collection.NumDigits > 0 && length != collection.NumDigits) ||
+		(collection.MinDigits > 0 && length < collection.MinDigits) ||
+		(collection.MaxDigits > 0 && length > collection.MaxDigits) {
+		return digits, false
+	}
+	return digits, true
+}
+
+// invalidDigitsPrompt devuelve el mensaje de entrada inválida de una captura de dígitos
+func invalidDigitsPrompt(collection *models.DigitCollection) string {
+	if collection.Type == digitsTypeRUT {
+		return promptRUTInvalid
+	}
+	return promptDigitsInvalid
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"kairosia/internal/auth"
+	"kairosia/internal/events"
+	"kairosia/internal/models"
+)
+
+const (
+	// outboxDestinationAgentDesktop indica que el evento se entrega al webhook del escritorio del agente
+	// y no al bus de eventos
+	outboxDestinationAgentDesktop = "agent_desktop"
+
+	// agentDesktopHandoffEvent es el tipo del evento con el contexto de la transferencia
+	agentDesktopHandoffEvent events.EventType = "agent_desktop.handoff_context"
+
+	// handoffsPath es la ruta de la API que consulta el contexto de una transferencia: /handoffs/{callSid}
+	handoffsPath = "/handoffs/"
+
+	// agentDesktopEventHeader identifica el evento entregado; el escritorio puede usarlo para descartar duplicados
+	agentDesktopEventHeader = "X-Kairosia-Event-Id"
+)
+
+// buildHandoffContext construye el contexto de la conversación que recibe el escritorio del agente
+func buildHandoffContext(state *models.ConversationState) *models.HandoffContext {
+	handoffContext := &models.HandoffContext{
+		CallSid:          state.CallSid,
+		TenantID:         state.TenantID,
+		Reason:           state.HandoffReason,
+		Summary:          state.HandoffSummary,
+		TransferNumber:   state.CurrentTransferNumber,
+		Transcript:       lastTurnsWithoutEmbeddings(state.RecentTurns, len(state.RecentTurns)),
+		HandoffTimestamp: state.HandoffTimestamp,
+		TransferOutcome:  state.TransferOutcome,
+		TransferAttempts: state.TransferAttempts,
+		CallStatus:       state.CallStatus,
+		CallerProfile:    buildCallerProfile(state),
+	}
+
+	if result := state.LastDialogflowResult; result != nil {
+		handoffContext.IntentName = result.IntentName
+		handoffContext.Parameters = map[string]interface{}{}
+		for name, value := range result.Parameters {
+			if !internalSessionParameters[name] {
+				handoffContext.Parameters[name] = value
+			}
+		}
+	}
+
+	return handoffContext
+}
+
+// buildCallerProfile reúne lo que se sabe del cliente: su número, la campaña o la devolución de llamada de origen
+func buildCallerProfile(state *models.ConversationState) models.CallerProfile {
+	profile := models.CallerProfile{
+		PhoneNumber: customerNumber(state),
+		Direction:   "inbound",
+		AnsweredBy:  state.AnsweredBy,
+	}
+	if isOutboundCall(state) {
+		profile.Direction = "outbound"
+	}
+	if state.Campaign != nil {
+		profile.CampaignID = state.Campaign.CampaignID
+		profile.ContactID = state.Campaign.ContactID
+		profile.Variables = state.Campaign.Variables
+	}
+	if state.Callback != nil {
+		profile.CallbackID = state.Callback.CallbackID
+		profile.OriginalCallSid = state.Callback.OriginalCallSid
+		profile.PreviousConversation = state.Callback.PreviousTurns
+	}
+	return profile
+}
+
+// appendHandoffContextEvent encola el envío del contexto al escritorio del agente si la transferencia lo preserva
+func appendHandoffContextEvent(outboxEvents []*models.OutboxEvent, state *models.ConversationState, handoffPayload *models.LiveAgentHandoffPayload) []*models.OutboxEvent {
+	if agentDesktopWebhookURL == "" || !handoffPayload.PreserveContext {
+		return outboxEvents
+	}
+
+	event, err := newOutboxEvent(fmt.Sprintf("%s-handoff-context-%d", state.CallSid, state.CurrentTurnIndex), agentDesktopHandoffEvent, state, buildHandoffContext(state))
+	if event != nil {
+		event.Destination = outboxDestinationAgentDesktop
+	}
+	return appendOutboxEvent(outboxEvents, event, err)
+}
+
+// deliverAgentDesktopWebhook envía el contexto de la transferencia al webhook del escritorio del agente.
+// Si hay un secreto configurado, la solicitud se firma con el mismo formato que la autenticación HMAC.
+func deliverAgentDesktopWebhook(ctx context.Context, outboxEvent *models.OutboxEvent) error {
+	requestCtx, cancel := context.WithTimeout(ctx, outboxRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(requestCtx, http.MethodPost, agentDesktopWebhookURL, bytes.NewReader(outboxEvent.Data))
+	if err != nil {
+		return fmt.Errorf("error al crear la solicitud al escritorio del agente: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(agentDesktopEventHeader, outboxEvent.ID)
+	if agentDesktopWebhookSecret != "" {
+		timestamp := time.Now().Unix()
+		signature := auth.Sign([]byte(agentDesktopWebhookSecret), timestamp, req.Method, req.URL.Path, outboxEvent.Data)
+		req.Header.Set(auth.SignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, signature))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error al enviar el contexto al escritorio del agente: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("el escritorio del agente respondió %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// GetHandoffContext devuelve el contexto de la transferencia de una llamada (GET /handoffs/{callSid}).
+// La interfaz del agente la consulta periódicamente; incluye el resultado de la transferencia en curso.
+// Las transferencias sin preserveContext responden 404, igual que una llamada sin transferencia.
+func GetHandoffContext(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callSid := r.URL.Query().Get("call_sid")
+	if i := strings.LastIndex(r.URL.Path, handoffsPath); i >= 0 {
+		if pathSid := strings.Trim(r.URL.Path[i+len(handoffsPath):], "/"); pathSid != "" {
+			callSid = pathSid
+		}
+	}
+	if callSid == "" {
+		http.Error(w, "Falta el CallSid de la transferencia", http.StatusBadRequest)
+		return
+	}
+
+	state, err := getConversationState(r.Context(), callSid)
+	if err != nil {
+		log.Printf("Error al obtener el estado de la conversación %s: %v", callSid, err)
+		http.Error(w, "Error al obtener el estado de la conversación", http.StatusInternalServerError)
+		return
+	}
+	// Solo las transferencias que preservan el contexto lo comparten con el agente
+	if state == nil || !state.HandoffOccurred || !state.HandoffPreserveContext {
+		http.Error(w, "Transferencia no encontrada", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildHandoffContext(state))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	dialogflow "google.golang.org/api/dialogflow/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"kairosia/internal/models"
+	"kairosia/internal/speech"
+)
+
+// speechHintsEntry son los hints cargados de una página de Dialogflow o del vocabulario de un tenant
+type speechHintsEntry struct {
+	hints   []speech.Hint
+	expires time.Time
+}
+
+// Los hints se guardan en memoria y se vuelven a cargar cada SPEECH_HINTS_REFRESH_SECONDS, para tomar
+// los cambios del agente de Dialogflow y del vocabulario de los tenants sin consultarlos en cada turno
+var (
+	speechHintsMu    sync.Mutex
+	pageHintsCache   = map[string]speechHintsEntry{}
+	tenantHintsCache = map[string]speechHintsEntry{}
+)
+
+// applySpeechHints agrega al <Gather> de voz los hints de la llamada: los de la captura pendiente, las
+// entidades que espera la página actual de Dialogflow, el vocabulario del tenant y los hints comunes de
+// SPEECH_HINTS, en ese orden de prioridad
+func applySpeechHints(ctx context.Context, state *models.ConversationState, twiml *models.TwiMLResponse) {
+	if !speechHintsEnabled || twiml == nil || twiml.Gather == nil || !strings.Contains(twiml.Gather.Input, "speech") {
+		return
+	}
+
+	var pageHints []speech.Hint
+	if state.LastDialogflowResult != nil && state.LastDialogflowResult.PageID != "" {
+		pageID, languageCode := state.LastDialogflowResult.PageID, dialogflowLanguage(state)
+		pageHints = cachedSpeechHints(pageHintsCache, pageID+"|"+languageCode, func() ([]speech.Hint, error) {
+			return loadPageHints(ctx, pageID, languageCode)
+		})
+	}
+	tenantHints := cachedSpeechHints(tenantHintsCache, state.TenantID, func() ([]speech.Hint, error) {
+		return loadTenantHints(ctx, state.TenantID)
+	})
+
+	hints := speech.Merge(slotSpeechHints(state), pageHints, tenantHints, speech.Phrases(speechHints, speechHintBoost))
+	twiml.Gather.Hints = speech.Format(hints, speechHintBoostEnabled())
+}
+
+// speechHintBoostEnabled indica si el modelo de reconocimiento (STT_MODEL) admite el refuerzo de frases
+// de Google, que son los modelos googlev2_*
+func speechHintBoostEnabled() bool {
+	return strings.HasPrefix(sttModel, "googlev2")
+}
+
+// cachedSpeechHints devuelve los hints guardados en memoria o los carga si no están o vencieron. Un error
+// de carga se registra y se guarda una lista vacía hasta el próximo refresco, para no reintentar en cada turno.
+func cachedSpeechHints(cache map[string]speechHintsEntry, key string, load func() ([]speech.Hint, error)) []speech.Hint {
+	speechHintsMu.Lock()
+	entry, ok := cache[key]
+	speechHintsMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.hints
+	}
+
+	hints, err := load()
+	if err != nil {
+		log.Printf("Error al cargar los hints de reconocimiento de voz %s: %v", key, err)
+	}
+
+	speechHintsMu.Lock()
+	cache[key] = speechHintsEntry{hints: hints, expires: time.Now().Add(speechHintsRefresh)}
+	speechHintsMu.Unlock()
+	return hints
+}
+
+// loadPageHints lee del agente de Dialogflow CX los parámetros del formulario de una página y devuelve los
+// valores y sinónimos de sus tipos de entidad. Las entidades de sistema se reemplazan por los tokens de clase
+// de Google Speech-to-Text equivalentes.
+func loadPageHints(ctx context.Context, pageID, languageCode string) ([]speech.Hint, error) {
+	// Inicializar el cliente REST de Dialogflow CX en el endpoint regional del agente
+	service, err := dialogflow.NewService(ctx, option.WithEndpoint(fmt.Sprintf("https://%s-dialogflow.googleapis.com/", dialogflowLocation)))
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente de Dialogflow CX: %v", err)
+	}
+	agents := service.Projects.Locations.Agents
+
+	page, err := agents.Flows.Pages.Get(pageID).LanguageCode(languageCode).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener la página %s: %v", pageID, err)
+	}
+	if page.Form == nil {
+		return nil, nil
+	}
+
+	var hints []speech.Hint
+	for _, parameter := range page.Form.Parameters {
+		if speech.IsSystemEntity(parameter.EntityType) {
+			hints = append(hints, speech.SystemEntityHints(parameter.EntityType)...)
+			continue
+		}
+
+		entityType, err := agents.EntityTypes.Get(parameter.EntityType).LanguageCode(languageCode).Context(ctx).Do()
+		if err != nil {
+			// Se usan los hints de los demás parámetros
+			log.Printf("Error al obtener el tipo de entidad %s: %v", parameter.EntityType, err)
+			continue
+		}
+		for _, entity := range entityType.Entities {
+			hints = append(hints, speech.Hint{Phrase: entity.Value, Boost: speechHintBoost})
+			hints = append(hints, speech.Phrases(entity.Synonyms, speechHintBoost)...)
+		}
+	}
+	return hints, nil
+}
+
+// loadTenantHints lee el vocabulario propio de un tenant. Un tenant sin vocabulario no tiene hints propios.
+func loadTenantHints(ctx context.Context, tenantID string) ([]speech.Hint, error) {
+	if tenantID == "" {
+		return nil, nil
+	}
+
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	doc, err := client.Collection(speechVocabularyCollection).Doc(tenantID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener el vocabulario del tenant: %v", err)
+	}
+
+	var vocabulary models.SpeechVocabulary
+	if err := doc.DataTo(&vocabulary); err != nil {
+		return nil, fmt.Errorf("error al parsear el vocabulario del tenant: %v", err)
+	}
+	boost := vocabulary.Boost
+	if boost == 0 {
+		boost = speechHintBoost
+	}
+	return speech.Phrases(vocabulary.Phrases, boost), nil
+}
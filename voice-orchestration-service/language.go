@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+
+	"kairosia/internal/language"
+	"kairosia/internal/models"
+	"kairosia/internal/utils"
+)
+
+const (
+	// languageSwitchAction es la acción del payload de Dialogflow que cambia el idioma de la llamada, por ejemplo
+	// cuando el cliente pide que lo atiendan en inglés: {"action": "SwitchLanguage", "languageCode": "en-US"}
+	languageSwitchAction = "SwitchLanguage"
+
+	// defaultSayVoice es la voz de Twilio de los idiomas sin voz en LANGUAGE_VOICES
+	defaultSayVoice = "Polly.Lupe"
+)
+
+// detectCallLanguage clasifica el idioma de la primera respuesta del cliente y, si es otro idioma soportado,
+// cambia a ese idioma la llamada: Dialogflow, el <Gather> y la voz. El idioma detectado queda en el estado
+// aunque no se cambie, para analítica.
+func detectCallLanguage(state *models.ConversationState, speech string) {
+	if !languageDetectionEnabled || speech == "" || state.CurrentTurnIndex > 0 {
+		return
+	}
+
+	detection := language.Detect(speech, supportedLanguages)
+	if detection.Locale == "" || detection.Score < languageDetectionMinScore {
+		return
+	}
+	state.DetectedLanguage = detection.Locale
+	if detection.Locale != dialogflowLanguage(state) {
+		switchCallLanguage(state, detection.Locale)
+	}
+}
+
+// switchCallLanguage cambia el idioma de la llamada si es un idioma soportado
+func switchCallLanguage(state *models.ConversationState, locale string) bool {
+	for _, supported := range supportedLanguages {
+		if supported == locale {
+			log.Printf("Cambiando el idioma de la llamada %s a %s", state.CallSid, locale)
+			state.LanguageCode = locale
+			return true
+		}
+	}
+	log.Printf("Idioma no soportado, se mantiene el de la llamada %s: %s", state.CallSid, locale)
+	return false
+}
+
+// isLanguageSwitchAction indica si la respuesta de Dialogflow pide cambiar el idioma y a cuál
+func isLanguageSwitchAction(response *models.DialogflowQueryResult) (string, bool) {
+	if response.CustomPayload == nil {
+		return "", false
+	}
+	if action, _ := response.CustomPayload["action"].(string); action != languageSwitchAction {
+		return "", false
+	}
+	locale, ok := response.CustomPayload["languageCode"].(string)
+	return locale, ok && locale != ""
+}
+
+// dialogflowLanguage devuelve el código de idioma de las consultas a Dialogflow de la llamada
+func dialogflowLanguage(state *models.ConversationState) string {
+	if state != nil && state.LanguageCode != "" {
+		return state.LanguageCode
+	}
+	return dialogflowDefaultLanguage
+}
+
+// sttLanguage devuelve el idioma del reconocimiento de voz del <Gather> de la llamada
+func sttLanguage(state *models.ConversationState) string {
+	if state != nil && state.LanguageCode != "" {
+		return state.LanguageCode
+	}
+	return sttLanguageCode
+}
+
+// sayLanguage devuelve el idioma de los <Say> de la llamada
+func sayLanguage(state *models.ConversationState) string {
+	if state != nil && state.LanguageCode != "" {
+		return state.LanguageCode
+	}
+	return ttsLanguageCode
+}
+
+// parseLanguageVoices convierte "idioma=voz,idioma=voz" en el mapa de voces por idioma
+func parseLanguageVoices(s string) map[string]string {
+	voices, invalid := utils.ParseMap(s)
+	for _, item := range invalid {
+		log.Printf("Voz de idioma inválida, se ignora: %s", item)
+	}
+	return voices
+}
+
+// sayVoice devuelve la voz de los <Say> de la llamada según LANGUAGE_VOICES
+func sayVoice(state *models.ConversationState) string {
+	if voice, ok := languageVoices[sayLanguage(state)]; ok {
+		return voice
+	}
+	return defaultSayVoice
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"kairosia/internal/events"
+	"kairosia/internal/models"
+	"kairosia/internal/utils"
+)
+
+// buildTranscriptPayload construye la transcripción acumulada de la conversación
+func buildTranscriptPayload(state *models.ConversationState) *models.FullTranscriptPayload {
+	payload := &models.FullTranscriptPayload{
+		CallSid:                     state.CallSid,
+		TenantID:                    state.TenantID,
+		FromNumber:                  state.FromNumber,
+		ToNumber:                    state.ToNumber,
+		StartTimestamp:              state.StartTimestamp,
+		TranscriptEntries:           state.RecentTurns,
+		DialogflowMetadata:          state.LastDialogflowResult,
+		HandoffOccurred:             state.HandoffOccurred,
+		HandoffReason:               state.HandoffReason,
+		HandoffTimestamp:            state.HandoffTimestamp,
+		AnsweredBy:                  state.AnsweredBy,
+		MachineDetectionOutcome:     state.MachineDetectionOutcome,
+		TransferOutcome:             state.TransferOutcome,
+		TransferDurationSeconds:     state.TransferDurationSeconds,
+		LanguageCode:                dialogflowLanguage(state),
+		DetectedLanguage:            state.DetectedLanguage,
+		NoInputCount:                state.NoInputTotal,
+		NoMatchCount:                state.NoMatchTotal,
+		SpeechConfidenceAvg:         speechConfidenceAverage(state.SpeechStats),
+		SpeechConfidenceMin:         state.SpeechStats.ConfidenceMin,
+		LowConfidenceTurns:          state.SpeechStats.LowConfidenceTurns,
+		SpeechConfirmations:         state.SpeechStats.Confirmations,
+		SpeechConfirmationsRejected: state.SpeechStats.ConfirmationsRejected,
+		CreatedAt:                   time.Now(),
+	}
+
+	// Si la llamada ha terminado, calcular la duración. Una transferencia también cierra la parte atendida por la IA.
+	endTime := state.EndTimestamp
+	if endTime == nil && state.HandoffOccurred {
+		endTime = state.HandoffTimestamp
+	}
+	if endTime != nil {
+		end := *endTime
+		payload.EndTimestamp = &end
+		payload.DurationSeconds = int(end.Sub(state.StartTimestamp).Seconds())
+	}
+
+	return payload
+}
+
+// newCallStartedEvent crea el evento de inicio de llamada
+func newCallStartedEvent(state *models.ConversationState) (*models.OutboxEvent, error) {
+	return newOutboxEvent(fmt.Sprintf("%s-started", state.CallSid), events.CallStarted, state, &events.CallStartedData{
+		FromNumber:     state.FromNumber,
+		ToNumber:       state.ToNumber,
+		StartTimestamp: state.StartTimestamp,
+	})
+}
+
+// newTurnCompletedEvent crea el evento de turno completado con la transcripción acumulada
+func newTurnCompletedEvent(state *models.ConversationState, userEntry, aiEntry models.TranscriptEntry) (*models.OutboxEvent, error) {
+	return newOutboxEvent(fmt.Sprintf("%s-turn-%d", state.CallSid, state.CurrentTurnIndex), events.TurnCompleted, state, &events.TurnCompletedData{
+		TurnIndex:  state.CurrentTurnIndex,
+		UserEntry:  userEntry,
+		AIEntry:    aiEntry,
+		Transcript: buildTranscriptPayload(state),
+	})
+}
+
+// newHandoffRequestedEvent crea el evento de solicitud de transferencia a un agente humano
+func newHandoffRequestedEvent(state *models.ConversationState, handoff *models.LiveAgentHandoffPayload) (*models.OutboxEvent, error) {
+	timestamp := time.Now()
+	if state.HandoffTimestamp != nil {
+		timestamp = *state.HandoffTimestamp
+	}
+	return newOutboxEvent(fmt.Sprintf("%s-handoff-%d", state.CallSid, state.CurrentTurnIndex), events.HandoffRequested, state, &events.HandoffRequestedData{
+		Handoff:   handoff,
+		Timestamp: timestamp,
+	})
+}
+
+// newCallEndedEvent crea el evento de fin de llamada con la transcripción final
+func newCallEndedEvent(state *models.ConversationState, durationSeconds int) (*models.OutboxEvent, error) {
+	return newOutboxEvent(fmt.Sprintf("%s-ended", state.CallSid), events.CallEnded, state, &events.CallEndedData{
+		CallStatus:      state.CallStatus,
+		DurationSeconds: durationSeconds,
+		Transcript:      buildTranscriptPayload(state),
+	})
+}
+
+// isFinalCallStatus indica si el estado de Twilio corresponde a una llamada terminada
+func isFinalCallStatus(status string) bool {
+	switch status {
+	case "completed", "busy", "failed", "no-answer", "canceled":
+		return true
+	}
+	return false
+}
+
+// HandleCallStatus recibe el status callback de Twilio y emite CallEnded cuando la llamada termina
+func HandleCallStatus(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	callSid := r.FormValue("CallSid")
+	callStatus := r.FormValue("CallStatus")
+	if callSid == "" || !isFinalCallStatus(callStatus) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if _, err := recordCallEnded(r.Context(), callSid, callStatus, r.FormValue("CallDuration")); err != nil {
+		log.Printf("Error al guardar el fin de la llamada %s: %v", callSid, err)
+		http.Error(w, "Error al guardar el fin de la llamada", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recordCallEnded marca la conversación como terminada y encola el evento CallEnded.
+// Devuelve nil si la llamada terminó antes de crear el estado (por ejemplo, una llamada saliente sin respuesta).
+// El estado se lee dentro de la transacción y solo se actualizan los campos del fin de la llamada, para no
+// perder una escritura concurrente de un turno o de /dial-result.
+func recordCallEnded(ctx context.Context, callSid, callStatus, callDuration string) (*models.ConversationState, error) {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	stateRef := client.Collection(firestoreCollection).Doc(callSid)
+
+	var ended *models.ConversationState
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		ended = nil
+		doc, err := tx.Get(stateRef)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var state models.ConversationState
+		if err := doc.DataTo(&state); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		state.CallStatus = callStatus
+		state.EndTimestamp = &now
+		state.LastUpdateTimestamp = now
+
+		durationSeconds := utils.Atoi(callDuration, int(now.Sub(state.StartTimestamp).Seconds()))
+		endedEvent, err := newCallEndedEvent(&state, durationSeconds)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Update(stateRef, []firestore.Update{
+			{Path: "call_status", Value: callStatus},
+			{Path: "end_timestamp", Value: now},
+			{Path: "last_update_timestamp", Value: now},
+		}); err != nil {
+			return err
+		}
+		if err := tx.Set(client.Collection(outboxCollection).Doc(endedEvent.ID), endedEvent); err != nil {
+			return err
+		}
+		ended = &state
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al guardar el fin de la llamada y el evento de salida: %v", err)
+	}
+
+	return ended, nil
+}
+
+// preserveCallEnd copia en state el fin de la llamada ya guardado en Firestore.
+// Los manejadores leen el estado fuera de la transacción y lo sobrescriben completo; sin esto, un turno o un
+// callback de Twilio que llegue junto al status callback borraría call_status y end_timestamp.
+func preserveCallEnd(tx *firestore.Transaction, stateRef *firestore.DocumentRef, state *models.ConversationState) error {
+	doc, err := tx.Get(stateRef)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored models.ConversationState
+	if err := doc.DataTo(&stored); err != nil {
+		return err
+	}
+	if stored.EndTimestamp != nil {
+		state.CallStatus = stored.CallStatus
+		state.EndTimestamp = stored.EndTimestamp
+	}
+	return nil
+}
+
+// appendOutboxEvent agrega un evento a la lista si se pudo crear, registrando el error en caso contrario
+func appendOutboxEvent(outboxEvents []*models.OutboxEvent, event *models.OutboxEvent, err error) []*models.OutboxEvent {
+	if err != nil {
+		log.Printf("Error al crear el evento de salida: %v", err)
+		return outboxEvents
+	}
+	return append(outboxEvents, event)
+}
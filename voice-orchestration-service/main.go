@@ -3,28 +3,28 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"encoding/xml"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	speech "cloud.google.com/go/speech/apiv1"
-	texttospeech "cloud.google.com/go/texttospeech/apiv1"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
-	"github.com/twilio/twilio-go"
 	dialogflow "google.golang.org/api/dialogflow/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
-	speechpb "google.golang.org/genproto/googleapis/cloud/speech/v1"
-	texttospeechpb "google.golang.org/genproto/googleapis/cloud/texttospeech/v1"
-	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"kairosia/internal/auth"
+	"kairosia/internal/businesshours"
 	"kairosia/internal/models"
+	"kairosia/internal/prompts"
+	"kairosia/internal/twiml"
 	"kairosia/internal/utils"
 )
 
@@ -46,6 +46,75 @@ var (
 	vertexAIVectorSearchNeighbors int
 	conversationHistoryServiceURL string
 	transferPhoneNumber        string
+	outboxCollection           string
+	outboxBatchSize            int
+	outboxMaxAttempts          int
+	outboxDispatchInterval     time.Duration
+	outboxRequestTimeout       time.Duration
+	outboxRetryBaseDelay       time.Duration
+	outboxRetryMaxDelay        time.Duration
+	serviceAuthConfig          auth.Config
+	eventBus                   string
+	pubsubTopic                string
+	voiceServiceURL            string
+	twilioPhoneNumber          string
+	campaignCollection         string
+	campaignContactsCollection string
+	campaignDispatchInterval   time.Duration
+	campaignRingTimeout        int
+	campaignStaleCallTimeout   time.Duration
+	amdEnabled                 bool
+	amdAsync                   bool
+	amdTimeout                 int
+	callbackCollection         string
+	callbackOfferAfter         int
+	callbackTimezone           string
+	callbackMaxAttempts        int
+	callbackRetryDelay         time.Duration
+	callbackRingTimeout        int
+	callbackBatchSize          int
+	callbackStaleCallTimeout   time.Duration
+	callbackDispatchInterval   time.Duration
+	transferAlternateNumbers   []string
+	handoffFallback            string
+	voicemailMaxLength         int
+	warmTransferEnabled        bool
+	agentDesktopWebhookURL     string
+	agentDesktopWebhookSecret  string
+	handoffMode                string
+	queueRoutes                map[string]string
+	queueDefault               string
+	queueHoldMusicURL          string
+	queueMaxWait               int
+	routingCollection          string
+	transferSipUsername        string
+	transferSipPassword        string
+	businessHoursCollection    string
+	businessHoursWindows       []models.BusinessWindow
+	businessHoursTimezone      string
+	businessHoursHolidays      string
+	afterHoursGreeting         string
+	afterHoursHandoff          string
+	twimlValidationMode        string
+	ssmlEnabled                bool
+	promptDefaultLocale        string
+	languageDetectionEnabled   bool
+	languageDetectionMinScore  float64
+	supportedLanguages         []string
+	languageVoices             map[string]string
+	noInputEscalation          []string
+	noMatchEscalation          []string
+	speechConfirmationThreshold float64
+	speechConfirmationMaxAttempts int
+	speechHintsEnabled         bool
+	speechHints                []string
+	speechHintBoost            float64
+	speechHintsRefresh         time.Duration
+	speechVocabularyCollection string
+	dtmfTimeout                int
+	dtmfMaxAttempts            int
+	rutKKey                    string
+	apiAuthConfig              auth.Config
 )
 
 func init() {
@@ -67,9 +136,132 @@ func init() {
 	vertexAIVectorSearchNeighbors = utils.Atoi(utils.GetEnv("VERTEX_AI_VECTOR_SEARCH_NEIGHBORS", "5"), 5)
 	conversationHistoryServiceURL = utils.GetEnv("CONVERSATION_HISTORY_SERVICE_URL", "")
 	transferPhoneNumber = utils.GetEnv("TRANSFER_PHONE_NUMBER", "+56912345678")
+	outboxCollection = utils.GetEnv("OUTBOX_COLLECTION", "transcript_outbox")
+	outboxBatchSize = utils.Atoi(utils.GetEnv("OUTBOX_BATCH_SIZE", "20"), 20)
+	outboxMaxAttempts = utils.Atoi(utils.GetEnv("OUTBOX_MAX_ATTEMPTS", "10"), 10)
+	outboxDispatchInterval = time.Duration(utils.Atoi(utils.GetEnv("OUTBOX_DISPATCH_INTERVAL_MS", "2000"), 2000)) * time.Millisecond
+	outboxRequestTimeout = time.Duration(utils.Atoi(utils.GetEnv("OUTBOX_REQUEST_TIMEOUT_MS", "10000"), 10000)) * time.Millisecond
+	outboxRetryBaseDelay = time.Duration(utils.Atoi(utils.GetEnv("OUTBOX_RETRY_BASE_DELAY_MS", "1000"), 1000)) * time.Millisecond
+	outboxRetryMaxDelay = time.Duration(utils.Atoi(utils.GetEnv("OUTBOX_RETRY_MAX_DELAY_MS", "300000"), 300000)) * time.Millisecond
+	serviceAuthConfig = auth.Config{
+		Mode:         utils.GetEnv("SERVICE_AUTH_MODE", auth.ModeIDToken),
+		Audience:     utils.GetEnv("SERVICE_AUTH_AUDIENCE", conversationHistoryServiceURL),
+		HMACSecret:   utils.GetEnv("SERVICE_AUTH_HMAC_SECRET", ""),
+		LocalKeyFile: utils.GetEnv("SERVICE_AUTH_LOCAL_KEY_FILE", "/tmp/kairosia-local-issuer.pem"),
+		LocalSubject: utils.GetEnv("SERVICE_AUTH_LOCAL_SUBJECT", "voice-orchestration@kairosia.local"),
+	}
+	eventBus = utils.GetEnv("EVENT_BUS", "pubsub")
+	pubsubTopic = utils.GetEnv("PUBSUB_TOPIC", "conversation-events")
+	voiceServiceURL = utils.GetEnv("VOICE_ORCHESTRATION_SERVICE_URL", "")
+	twilioPhoneNumber = utils.GetEnv("TWILIO_PHONE_NUMBER", "")
+	campaignCollection = utils.GetEnv("CAMPAIGN_COLLECTION", "campaigns")
+	campaignContactsCollection = utils.GetEnv("CAMPAIGN_CONTACTS_COLLECTION", "campaign_contacts")
+	campaignDispatchInterval = time.Duration(utils.Atoi(utils.GetEnv("CAMPAIGN_DISPATCH_INTERVAL_MS", "15000"), 15000)) * time.Millisecond
+	campaignRingTimeout = utils.Atoi(utils.GetEnv("CAMPAIGN_RING_TIMEOUT_SECONDS", "30"), 30)
+	campaignStaleCallTimeout = time.Duration(utils.Atoi(utils.GetEnv("CAMPAIGN_STALE_CALL_MINUTES", "60"), 60)) * time.Minute
+	amdEnabled = utils.GetEnv("AMD_ENABLED", "true") == "true"
+	amdAsync = utils.GetEnv("AMD_ASYNC", "false") == "true"
+	amdTimeout = utils.Atoi(utils.GetEnv("AMD_TIMEOUT_SECONDS", "30"), 30)
+	callbackCollection = utils.GetEnv("CALLBACK_COLLECTION", "callbacks")
+	callbackOfferAfter = utils.Atoi(utils.GetEnv("CALLBACK_OFFER_AFTER_SECONDS", "30"), 30)
+	callbackTimezone = utils.GetEnv("CALLBACK_TIMEZONE", "America/Santiago")
+	callbackMaxAttempts = utils.Atoi(utils.GetEnv("CALLBACK_MAX_ATTEMPTS", "3"), 3)
+	callbackRetryDelay = time.Duration(utils.Atoi(utils.GetEnv("CALLBACK_RETRY_DELAY_MINUTES", "15"), 15)) * time.Minute
+	callbackRingTimeout = utils.Atoi(utils.GetEnv("CALLBACK_RING_TIMEOUT_SECONDS", "30"), 30)
+	callbackBatchSize = utils.Atoi(utils.GetEnv("CALLBACK_BATCH_SIZE", "10"), 10)
+	callbackStaleCallTimeout = time.Duration(utils.Atoi(utils.GetEnv("CALLBACK_STALE_CALL_MINUTES", "60"), 60)) * time.Minute
+	callbackDispatchInterval = time.Duration(utils.Atoi(utils.GetEnv("CALLBACK_DISPATCH_INTERVAL_MS", "15000"), 15000)) * time.Millisecond
+	transferAlternateNumbers = utils.ParseList(utils.GetEnv("TRANSFER_ALTERNATE_NUMBERS", ""))
+	handoffFallback = utils.GetEnv("HANDOFF_FALLBACK", handoffFallbackCallback)
+	voicemailMaxLength = utils.Atoi(utils.GetEnv("VOICEMAIL_MAX_LENGTH_SECONDS", "120"), 120)
+	warmTransferEnabled = utils.GetEnv("WARM_TRANSFER_ENABLED", "true") == "true"
+	agentDesktopWebhookURL = utils.GetEnv("AGENT_DESKTOP_WEBHOOK_URL", "")
+	agentDesktopWebhookSecret = utils.GetEnv("AGENT_DESKTOP_WEBHOOK_SECRET", "")
+	handoffMode = utils.GetEnv("HANDOFF_MODE", handoffModeDial)
+	queueRoutes = parseQueueRoutes(utils.GetEnv("QUEUE_ROUTES", "billing_inquiry=billing,technical_support=support"))
+	queueDefault = utils.GetEnv("QUEUE_DEFAULT", "general")
+	queueHoldMusicURL = utils.GetEnv("QUEUE_HOLD_MUSIC_URL", "http://com.twilio.music.classical.s3.amazonaws.com/BusyStrings.mp3")
+	queueMaxWait = utils.Atoi(utils.GetEnv("QUEUE_MAX_WAIT_SECONDS", "600"), 600)
+	routingCollection = utils.GetEnv("ROUTING_COLLECTION", "routing_rules")
+	transferSipUsername = utils.GetEnv("TRANSFER_SIP_USERNAME", "")
+	transferSipPassword = utils.GetEnv("TRANSFER_SIP_PASSWORD", "")
+	businessHoursCollection = utils.GetEnv("BUSINESS_HOURS_COLLECTION", "business_hours")
+	businessHoursTimezone = utils.GetEnv("BUSINESS_HOURS_TIMEZONE", businesshours.DefaultTimezone)
+	businessHoursHolidays = utils.GetEnv("BUSINESS_HOURS_HOLIDAYS", businesshours.CalendarChile)
+	afterHoursGreeting = utils.GetEnv("AFTER_HOURS_GREETING", "")
+	afterHoursHandoff = utils.GetEnv("AFTER_HOURS_HANDOFF", handoffFallbackCallback)
+	twimlValidationMode = utils.GetEnv("TWIML_VALIDATION_MODE", twimlValidationEnforce)
+	ssmlEnabled = utils.GetEnv("SSML_ENABLED", "true") == "true"
+	promptDefaultLocale = utils.GetEnv("PROMPT_DEFAULT_LOCALE", "es-CL")
+	languageDetectionEnabled = utils.GetEnv("LANGUAGE_DETECTION_ENABLED", "true") == "true"
+	languageDetectionMinScore = utils.ParseFloat(utils.GetEnv("LANGUAGE_DETECTION_MIN_SCORE", "0.7"), 0.7)
+	supportedLanguages = utils.ParseList(utils.GetEnv("SUPPORTED_LANGUAGES", "es-CL,en-US,pt-BR"))
+	languageVoices = parseLanguageVoices(utils.GetEnv("LANGUAGE_VOICES", "es-CL=Polly.Lupe,en-US=Polly.Joanna,pt-BR=Polly.Camila"))
+	speechConfirmationThreshold = utils.ParseFloat(utils.GetEnv("SPEECH_CONFIRMATION_THRESHOLD", "0.5"), 0.5)
+	speechConfirmationMaxAttempts = utils.Atoi(utils.GetEnv("SPEECH_CONFIRMATION_MAX_ATTEMPTS", "2"), 2)
+	speechHintsEnabled = utils.GetEnv("SPEECH_HINTS_ENABLED", "true") == "true"
+	speechHints = utils.ParseList(utils.GetEnv("SPEECH_HINTS", ""))
+	speechHintBoost = utils.ParseFloat(utils.GetEnv("SPEECH_HINT_BOOST", "10"), 10)
+	speechHintsRefresh = time.Duration(utils.Atoi(utils.GetEnv("SPEECH_HINTS_REFRESH_SECONDS", "600"), 600)) * time.Second
+	speechVocabularyCollection = utils.GetEnv("SPEECH_VOCABULARY_COLLECTION", "speech_vocabulary")
+	dtmfTimeout = utils.Atoi(utils.GetEnv("DTMF_TIMEOUT", "10"), 10)
+	dtmfMaxAttempts = utils.Atoi(utils.GetEnv("DTMF_MAX_ATTEMPTS", "3"), 3)
+	rutKKey = utils.GetEnv("RUT_DTMF_K_KEY", "*")
+	promptStore = prompts.NewStore(utils.GetEnv("PROMPTS_DIR", ""), utils.ParseList(utils.GetEnv("PROMPT_FALLBACK_LOCALES", promptDefaultLocale)))
+	apiAuthConfig = auth.Config{
+		Mode:                   utils.GetEnv("SERVICE_AUTH_MODE", auth.ModeIDToken),
+		Audience:               utils.GetEnv("API_AUTH_AUDIENCE", voiceServiceURL),
+		AllowedServiceAccounts: utils.ParseList(utils.GetEnv("API_AUTH_ALLOWED_SERVICE_ACCOUNTS", "")),
+		HMACSecret:             utils.GetEnv("SERVICE_AUTH_HMAC_SECRET", ""),
+		HMACTolerance:          time.Duration(utils.Atoi(utils.GetEnv("SERVICE_AUTH_HMAC_TOLERANCE_SECONDS", "300"), 300)) * time.Second,
+		LocalKeyFile:           utils.GetEnv("SERVICE_AUTH_LOCAL_KEY_FILE", "/tmp/kairosia-local-issuer.pem"),
+	}
+
+	// Horario de atención predeterminado de los tenants sin horario propio
+	windows, err := businesshours.ParseWindows(utils.GetEnv("BUSINESS_HOURS", ""))
+	if err != nil {
+		log.Fatalf("Error al configurar el horario de atención: %v", err)
+	}
+	businessHoursWindows = windows
 
-	// Registrar la función HTTP
+	// Política de reintentos cuando el cliente no responde o Dialogflow no reconoce la respuesta
+	if noInputEscalation, err = parseEscalation(utils.GetEnv("NO_INPUT_ESCALATION", "reprompt,rephrase,dtmf,hangup")); err != nil {
+		log.Fatalf("Error al configurar NO_INPUT_ESCALATION: %v", err)
+	}
+	if noMatchEscalation, err = parseEscalation(utils.GetEnv("NO_MATCH_ESCALATION", "reprompt,rephrase,dtmf,transfer")); err != nil {
+		log.Fatalf("Error al configurar NO_MATCH_ESCALATION: %v", err)
+	}
+
+	// Crear el verificador de la API de administración (campañas)
+	apiVerifier, err := auth.NewVerifier(apiAuthConfig)
+	if err != nil {
+		log.Fatalf("Error al configurar la autenticación de la API: %v", err)
+	}
+
+	// Registrar las funciones HTTP. Los webhooks de Twilio son públicos; la API de administración requiere autenticación.
 	functions.HTTP("HandleVoiceRequest", HandleVoiceRequest)
+	functions.HTTP("DispatchOutbox", auth.Middleware(apiVerifier, DispatchOutbox))
+	functions.HTTP("OutboxMetrics", auth.Middleware(apiVerifier, OutboxMetrics))
+	functions.HTTP("HandleCallStatus", HandleCallStatus)
+	functions.HTTP("HandleCampaignCallStatus", HandleCampaignCallStatus)
+	functions.HTTP("HandleAMDStatus", HandleAMDStatus)
+	functions.HTTP("HandleDialResult", HandleDialResult)
+	functions.HTTP("HandleVoicemailRecording", HandleVoicemailRecording)
+	functions.HTTP("HandleAgentWhisper", HandleAgentWhisper)
+	functions.HTTP("HandleConferenceEvents", HandleConferenceEvents)
+	functions.HTTP("HandleConferenceAgentStatus", HandleConferenceAgentStatus)
+	functions.HTTP("HandleQueueWait", HandleQueueWait)
+	functions.HTTP("HandleQueueResult", HandleQueueResult)
+	functions.HTTP("HandleCallbackCallStatus", HandleCallbackCallStatus)
+	functions.HTTP("CreateCampaign", auth.Middleware(apiVerifier, CreateCampaign))
+	functions.HTTP("UploadCampaignContacts", auth.Middleware(apiVerifier, UploadCampaignContacts))
+	functions.HTTP("SetCampaignStatus", auth.Middleware(apiVerifier, SetCampaignStatus))
+	functions.HTTP("GetCampaign", auth.Middleware(apiVerifier, GetCampaign))
+	functions.HTTP("GetHandoffContext", auth.Middleware(apiVerifier, GetHandoffContext))
+	functions.HTTP("SuperviseConference", auth.Middleware(apiVerifier, SuperviseConference))
+	functions.HTTP("DequeueCall", auth.Middleware(apiVerifier, DequeueCall))
+	functions.HTTP("DispatchCampaigns", auth.Middleware(apiVerifier, DispatchCampaigns))
+	functions.HTTP("DispatchCallbacks", auth.Middleware(apiVerifier, DispatchCallbacks))
 }
 
 // HandleVoiceRequest maneja las solicitudes de voz de Twilio
@@ -91,18 +283,24 @@ func HandleVoiceRequest(w http.ResponseWriter, r *http.Request) {
 		CallStatus: r.FormValue("CallStatus"),
 		ApiVersion: r.FormValue("ApiVersion"),
 		Digits:     r.FormValue("Digits"),
+		AnsweredBy: r.FormValue("AnsweredBy"),
+		// Las llamadas salientes de campaña incluyen su contexto en la URL del webhook
+		CampaignID:        r.FormValue("campaign_id"),
+		CampaignContactID: r.FormValue("contact_id"),
+		CallbackID:        r.FormValue("callback_id"),
 	}
 
-	// Si hay un resultado de reconocimiento de voz, usarlo
+	// Si hay un resultado de reconocimiento de voz, usarlo junto con su confianza
 	if speechResult := r.FormValue("SpeechResult"); speechResult != "" {
 		voiceRequest.SpeechResult = speechResult
+		voiceRequest.Confidence = r.FormValue("Confidence")
 	}
 
 	// Inicializar el contexto
 	ctx := context.Background()
 
 	// Obtener o crear el estado de la conversación
-	conversationState, err := getOrCreateConversationState(ctx, voiceRequest)
+	conversationState, isNewCall, err := getOrCreateConversationState(ctx, voiceRequest)
 	if err != nil {
 		log.Printf("Error al obtener o crear el estado de la conversación: %v", err)
 		respondWithError(w, err)
@@ -110,34 +308,111 @@ func HandleVoiceRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Si es una nueva llamada, responder con un saludo
-	if conversationState.CurrentTurnIndex == 0 {
-		// Generar un saludo inicial
-		twiml := generateWelcomeTwiML()
-		respondWithTwiML(w, twiml)
+	if isNewCall {
+		// Si la detección de contestador síncrona indica una máquina, dejar el mensaje de voz o colgar
+		if isMachineAnswer(voiceRequest.AnsweredBy) {
+			twiml := handleMachineAnswer(conversationState, voiceRequest.AnsweredBy)
+			if err := updateConversationState(ctx, conversationState); err != nil {
+				log.Printf("Error al guardar el resultado de AMD: %v", err)
+			}
+			respondWithTwiML(w, conversationState, twiml)
+			return
+		}
+
+		// Generar un saludo inicial. Las llamadas de campaña usan el saludo de la campaña,
+		// las devoluciones de llamada transfieren directamente al agente con el contexto original
+		// y fuera del horario de atención se usa el saludo de fuera de horario.
+		twiml := generateWelcomeTwiML(conversationState)
+		if conversationState.Callback != nil {
+			twiml = startCallbackHandoff(ctx, conversationState)
+		} else if conversationState.Campaign != nil {
+			twiml = generateResponseTwiML(conversationState, conversationState.Campaign.Greeting)
+		} else if isAfterHours(conversationState) {
+			twiml = generateResponseTwiML(conversationState, conversationState.BusinessHours.AfterHoursGreeting)
+		}
+		applySpeechHints(ctx, conversationState, twiml)
+		respondWithTwiML(w, conversationState, twiml)
 		return
 	}
 
 	// Procesar la entrada del usuario
 	var userInput string
+	var queryInput dialogflowInput
+	var inputMode string
+	var confidence float64
 	if voiceRequest.SpeechResult != "" {
-		// Si hay un resultado de reconocimiento de voz, usarlo
-		userInput = voiceRequest.SpeechResult
+		// Si hay un resultado de reconocimiento de voz, usarlo. En la primera respuesta se detecta el idioma del cliente.
+		detectCallLanguage(conversationState, voiceRequest.SpeechResult)
+
+		// Un RUT dicho en una captura pendiente se valida con su dígito verificador; las demás transcripciones
+		// de baja confianza se confirman con el cliente antes de enviarlas a Dialogflow
+		score, known := speechConfidence(voiceRequest.Confidence)
+		var speechTwiML *models.TwiMLResponse
+		if slotInput, slotTwiML, filled := fillSpokenSlot(conversationState, voiceRequest.SpeechResult); filled {
+			if known {
+				recordSpeechConfidence(conversationState, score)
+			}
+			queryInput, speechTwiML = slotInput, slotTwiML
+			userInput, confidence = slotInput.Transcript, score
+		} else {
+			userInput, confidence, speechTwiML = confirmSpeech(conversationState, voiceRequest.SpeechResult, score, known)
+			queryInput = dialogflowInput{Text: userInput, Transcript: userInput}
+		}
+		if speechTwiML != nil {
+			conversationState.NoInputCount = 0
+			conversationState.LastUpdateTimestamp = time.Now()
+			if err := updateConversationState(ctx, conversationState); err != nil {
+				log.Printf("Error al actualizar el estado de la conversación: %v", err)
+			}
+			respondWithTwiML(w, conversationState, speechTwiML)
+			return
+		}
+		inputMode = "speech"
 	} else if voiceRequest.Digits != "" {
-		// Si hay dígitos, usarlos
-		userInput = fmt.Sprintf("Presionó %s", voiceRequest.Digits)
+		// Las teclas del menú de la política de reintentos se atienden sin consultar a Dialogflow
+		if doc := handleEscalationMenu(ctx, conversationState, voiceRequest.Digits); doc != nil {
+			respondWithDocument(w, doc, conversationFallback(conversationState))
+			return
+		}
+
+		// Los dígitos se envían a Dialogflow como entrada DTMF. Si responden a un menú o a una captura de
+		// dígitos, se validan y se envían también como parámetros tipados.
+		var digitsTwiML *models.TwiMLResponse
+		queryInput, digitsTwiML = handleDigits(conversationState, voiceRequest.Digits)
+		if digitsTwiML != nil {
+			conversationState.NoInputCount = 0
+			conversationState.LastUpdateTimestamp = time.Now()
+			if err := updateConversationState(ctx, conversationState); err != nil {
+				log.Printf("Error al actualizar el estado de la conversación: %v", err)
+			}
+			respondWithTwiML(w, conversationState, digitsTwiML)
+			return
+		}
+
+		// El teclado no tiene incertidumbre de reconocimiento
+		userInput = queryInput.Transcript
+		inputMode = "dtmf"
+		confidence = 1.0
 	} else {
-		// Si no hay entrada, responder con un mensaje de error
-		twiml := generateErrorTwiML("No se detectó ninguna entrada. Por favor, inténtelo de nuevo.")
-		respondWithTwiML(w, twiml)
+		// Si no hay entrada, aplicar la política de reintentos (NO_INPUT_ESCALATION)
+		respondWithDocument(w, handleNoInput(ctx, conversationState), conversationFallback(conversationState))
 		return
 	}
 
+	// El cliente respondió: se reinicia la cuenta de turnos seguidos sin respuesta
+	conversationState.NoInputCount = 0
+	conversationState.EscalationMenu = false
+	conversationState.PendingConfirmation = nil
+	conversationState.DTMFMenu = nil
+	conversationState.DigitCollection = nil
+
 	// Crear una entrada de transcripción para el usuario
 	userTranscriptEntry := models.TranscriptEntry{
 		Speaker:    "user",
 		Text:       userInput,
 		Timestamp:  time.Now(),
-		Confidence: 1.0, // Asumimos confianza máxima para simplificar
+		Confidence: confidence,
+		InputMode:  inputMode,
 	}
 
 	// Generar embedding para la entrada del usuario
@@ -156,7 +431,7 @@ func HandleVoiceRequest(w http.ResponseWriter, r *http.Request) {
 	// Buscar contexto relevante en Vector Search
 	var contextText string
 	if len(userEmbedding) > 0 {
-		matches, err := searchVectorIndex(ctx, userEmbedding, conversationState.TenantID, conversationState.FromNumber)
+		matches, err := searchVectorIndex(ctx, userEmbedding, conversationState.TenantID, customerNumber(conversationState))
 		if err != nil {
 			log.Printf("Error al buscar en el índice vectorial: %v", err)
 			// Continuamos sin contexto adicional
@@ -173,19 +448,29 @@ func HandleVoiceRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Consultar a Dialogflow CX
-	dialogflowResponse, err := queryDialogflow(ctx, conversationState.DialogflowSessionID, userInput, contextText)
+	dialogflowResponse, err := queryDialogflow(ctx, conversationState.DialogflowSessionID, queryInput, contextText, dialogflowLanguage(conversationState), conversationSessionParameters(conversationState))
 	if err != nil {
 		log.Printf("Error al consultar a Dialogflow CX: %v", err)
 		respondWithError(w, err)
 		return
 	}
 
+	conversationState.LastDialogflowResult = dialogflowResponse
+
+	// Dialogflow puede pedir continuar la llamada en otro idioma desde el próximo turno
+	if locale, ok := isLanguageSwitchAction(dialogflowResponse); ok {
+		switchCallLanguage(conversationState, locale)
+	}
+
+	// Dialogflow puede pedir la próxima respuesta por teclado: un menú o la captura de un número
+	startDTMFAction(conversationState, dialogflowResponse)
+
 	// Crear una entrada de transcripción para la IA
 	aiTranscriptEntry := models.TranscriptEntry{
 		Speaker:    "ai",
 		Text:       dialogflowResponse.ResponseText,
 		Timestamp:  time.Now(),
-		Confidence: 1.0, // Asumimos confianza máxima para simplificar
+		Confidence: 1.0, // El texto de la IA no proviene de un reconocimiento de voz
 	}
 
 	// Generar embedding para la respuesta de la IA
@@ -201,15 +486,19 @@ func HandleVoiceRequest(w http.ResponseWriter, r *http.Request) {
 	conversationState.RecentTurns = append(conversationState.RecentTurns, aiTranscriptEntry)
 	conversationState.LastUpdateTimestamp = time.Now()
 
-	// Verificar si hay un payload personalizado para transferir a un agente humano
+	// Verificar si hay un payload personalizado para transferir a un agente humano.
+	// Fuera del horario de atención la transferencia se reemplaza por la alternativa del tenant.
 	var handoffPayload *models.LiveAgentHandoffPayload
+	var afterHoursDoc *twiml.Response
 	if dialogflowResponse.CustomPayload != nil {
-		if action, ok := dialogflowResponse.CustomPayload["action"].(string); ok && action == "LiveAgentHandoff" {
+		if action, ok := dialogflowResponse.CustomPayload["action"].(string); ok && action == "LiveAgentHandoff" && handoffClosed(conversationState) {
+			afterHoursDoc = afterHoursHandoffDocument(ctx, conversationState)
+		} else if ok && action == "LiveAgentHandoff" {
 			// Parsear el payload de handoff
 			handoffPayload = &models.LiveAgentHandoffPayload{
 				Action:         action,
 				TransferNumber: transferPhoneNumber, // Usar el número de transferencia configurado
-				Reason:         "El cliente ha solicitado hablar con un agente humano",
+				Reason:         prompt(conversationState, promptHandoffReasonRequested, nil),
 				PreserveContext: true,
 			}
 
@@ -218,6 +507,11 @@ func HandleVoiceRequest(w http.ResponseWriter, r *http.Request) {
 				handoffPayload.TransferNumber = transferNumber
 			}
 
+			// Si hay una cola en el payload, usarla en lugar de la asociada a la intención
+			if queue, ok := dialogflowResponse.CustomPayload["queue"].(string); ok && queue != "" {
+				handoffPayload.Queue = queue
+			}
+
 			// Si hay una razón en el payload, usarla
 			if reason, ok := dialogflowResponse.CustomPayload["reason"].(string); ok && reason != "" {
 				handoffPayload.Reason = reason
@@ -228,47 +522,116 @@ func HandleVoiceRequest(w http.ResponseWriter, r *http.Request) {
 				handoffPayload.PreserveContext = preserveContext
 			}
 
+			// Números alternativos a los que transferir si el primero no contesta
+			handoffPayload.AlternateNumbers = transferAlternateNumbers
+			if alternates, ok := dialogflowResponse.CustomPayload["alternateNumbers"].([]interface{}); ok {
+				handoffPayload.AlternateNumbers = nil
+				for _, alternate := range alternates {
+					if number, ok := alternate.(string); ok && number != "" {
+						handoffPayload.AlternateNumbers = append(handoffPayload.AlternateNumbers, number)
+					}
+				}
+			}
+
+			// Aplicar la tabla de enrutamiento; si falla, se mantienen los destinos del payload
+			if err := routeHandoff(ctx, conversationState, handoffPayload); err != nil {
+				log.Printf("Error al evaluar la tabla de enrutamiento: %v", err)
+			}
+
 			// Actualizar el estado de la conversación con la información de handoff
-			now := time.Now()
-			conversationState.HandoffOccurred = true
-			conversationState.HandoffReason = handoffPayload.Reason
-			conversationState.HandoffTimestamp = &now
+			beginTransfer(conversationState, handoffPayload)
 		}
 	}
 
-	// Guardar el estado actualizado de la conversación
-	if err := updateConversationState(ctx, conversationState); err != nil {
-		log.Printf("Error al actualizar el estado de la conversación: %v", err)
-		// Continuamos a pesar del error
+	// Si Dialogflow no reconoció la respuesta, aplicar la política de reintentos (NO_MATCH_ESCALATION).
+	// Al final de la política se transfiere al agente como si Dialogflow lo hubiera pedido.
+	var escalationResponse *models.TwiMLResponse
+	handoffText := dialogflowResponse.ResponseText
+	if step := recordNoMatch(conversationState, dialogflowResponse); step != "" && handoffPayload == nil && afterHoursDoc == nil {
+		if step != escalationTransfer {
+			escalationResponse = escalationTwiML(conversationState, step, dialogflowResponse.ResponseText, promptNoMatchRephrase)
+		} else if handoffClosed(conversationState) {
+			afterHoursDoc = afterHoursHandoffDocument(ctx, conversationState)
+		} else {
+			handoffPayload = escalationHandoffPayload(conversationState)
+			if err := routeHandoff(ctx, conversationState, handoffPayload); err != nil {
+				log.Printf("Error al evaluar la tabla de enrutamiento: %v", err)
+			}
+			beginTransfer(conversationState, handoffPayload)
+			handoffText = prompt(conversationState, promptEscalationTransfer, nil)
+		}
 	}
 
-	// Enviar la transcripción completa al servicio de historial (en una goroutine para no bloquear)
-	go func() {
-		if err := sendTranscriptToHistoryService(conversationState, dialogflowResponse); err != nil {
-			log.Printf("Error al enviar la transcripción al servicio de historial: %v", err)
+	// Si se ofreció una devolución de llamada y Dialogflow capturó el horario, programarla
+	callbackScheduled := false
+	callbackFailed := false
+	if isScheduleCallbackAction(dialogflowResponse) {
+		if _, err := scheduleCallback(ctx, conversationState, dialogflowResponse); err != nil {
+			log.Printf("Error al programar la devolución de llamada: %v", err)
+			callbackFailed = true
+		} else {
+			callbackScheduled = true
+			conversationState.CallbackOffered = false
 		}
-	}()
+	}
+
+	// Guardar el estado actualizado junto con los eventos del turno.
+	// El despachador de la cola de salida los publica en el bus de eventos con reintentos.
+	var outboxEvents []*models.OutboxEvent
+	turnEvent, err := newTurnCompletedEvent(conversationState, userTranscriptEntry, aiTranscriptEntry)
+	outboxEvents = appendOutboxEvent(outboxEvents, turnEvent, err)
+	if handoffPayload != nil {
+		handoffEvent, err := newHandoffRequestedEvent(conversationState, handoffPayload)
+		outboxEvents = appendOutboxEvent(outboxEvents, handoffEvent, err)
+		outboxEvents = appendHandoffContextEvent(outboxEvents, conversationState, handoffPayload)
+	}
+	if err := saveConversationStateWithOutbox(ctx, conversationState, outboxEvents...); err != nil {
+		log.Printf("Error al actualizar el estado de la conversación: %v", err)
+		// Continuamos a pesar del error
+	}
 
 	// Generar la respuesta TwiML
 	var twiml *models.TwiMLResponse
-	if handoffPayload != nil {
+	if callbackScheduled {
+		// La devolución quedó programada: despedirse y colgar
+		twiml = generateResponseTwiML(conversationState, dialogflowResponse.ResponseText)
+		twiml.Gather = nil
+		twiml.Hangup = &models.TwiMLHangup{}
+	} else if callbackFailed {
+		twiml = generateResponseTwiML(conversationState, prompt(conversationState, promptCallbackRetry, nil))
+	} else if afterHoursDoc != nil {
+		// La alternativa fuera de horario ya es un documento completo
+		respondWithDocument(w, afterHoursDoc, conversationFallback(conversationState))
+		return
+	} else if handoffPayload != nil {
 		// Si hay un handoff, transferir la llamada
-		twiml = generateHandoffTwiML(handoffPayload, dialogflowResponse.ResponseText)
+		twiml = generateHandoffTwiML(conversationState, handoffText)
+	} else if escalationResponse != nil {
+		twiml = escalationResponse
 	} else {
 		// Si no hay handoff, generar una respuesta normal
-		twiml = generateResponseTwiML(dialogflowResponse.ResponseText)
+		twiml = generateResponseTwiML(conversationState, dialogflowResponse.ResponseText)
 	}
 
+	// Leer el SSML explícito de Dialogflow si la respuesta lo trae
+	applyResponseSSML(twiml.Say, dialogflowResponse)
+
+	// Ajustar el <Gather> al menú o a la captura de dígitos y ayudar al reconocimiento de voz con lo que
+	// se espera escuchar en la página actual de Dialogflow
+	applyDTMFGather(conversationState, twiml)
+	applySpeechHints(ctx, conversationState, twiml)
+
 	// Responder con TwiML
-	respondWithTwiML(w, twiml)
+	respondWithTwiML(w, conversationState, twiml)
 }
 
-// getOrCreateConversationState obtiene o crea el estado de una conversación
-func getOrCreateConversationState(ctx context.Context, voiceRequest *models.VoiceRequest) (*models.ConversationState, error) {
+// getOrCreateConversationState obtiene o crea el estado de una conversación.
+// Indica además si el estado se acaba de crear, es decir, si es la primera solicitud de la llamada.
+func getOrCreateConversationState(ctx context.Context, voiceRequest *models.VoiceRequest) (*models.ConversationState, bool, error) {
 	// Inicializar el cliente de Firestore
 	client, err := firestore.NewClient(ctx, projectID)
 	if err != nil {
-		return nil, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+		return nil, false, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
 	}
 	defer client.Close()
 
@@ -281,9 +644,9 @@ func getOrCreateConversationState(ctx context.Context, voiceRequest *models.Voic
 		// Si el documento existe, convertirlo a ConversationState
 		var state models.ConversationState
 		if err := doc.DataTo(&state); err != nil {
-			return nil, fmt.Errorf("error al convertir el documento a ConversationState: %v", err)
+			return nil, false, fmt.Errorf("error al convertir el documento a ConversationState: %v", err)
 		}
-		return &state, nil
+		return &state, false, nil
 	}
 
 	// Si el documento no existe, crear uno nuevo
@@ -300,40 +663,81 @@ func getOrCreateConversationState(ctx context.Context, voiceRequest *models.Voic
 		RecentTurns:         []models.TranscriptEntry{},
 		HandoffOccurred:     false,
 	}
+	recordHumanAnswer(state, voiceRequest.AnsweredBy)
 
-	// Guardar el nuevo estado en Firestore
-	if _, err := docRef.Set(ctx, state); err != nil {
-		return nil, fmt.Errorf("error al guardar el estado de la conversación: %v", err)
+	// Si es una llamada saliente de campaña, restaurar el contexto del contacto
+	if voiceRequest.CampaignID != "" {
+		campaignContext, tenantID, err := loadCampaignContext(ctx, voiceRequest.CampaignID, voiceRequest.CampaignContactID)
+		if err != nil {
+			log.Printf("Error al cargar el contexto de la campaña %s: %v", voiceRequest.CampaignID, err)
+		} else {
+			state.Campaign = campaignContext
+			state.TenantID = tenantID
+		}
+	}
+
+	// Si es una devolución de llamada, restaurar el contexto de la llamada original
+	if voiceRequest.CallbackID != "" {
+		callbackContext, tenantID, err := loadCallbackContext(ctx, voiceRequest.CallbackID)
+		if err != nil {
+			log.Printf("Error al cargar el contexto de la devolución de llamada %s: %v", voiceRequest.CallbackID, err)
+		} else {
+			state.Callback = callbackContext
+			state.TenantID = tenantID
+		}
+	}
+
+	// Evaluar el horario de atención del tenant al inicio de la llamada
+	evaluateBusinessHours(ctx, state)
+
+	// Guardar el nuevo estado en Firestore junto con el evento de inicio de llamada
+	startedEvent, err := newCallStartedEvent(state)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := saveConversationStateWithOutbox(ctx, state, startedEvent); err != nil {
+		return nil, false, fmt.Errorf("error al guardar el estado de la conversación: %v", err)
 	}
 
-	return state, nil
+	return state, true, nil
 }
 
-// updateConversationState actualiza el estado de una conversación en Firestore
+// updateConversationState actualiza el estado de una conversación en Firestore sin eventos de salida
 func updateConversationState(ctx context.Context, state *models.ConversationState) error {
+	if err := saveConversationStateWithOutbox(ctx, state); err != nil {
+		return fmt.Errorf("error al actualizar el estado de la conversación: %v", err)
+	}
+	return nil
+}
+
+// getConversationState obtiene el estado de una conversación. Devuelve nil si no existe.
+func getConversationState(ctx context.Context, callSid string) (*models.ConversationState, error) {
 	// Inicializar el cliente de Firestore
 	client, err := firestore.NewClient(ctx, projectID)
 	if err != nil {
-		return fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+		return nil, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
 	}
 	defer client.Close()
 
-	// Obtener la referencia al documento
-	docRef := client.Collection(firestoreCollection).Doc(state.CallSid)
-
-	// Actualizar el documento
-	_, err = docRef.Set(ctx, state)
+	doc, err := client.Collection(firestoreCollection).Doc(callSid).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("error al actualizar el estado de la conversación: %v", err)
+		return nil, fmt.Errorf("error al obtener el estado de la conversación: %v", err)
 	}
 
-	return nil
+	var state models.ConversationState
+	if err := doc.DataTo(&state); err != nil {
+		return nil, fmt.Errorf("error al convertir el documento a ConversationState: %v", err)
+	}
+	return &state, nil
 }
 
-// queryDialogflow consulta a Dialogflow CX
-func queryDialogflow(ctx context.Context, sessionID, query, contextText string) (*models.DialogflowQueryResult, error) {
-	// Inicializar el cliente de Dialogflow CX
-	client, err := dialogflow.NewSessionsService(ctx, option.WithEndpoint(fmt.Sprintf("%s-dialogflow.googleapis.com:443", dialogflowLocation)))
+// queryDialogflow consulta a Dialogflow CX en el idioma languageCode. sessionParams se agrega a los parámetros de la sesión.
+func queryDialogflow(ctx context.Context, sessionID string, input dialogflowInput, contextText, languageCode string, sessionParams map[string]interface{}) (*models.DialogflowQueryResult, error) {
+	// Inicializar el cliente REST de Dialogflow CX en el endpoint regional del agente
+	service, err := dialogflow.NewService(ctx, option.WithEndpoint(fmt.Sprintf("https://%s-dialogflow.googleapis.com/", dialogflowLocation)))
 	if err != nil {
 		return nil, fmt.Errorf("error al crear el cliente de Dialogflow CX: %v", err)
 	}
@@ -341,45 +745,65 @@ func queryDialogflow(ctx context.Context, sessionID, query, contextText string)
 	// Construir la ruta de la sesión
 	sessionPath := fmt.Sprintf("projects/%s/locations/%s/agents/%s/sessions/%s", projectID, dialogflowLocation, dialogflowAgentID, sessionID)
 
-	// Construir la consulta
-	queryInput := &dialogflow.QueryInput{
-		Text: &dialogflow.TextInput{
-			Text: query,
-		},
-		LanguageCode: dialogflowDefaultLanguage,
+	// Construir la consulta. Los dígitos marcados se envían como entrada DTMF.
+	queryInput := &dialogflow.GoogleCloudDialogflowCxV3QueryInput{
+		LanguageCode: languageCode,
+	}
+	if input.Digits != "" {
+		queryInput.Dtmf = &dialogflow.GoogleCloudDialogflowCxV3DtmfInput{
+			Digits: input.Digits,
+		}
+	} else {
+		queryInput.Text = &dialogflow.GoogleCloudDialogflowCxV3TextInput{
+			Text: input.Text,
+		}
 	}
 
-	// Si hay contexto adicional, agregarlo como parámetros de la consulta
-	var queryParams *dialogflow.QueryParameters
+	// Si hay contexto adicional, parámetros de sesión o parámetros de la entrada, agregarlos como parámetros de la consulta
+	parameters := make(map[string]interface{}, len(sessionParams)+len(input.Parameters)+1)
+	for name, value := range sessionParams {
+		parameters[name] = value
+	}
+	for name, value := range input.Parameters {
+		parameters[name] = value
+	}
 	if contextText != "" {
-		contextStruct, err := structpb.NewStruct(map[string]interface{}{
-			"additional_context": contextText,
-		})
+		parameters["additional_context"] = contextText
+	}
+
+	var queryParams *dialogflow.GoogleCloudDialogflowCxV3QueryParameters
+	if len(parameters) > 0 {
+		parametersJSON, err := json.Marshal(parameters)
 		if err != nil {
-			log.Printf("Error al crear el struct de contexto adicional: %v", err)
+			log.Printf("Error al serializar los parámetros de la consulta: %v", err)
 		} else {
-			queryParams = &dialogflow.QueryParameters{
-				Parameters: contextStruct,
+			queryParams = &dialogflow.GoogleCloudDialogflowCxV3QueryParameters{
+				Parameters: parametersJSON,
 			}
 		}
 	}
 
 	// Realizar la consulta
-	request := &dialogflow.DetectIntentRequest{
-		Session:     sessionPath,
+	request := &dialogflow.GoogleCloudDialogflowCxV3DetectIntentRequest{
 		QueryInput:  queryInput,
 		QueryParams: queryParams,
 	}
 
-	response, err := client.DetectIntent(ctx, request)
+	response, err := service.Projects.Locations.Agents.Sessions.DetectIntent(sessionPath, request).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("error al detectar la intención: %v", err)
 	}
 
-	// Extraer la información relevante de la respuesta
+	// Extraer la información relevante de la respuesta. El texto es el del primer mensaje de texto: los
+	// primeros mensajes pueden ser un payload personalizado o el audio de salida con SSML.
 	queryResult := &models.DialogflowQueryResult{
-		SessionID:    sessionID,
-		ResponseText: response.QueryResult.ResponseMessages[0].Text.Text[0],
+		SessionID: sessionID,
+	}
+	for _, message := range response.QueryResult.ResponseMessages {
+		if message.Text != nil && len(message.Text.Text) > 0 && message.Text.Text[0] != "" {
+			queryResult.ResponseText = message.Text.Text[0]
+			break
+		}
 	}
 
 	// Extraer información adicional si está disponible
@@ -387,26 +811,44 @@ func queryDialogflow(ctx context.Context, sessionID, query, contextText string)
 		queryResult.PageID = response.QueryResult.CurrentPage.Name
 	}
 
-	if response.QueryResult.Match != nil {
-		queryResult.IntentName = response.QueryResult.Match.Intent.DisplayName
-		queryResult.IntentConfidence = response.QueryResult.Match.Confidence
+	if match := response.QueryResult.Match; match != nil {
+		if match.Intent != nil {
+			queryResult.IntentName = match.Intent.DisplayName
+		}
+		queryResult.IntentConfidence = match.Confidence
+		queryResult.MatchType = match.MatchType
 	}
 
-	if response.QueryResult.Parameters != nil {
-		queryResult.Parameters = utils.ProtoStructToMap(response.QueryResult.Parameters.AsStruct())
-	}
+	queryResult.Parameters = dialogflowStruct(response.QueryResult.Parameters)
 
-	// Extraer el payload personalizado si está disponible
+	// Extraer el payload personalizado y el SSML del audio de salida si están disponibles
+	outputAudioSSML := ""
 	for _, message := range response.QueryResult.ResponseMessages {
-		if message.Payload != nil {
-			queryResult.CustomPayload = utils.ProtoStructToMap(message.Payload.AsStruct())
-			break
+		if len(message.Payload) > 0 && queryResult.CustomPayload == nil {
+			queryResult.CustomPayload = dialogflowStruct(message.Payload)
+		}
+		if message.OutputAudioText != nil && message.OutputAudioText.Ssml != "" && outputAudioSSML == "" {
+			outputAudioSSML = message.OutputAudioText.Ssml
 		}
 	}
+	setResponseSSML(queryResult, outputAudioSSML)
 
 	return queryResult, nil
 }
 
+// dialogflowStruct convierte un objeto JSON de la respuesta de Dialogflow (parámetros o payload) en un mapa
+func dialogflowStruct(raw googleapi.RawMessage) map[string]interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(raw, &values); err != nil {
+		log.Printf("Error al leer el objeto de la respuesta de Dialogflow: %v", err)
+		return nil
+	}
+	return values
+}
+
 // generateEmbedding genera un embedding para un texto
 func generateEmbedding(ctx context.Context, text string) ([]float64, error) {
 	// Nota: Esta es una implementación conceptual.
@@ -533,141 +975,146 @@ func searchVectorIndex(ctx context.Context, embedding []float64, tenantID, fromN
 	return []models.VectorSearchMatch{}, nil
 }
 
-// sendTranscriptToHistoryService envía la transcripción al servicio de historial
-func sendTranscriptToHistoryService(state *models.ConversationState, dialogflowResult *models.DialogflowQueryResult) error {
-	// Crear el payload
-	now := time.Now()
-	payload := &models.FullTranscriptPayload{
-		CallSid:           state.CallSid,
-		TenantID:          state.TenantID,
-		FromNumber:        state.FromNumber,
-		ToNumber:          state.ToNumber,
-		StartTimestamp:    state.StartTimestamp,
-		TranscriptEntries: state.RecentTurns,
-		DialogflowMetadata: dialogflowResult,
-		HandoffOccurred:   state.HandoffOccurred,
-		HandoffReason:     state.HandoffReason,
-		HandoffTimestamp:  state.HandoffTimestamp,
-		CreatedAt:         now,
-	}
-
-	// Si la llamada ha terminado, calcular la duración
-	if state.HandoffOccurred && state.HandoffTimestamp != nil {
-		endTime := *state.HandoffTimestamp
-		payload.EndTimestamp = &endTime
-		payload.DurationSeconds = int(endTime.Sub(state.StartTimestamp).Seconds())
-	}
-
-	// Serializar el payload
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("error al serializar el payload: %v", err)
-	}
-
-	// Enviar el payload al servicio de historial
-	resp, err := http.Post(
-		fmt.Sprintf("%s/save-transcript", conversationHistoryServiceURL),
-		"application/json",
-		strings.NewReader(string(jsonPayload)),
-	)
-	if err != nil {
-		return fmt.Errorf("error al enviar el payload al servicio de historial: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// Verificar la respuesta
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("error del servicio de historial: %s - %s", resp.Status, string(body))
-	}
-
-	return nil
-}
-
 // generateWelcomeTwiML genera el TwiML para el saludo inicial
-func generateWelcomeTwiML() *models.TwiMLResponse {
+func generateWelcomeTwiML(state *models.ConversationState) *models.TwiMLResponse {
 	return &models.TwiMLResponse{
 		Say: &models.TwiMLSay{
-			Voice:    "Polly.Lupe",
-			Language: ttsLanguageCode,
-			Value:    "Hola, soy KairosIA, su asistente virtual. ¿En qué puedo ayudarle hoy?",
+			Voice:    sayVoice(state),
+			Language: sayLanguage(state),
+			Value:    prompt(state, promptWelcome, nil),
 		},
 		Gather: &models.TwiMLGather{
-			Input:         "speech",
-			Language:      sttLanguageCode,
-			Timeout:       "5",
-			SpeechTimeout: "auto",
+			Input:               "speech",
+			Language:            sttLanguage(state),
+			Timeout:             "5",
+			SpeechTimeout:       "auto",
+			SpeechModel:         sttModel,
+			ActionOnEmptyResult: "true",
 			Say: &models.TwiMLSay{
-				Voice:    "Polly.Lupe",
-				Language: ttsLanguageCode,
-				Value:    "Por favor, dígame en qué puedo ayudarle.",
+				Voice:    sayVoice(state),
+				Language: sayLanguage(state),
+				Value:    prompt(state, promptWelcomeReprompt, nil),
 			},
 		},
 	}
 }
 
 // generateResponseTwiML genera el TwiML para una respuesta normal
-func generateResponseTwiML(responseText string) *models.TwiMLResponse {
+func generateResponseTwiML(state *models.ConversationState, responseText string) *models.TwiMLResponse {
 	return &models.TwiMLResponse{
 		Say: &models.TwiMLSay{
-			Voice:    "Polly.Lupe",
-			Language: ttsLanguageCode,
+			Voice:    sayVoice(state),
+			Language: sayLanguage(state),
 			Value:    responseText,
 		},
 		Gather: &models.TwiMLGather{
-			Input:         "speech",
-			Language:      sttLanguageCode,
-			Timeout:       "5",
-			SpeechTimeout: "auto",
+			Input:               "speech",
+			Language:            sttLanguage(state),
+			Timeout:             "5",
+			SpeechTimeout:       "auto",
+			SpeechModel:         sttModel,
+			ActionOnEmptyResult: "true",
 		},
 	}
 }
 
 // generateHandoffTwiML genera el TwiML para transferir a un agente humano
-func generateHandoffTwiML(handoffPayload *models.LiveAgentHandoffPayload, responseText string) *models.TwiMLResponse {
-	return &models.TwiMLResponse{
+func generateHandoffTwiML(state *models.ConversationState, responseText string) *models.TwiMLResponse {
+	twiml := &models.TwiMLResponse{
 		Say: &models.TwiMLSay{
-			Voice:    "Polly.Lupe",
-			Language: ttsLanguageCode,
-			Value:    responseText + " Le transferiré con un agente humano. Por favor, espere un momento.",
-		},
-		Dial: &models.TwiMLDial{
-			CallerId: "{{From}}",
-			Number:   handoffPayload.TransferNumber,
+			Voice:    sayVoice(state),
+			Language: sayLanguage(state),
+			Value:    responseText + " " + prompt(state, promptHandoff, nil),
 		},
 	}
+
+	return addTransferVerb(twiml, state)
+}
+
+// generateTransferDial genera el <Dial> hacia el agente actual de la transferencia. Si el agente no contesta
+// dentro del umbral, HandleDialResult intenta el siguiente número o aplica la alternativa configurada.
+func generateTransferDial(state *models.ConversationState) *models.TwiMLDial {
+	dial := &models.TwiMLDial{
+		Action:   voiceWebhookURL(dialResultPath, nil),
+		Method:   "POST",
+		Timeout:  strconv.Itoa(callbackOfferAfter),
+		CallerId: "{{From}}",
+	}
+
+	// En una transferencia cálida el agente escucha el resumen de la conversación antes de conectarse
+	whisperURL, whisperMethod := "", ""
+	if warmTransferEnabled && state.HandoffPreserveContext {
+		whisperURL = voiceWebhookURL(agentWhisperPath, url.Values{"call_sid": {state.CallSid}})
+		whisperMethod = "POST"
+	}
+
+	generateAgentNoun(dial, state, whisperURL, whisperMethod)
+	return dial
+}
+
+// generateGatherTwiML genera una respuesta que continúa la conversación desde un webhook distinto de HandleVoiceRequest
+func generateGatherTwiML(state *models.ConversationState, responseText string) *models.TwiMLResponse {
+	twiml := generateResponseTwiML(state, responseText)
+	twiml.Gather.Action = voiceWebhookURL("", nil)
+	twiml.Gather.Method = "POST"
+	return twiml
+}
+
+// conversationDocument convierte en documento una respuesta que sigue la conversación, con el <Gather>
+// ajustado al menú o a la captura de dígitos y las pistas de reconocimiento de la página actual
+func conversationDocument(ctx context.Context, state *models.ConversationState, response *models.TwiMLResponse) *twiml.Response {
+	applyDTMFGather(state, response)
+	applySpeechHints(ctx, state, response)
+	return modelDocument(response)
 }
 
 // generateErrorTwiML genera el TwiML para un mensaje de error
-func generateErrorTwiML(errorMessage string) *models.TwiMLResponse {
+func generateErrorTwiML(state *models.ConversationState, errorMessage string) *models.TwiMLResponse {
 	return &models.TwiMLResponse{
 		Say: &models.TwiMLSay{
-			Voice:    "Polly.Lupe",
-			Language: ttsLanguageCode,
+			Voice:    sayVoice(state),
+			Language: sayLanguage(state),
 			Value:    errorMessage,
 		},
 		Gather: &models.TwiMLGather{
-			Input:         "speech",
-			Language:      sttLanguageCode,
-			Timeout:       "5",
-			SpeechTimeout: "auto",
+			Input:               "speech",
+			Language:            sttLanguage(state),
+			Timeout:             "5",
+			SpeechTimeout:       "auto",
+			SpeechModel:         sttModel,
+			ActionOnEmptyResult: "true",
 		},
 	}
 }
 
-// respondWithTwiML responde con TwiML
-func respondWithTwiML(w http.ResponseWriter, twiml *models.TwiMLResponse) {
-	// Serializar el TwiML
-	xmlData, err := xml.MarshalIndent(twiml, "", "  ")
+// renderTwiML serializa el TwiML con la declaración XML. Se usa para el TwiML que se envía por la API de Twilio;
+// en modo enforce un TwiML inválido se reemplaza por fallback.
+func renderTwiML(response *models.TwiMLResponse, fallback *twiml.Response) (string, error) {
+	return renderValidatedTwiML(modelDocument(response), fallback)
+}
+
+// respondWithTwiML responde con el TwiML de un turno de la conversación con el cliente
+func respondWithTwiML(w http.ResponseWriter, state *models.ConversationState, response *models.TwiMLResponse) {
+	respondWithDocument(w, modelDocument(response), conversationFallback(state))
+}
+
+// modelDocument convierte una respuesta de orden fijo en un documento TwiML, con el texto de los <Say> en SSML
+func modelDocument(response *models.TwiMLResponse) *twiml.Response {
+	addSpeechSSML(response)
+	return twiml.FromModel(response)
+}
+
+// respondWithDocument responde con un documento TwiML, que admite cualquier cantidad y orden de verbos.
+// El documento se valida antes de responder y, si es inválido, se responde fallback; ver renderValidatedTwiML.
+func respondWithDocument(w http.ResponseWriter, doc, fallback *twiml.Response) {
+	// Serializar y validar el TwiML
+	xmlString, err := renderValidatedTwiML(doc, fallback)
 	if err != nil {
 		log.Printf("Error al serializar el TwiML: %v", err)
 		http.Error(w, "Error interno del servidor", http.StatusInternalServerError)
 		return
 	}
 
-	// Agregar la declaración XML
-	xmlString := xml.Header + string(xmlData)
-
 	// Establecer las cabeceras
 	w.Header().Set("Content-Type", "application/xml")
 	w.Header().Set("Content-Length", strconv.Itoa(len(xmlString)))
@@ -681,17 +1128,61 @@ func respondWithTwiML(w http.ResponseWriter, twiml *models.TwiMLResponse) {
 // respondWithError responde con un mensaje de error
 func respondWithError(w http.ResponseWriter, err error) {
 	log.Printf("Error: %v", err)
-	twiml := generateErrorTwiML("Lo siento, ha ocurrido un error. Por favor, inténtelo de nuevo más tarde.")
-	respondWithTwiML(w, twiml)
+	twiml := generateErrorTwiML(nil, prompt(nil, promptError, nil))
+	respondWithTwiML(w, nil, twiml)
+}
+
+// voiceWebhookURL construye la URL pública de un webhook del servicio de voz para Twilio
+func voiceWebhookURL(path string, query url.Values) string {
+	webhookURL := strings.TrimRight(voiceServiceURL, "/") + path
+	if len(query) > 0 {
+		webhookURL += "?" + query.Encode()
+	}
+	return webhookURL
+}
+
+// conversationSessionParameters reúne los parámetros de sesión de Dialogflow de la campaña, de la devolución de llamada
+// y del horario de atención, junto con el idioma de la llamada
+func conversationSessionParameters(state *models.ConversationState) map[string]interface{} {
+	params := map[string]interface{}{
+		"language_code": dialogflowLanguage(state),
+	}
+	for name, value := range campaignSessionParameters(state) {
+		params[name] = value
+	}
+	for name, value := range callbackSessionParameters(state) {
+		params[name] = value
+	}
+	for name, value := range businessHoursSessionParameters(state) {
+		params[name] = value
+	}
+	return params
 }
 
 func main() {
+	// Subcomando para revisar las traducciones del catálogo de mensajes
+	if len(os.Args) > 1 && os.Args[1] == "check-prompts" {
+		if err := checkPrompts(); err != nil {
+			log.Fatalf("Error al revisar el catálogo de mensajes: %v", err)
+		}
+		return
+	}
+
 	// Obtener el puerto del entorno o usar 8080 por defecto
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
+	// Iniciar el despachador de la cola de salida
+	go runOutboxDispatcher()
+
+	// Iniciar el despachador de campañas salientes
+	go runCampaignDispatcher()
+
+	// Iniciar el despachador de devoluciones de llamada
+	go runCallbackDispatcher()
+
 	// Iniciar el servidor HTTP
 	log.Printf("Iniciando servidor en el puerto %s", port)
 	log.Fatal(http.ListenAndServe(":"+port, nil))
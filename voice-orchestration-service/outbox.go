@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+
+	"kairosia/internal/auth"
+	"kairosia/internal/events"
+	"kairosia/internal/models"
+	"kairosia/internal/utils"
+)
+
+const (
+	outboxStatusPending   = "pending"
+	outboxStatusDelivered = "delivered"
+	outboxStatusFailed    = "failed"
+)
+
+var (
+	// Contadores del proceso para las métricas de la cola de salida
+	outboxDeliveredTotal    int64
+	outboxFailedAttempts    int64
+	outboxDeadLetteredTotal int64
+
+	historyClientMu sync.Mutex
+	historyClient   *http.Client
+
+	publisherMu    sync.Mutex
+	eventPublisher events.Publisher
+)
+
+// newOutboxEvent crea un evento de salida pendiente serializando sus datos.
+// El ID del evento es la clave de idempotencia, por lo que reenviarlo no duplica datos.
+func newOutboxEvent(id string, eventType events.EventType, state *models.ConversationState, data interface{}) (*models.OutboxEvent, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar los datos del evento %s: %v", eventType, err)
+	}
+
+	now := time.Now()
+	return &models.OutboxEvent{
+		ID:            id,
+		CallSid:       state.CallSid,
+		TenantID:      state.TenantID,
+		EventType:     string(eventType),
+		Data:          raw,
+		Status:        outboxStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}, nil
+}
+
+// saveConversationStateWithOutbox guarda el estado de la conversación y sus eventos de salida en una sola transacción.
+// Si la llamada ya terminó, se conservan call_status y end_timestamp registrados por recordCallEnded.
+func saveConversationStateWithOutbox(ctx context.Context, state *models.ConversationState, outboxEvents ...*models.OutboxEvent) error {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	stateRef := client.Collection(firestoreCollection).Doc(state.CallSid)
+
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := preserveCallEnd(tx, stateRef, state); err != nil {
+			return err
+		}
+		if err := tx.Set(stateRef, state); err != nil {
+			return err
+		}
+		for _, event := range outboxEvents {
+			if err := tx.Set(client.Collection(outboxCollection).Doc(event.ID), event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error al guardar el estado de la conversación y los eventos de salida: %v", err)
+	}
+
+	return nil
+}
+
+// runOutboxDispatcher entrega periódicamente los eventos pendientes de la cola de salida
+func runOutboxDispatcher() {
+	ticker := time.NewTicker(outboxDispatchInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := dispatchPendingOutboxEvents(context.Background()); err != nil {
+			log.Printf("Error al despachar la cola de salida: %v", err)
+		}
+	}
+}
+
+// DispatchOutbox despacha los eventos pendientes bajo demanda.
+// En Cloud Run la CPU se limita fuera de las solicitudes, por lo que Cloud Scheduler invoca este endpoint.
+func DispatchOutbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	delivered, err := dispatchPendingOutboxEvents(r.Context())
+	if err != nil {
+		log.Printf("Error al despachar la cola de salida: %v", err)
+		http.Error(w, "Error al despachar la cola de salida", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"delivered": delivered})
+}
+
+// OutboxMetrics expone el número de eventos pendientes y fallidos junto con los contadores del proceso
+func OutboxMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics, err := getOutboxMetrics(r.Context())
+	if err != nil {
+		log.Printf("Error al obtener las métricas de la cola de salida: %v", err)
+		http.Error(w, "Error al obtener las métricas de la cola de salida", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// dispatchPendingOutboxEvents entrega un lote de eventos cuyo próximo intento ya venció
+func dispatchPendingOutboxEvents(ctx context.Context) (int, error) {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return 0, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	docs, err := client.Collection(outboxCollection).
+		Where("status", "==", outboxStatusPending).
+		Where("next_attempt_at", "<=", time.Now()).
+		OrderBy("next_attempt_at", firestore.Asc).
+		Limit(outboxBatchSize).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("error al consultar los eventos pendientes: %v", err)
+	}
+
+	delivered := 0
+	for _, doc := range docs {
+		event, err := claimOutboxEvent(ctx, client, doc.Ref)
+		if err != nil {
+			log.Printf("Error al reservar el evento %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if event == nil {
+			// Otra instancia ya reservó o entregó el evento
+			continue
+		}
+
+		deliveryErr := deliverOutboxEvent(ctx, event)
+		if err := recordOutboxAttempt(ctx, doc.Ref, event, deliveryErr); err != nil {
+			log.Printf("Error al registrar el intento de entrega del evento %s: %v", event.ID, err)
+		}
+		if deliveryErr == nil {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+// claimOutboxEvent reserva un evento moviendo su próximo intento hacia adelante, de modo que
+// otras instancias del despachador no lo entreguen a la vez. Devuelve nil si ya no está disponible.
+func claimOutboxEvent(ctx context.Context, client *firestore.Client, ref *firestore.DocumentRef) (*models.OutboxEvent, error) {
+	var claimed *models.OutboxEvent
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = nil
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+
+		var event models.OutboxEvent
+		if err := doc.DataTo(&event); err != nil {
+			return err
+		}
+		now := time.Now()
+		if event.Status != outboxStatusPending || event.NextAttemptAt.After(now) {
+			return nil
+		}
+
+		event.NextAttemptAt = now.Add(2 * outboxRequestTimeout)
+		claimed = &event
+		return tx.Update(ref, []firestore.Update{{Path: "next_attempt_at", Value: event.NextAttemptAt}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// recordOutboxAttempt actualiza el evento tras un intento de entrega
+func recordOutboxAttempt(ctx context.Context, ref *firestore.DocumentRef, event *models.OutboxEvent, deliveryErr error) error {
+	event.Attempts++
+	now := time.Now()
+
+	if deliveryErr == nil {
+		atomic.AddInt64(&outboxDeliveredTotal, 1)
+		event.Status = outboxStatusDelivered
+		event.DeliveredAt = &now
+		event.LastError = ""
+	} else {
+		atomic.AddInt64(&outboxFailedAttempts, 1)
+		event.LastError = deliveryErr.Error()
+		if event.Attempts >= outboxMaxAttempts {
+			// Se agotaron los intentos: el evento queda marcado como fallido para revisión manual
+			atomic.AddInt64(&outboxDeadLetteredTotal, 1)
+			event.Status = outboxStatusFailed
+			log.Printf("El evento %s se marcó como fallido tras %d intentos: %v", event.ID, event.Attempts, deliveryErr)
+		} else {
+			event.NextAttemptAt = now.Add(utils.BackoffDelay(event.Attempts, outboxRetryBaseDelay, outboxRetryMaxDelay))
+			log.Printf("Error al entregar el evento %s (intento %d): %v", event.ID, event.Attempts, deliveryErr)
+		}
+	}
+
+	_, err := ref.Set(ctx, event)
+	return err
+}
+
+// deliverOutboxEvent publica un evento de la cola de salida en el bus de eventos,
+// o lo envía al webhook del escritorio del agente si ese es su destino
+func deliverOutboxEvent(ctx context.Context, outboxEvent *models.OutboxEvent) error {
+	if outboxEvent.Destination == outboxDestinationAgentDesktop {
+		return deliverAgentDesktopWebhook(ctx, outboxEvent)
+	}
+
+	publisher, err := getEventPublisher(ctx)
+	if err != nil {
+		return err
+	}
+
+	event := &events.Event{
+		ID:         outboxEvent.ID,
+		Type:       events.EventType(outboxEvent.EventType),
+		CallSid:    outboxEvent.CallSid,
+		TenantID:   outboxEvent.TenantID,
+		OccurredAt: outboxEvent.CreatedAt,
+		Data:       outboxEvent.Data,
+	}
+
+	publishCtx, cancel := context.WithTimeout(ctx, outboxRequestTimeout)
+	defer cancel()
+	return publisher.Publish(publishCtx, event)
+}
+
+// getEventPublisher obtiene el publicador de eventos configurado, creándolo la primera vez.
+// En modo local los eventos se reenvían al servicio de historial con el formato push de Pub/Sub.
+func getEventPublisher(ctx context.Context) (events.Publisher, error) {
+	publisherMu.Lock()
+	defer publisherMu.Unlock()
+
+	if eventPublisher != nil {
+		return eventPublisher, nil
+	}
+
+	switch eventBus {
+	case "pubsub":
+		publisher, err := events.NewPubSubPublisher(context.Background(), projectID, pubsubTopic)
+		if err != nil {
+			return nil, err
+		}
+		eventPublisher = publisher
+	case "local":
+		client, err := getHistoryServiceClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		bus := events.NewLocalBus()
+		bus.Subscribe(events.SubjectPrefix+">", events.PushForwarder(client, fmt.Sprintf("%s/conversation-events", conversationHistoryServiceURL), "local"))
+		eventPublisher = bus
+	default:
+		return nil, fmt.Errorf("bus de eventos no soportado: %s", eventBus)
+	}
+
+	return eventPublisher, nil
+}
+
+// getHistoryServiceClient obtiene el cliente HTTP para el servicio de historial en modo local.
+// El cliente adjunta automáticamente las credenciales del modo de autenticación configurado.
+func getHistoryServiceClient(ctx context.Context) (*http.Client, error) {
+	historyClientMu.Lock()
+	defer historyClientMu.Unlock()
+
+	if historyClient != nil {
+		return historyClient, nil
+	}
+
+	client, err := auth.NewHTTPClient(ctx, serviceAuthConfig, outboxRequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente del servicio de historial: %v", err)
+	}
+	historyClient = client
+	return historyClient, nil
+}
+
+// getOutboxMetrics cuenta los eventos pendientes y fallidos en Firestore
+func getOutboxMetrics(ctx context.Context) (*models.OutboxMetrics, error) {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	pending, err := countOutboxEvents(ctx, client, outboxStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	failed, err := countOutboxEvents(ctx, client, outboxStatusFailed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.OutboxMetrics{
+		Pending:           pending,
+		Failed:            failed,
+		DeliveredTotal:    atomic.LoadInt64(&outboxDeliveredTotal),
+		FailedAttempts:    atomic.LoadInt64(&outboxFailedAttempts),
+		DeadLetteredTotal: atomic.LoadInt64(&outboxDeadLetteredTotal),
+	}, nil
+}
+
+// countOutboxEvents cuenta los eventos con un estado dado mediante una consulta de agregación
+func countOutboxEvents(ctx context.Context, client *firestore.Client, status string) (int64, error) {
+	query := client.Collection(outboxCollection).Where("status", "==", status)
+	result, err := query.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error al contar los eventos con estado %s: %v", status, err)
+	}
+
+	count, ok := result["count"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("resultado de conteo inesperado para el estado %s", status)
+	}
+	return count.GetIntegerValue(), nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"kairosia/internal/models"
+	"kairosia/internal/prompts"
+)
+
+// IDs de los mensajes del catálogo que usa el servicio
+const (
+	promptWelcome            = "welcome"
+	promptWelcomeReprompt    = "welcome_reprompt"
+	promptAfterHoursGreeting = "after_hours_greeting"
+	promptCampaignGreeting   = "campaign_greeting"
+	promptCallbackGreeting   = "callback_greeting"
+	promptCallbackOffer      = "callback_offer"
+	promptCallbackRetry      = "callback_retry"
+	promptCallbackVoicemail  = "callback_voicemail"
+	promptAgentsUnavailable  = "agents_unavailable"
+	promptNoInput            = "no_input"
+	promptError              = "error"
+	promptHandoff            = "handoff"
+	promptTransferAlternate  = "transfer_alternate"
+	promptVoicemailOffer     = "voicemail_offer"
+	promptVoicemailThanks    = "voicemail_thanks"
+	promptVoicemailEmpty     = "voicemail_empty"
+	promptTwiMLFallback      = "twiml_fallback"
+	promptQueueThanks        = "queue_thanks"
+	promptQueuePosition      = "queue_position"
+	promptQueueWaitMinute    = "queue_wait_minute"
+	promptQueueWaitMinutes   = "queue_wait_minutes"
+	promptQueueAgentSoon     = "queue_agent_soon"
+	promptNoInputRephrase    = "no_input_rephrase"
+	promptNoMatchRephrase    = "no_match_rephrase"
+	promptEscalationMenu     = "escalation_menu"
+	promptEscalationTransfer = "escalation_transfer"
+	promptEscalationHangup   = "escalation_hangup"
+	promptConfirmSpeech      = "confirm_speech"
+	promptConfirmRetry       = "confirm_retry"
+	promptMenuInvalid        = "menu_invalid"
+	promptDigitsInvalid      = "digits_invalid"
+	promptRUTInvalid         = "rut_invalid"
+
+	// Partes del resumen de la conversación que escucha el agente antes de conectarse
+	promptHandoffSummaryIntro  = "handoff_summary_intro"
+	promptHandoffSummaryReason = "handoff_summary_reason"
+	promptHandoffSummaryIntent = "handoff_summary_intent"
+	promptHandoffSummaryParams = "handoff_summary_params"
+	promptHandoffSummaryText   = "handoff_summary_text"
+
+	// Motivos de las transferencias que no indica Dialogflow; el agente los escucha en el resumen
+	promptHandoffReasonRequested  = "handoff_reason_requested"
+	promptHandoffReasonEscalation = "handoff_reason_escalation"
+
+	// Nombres de las teclas del teléfono en los mensajes de captura de dígitos
+	promptKeyStar  = "key_star"
+	promptKeyPound = "key_pound"
+)
+
+// promptIDs son todos los mensajes que usa el servicio; check-prompts revisa que cada idioma los traduzca
+var promptIDs = []string{
+	promptWelcome,
+	promptWelcomeReprompt,
+	promptAfterHoursGreeting,
+	promptCampaignGreeting,
+	promptCallbackGreeting,
+	promptCallbackOffer,
+	promptCallbackRetry,
+	promptCallbackVoicemail,
+	promptAgentsUnavailable,
+	promptNoInput,
+	promptError,
+	promptHandoff,
+	promptHandoffSummaryIntro,
+	promptHandoffSummaryReason,
+	promptHandoffSummaryIntent,
+	promptHandoffSummaryParams,
+	promptHandoffSummaryText,
+	promptHandoffReasonRequested,
+	promptHandoffReasonEscalation,
+	promptTransferAlternate,
+	promptVoicemailOffer,
+	promptVoicemailThanks,
+	promptVoicemailEmpty,
+	promptTwiMLFallback,
+	promptQueueThanks,
+	promptQueuePosition,
+	promptQueueWaitMinute,
+	promptQueueWaitMinutes,
+	promptQueueAgentSoon,
+	promptNoInputRephrase,
+	promptNoMatchRephrase,
+	promptEscalationMenu,
+	promptEscalationTransfer,
+	promptEscalationHangup,
+	promptConfirmSpeech,
+	promptConfirmRetry,
+	promptMenuInvalid,
+	promptDigitsInvalid,
+	promptRUTInvalid,
+	promptKeyStar,
+	promptKeyPound,
+}
+
+// promptStore entrega los mensajes de los tenants; se crea en init con PROMPTS_DIR
+var promptStore *prompts.Store
+
+// prompt devuelve un mensaje del catálogo en el tenant y el idioma de la llamada. Sin estado se usan el
+// tenant default y el idioma predeterminado.
+func prompt(state *models.ConversationState, id string, vars map[string]string) string {
+	tenantID := prompts.DefaultTenant
+	if state != nil && state.TenantID != "" {
+		tenantID = state.TenantID
+	}
+	return tenantPrompt(tenantID, promptLocale(state), id, vars)
+}
+
+// tenantPrompt devuelve un mensaje del catálogo de un tenant en un idioma. Si el mensaje no existe en
+// ningún idioma de respaldo se registra el error y se devuelve vacío.
+func tenantPrompt(tenantID, locale, id string, vars map[string]string) string {
+	text, err := promptStore.Text(tenantID, locale, id, vars)
+	if err != nil {
+		log.Printf("Error al obtener el mensaje %s del tenant %s: %v", id, tenantID, err)
+		return ""
+	}
+	return text
+}
+
+// promptLocale devuelve el idioma de los mensajes de la llamada: el idioma al que se cambió la llamada o el predeterminado
+func promptLocale(state *models.ConversationState) string {
+	if state != nil && state.LanguageCode != "" {
+		return state.LanguageCode
+	}
+	return promptDefaultLocale
+}
+
+// checkPrompts revisa los catálogos del tenant default y de cada tenant de PROMPTS_DIR y muestra los
+// problemas de traducción. Devuelve error si falta una traducción o sus variables no coinciden, para usarlo en CI.
+func checkPrompts() error {
+	tenants, err := promptStore.Tenants()
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+	for _, tenantID := range tenants {
+		catalog, err := promptStore.Catalog(tenantID)
+		if err != nil {
+			return err
+		}
+		for _, issue := range catalog.Check(promptDefaultLocale, promptIDs) {
+			fmt.Printf("%s: %s\n", tenantID, issue)
+			if issue.Kind != prompts.IssueUnknown {
+				failures++
+			}
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("hay %d traducciones faltantes o con variables distintas", failures)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+
+	"kairosia/internal/models"
+	"kairosia/internal/twiml"
+	"kairosia/internal/utils"
+)
+
+const (
+	// handoffModeQueue deja al cliente en una cola de Twilio hasta que un agente lo atienda
+	handoffModeQueue = "queue"
+
+	// queueWaitPath es la ruta del waitUrl de la cola: música de espera y anuncios
+	queueWaitPath = "/queue-wait"
+	// queueResultPath es la ruta del action del <Enqueue>, que recibe cómo salió el cliente de la cola
+	queueResultPath = "/queue-result"
+
+	// queueWaitFallbackPause son los segundos de silencio que responde el waitUrl si el anuncio es inválido
+	queueWaitFallbackPause = 10
+)
+
+// dequeueRequest es el cuerpo de la API con la que un agente atiende al primero de una cola
+type dequeueRequest struct {
+	Queue       string `json:"queue"`
+	AgentNumber string `json:"agent_number"`
+}
+
+// parseQueueRoutes convierte "intent=cola,intent=cola" en el mapa de colas por intención
+func parseQueueRoutes(s string) map[string]string {
+	routes, invalid := utils.ParseMap(s)
+	for _, item := range invalid {
+		log.Printf("Ruta de cola inválida, se ignora: %s", item)
+	}
+	return routes
+}
+
+// queueForHandoff elige la cola de la transferencia: la indicada en el payload,
+// la asociada a la intención detectada o la cola predeterminada
+func queueForHandoff(state *models.ConversationState, handoffPayload *models.LiveAgentHandoffPayload) string {
+	if handoffPayload.Queue != "" {
+		return handoffPayload.Queue
+	}
+	if result := state.LastDialogflowResult; result != nil {
+		if queue, ok := queueRoutes[result.IntentName]; ok {
+			return queue
+		}
+	}
+	return queueDefault
+}
+
+// generateEnqueueTwiML genera el <Enqueue> que deja al cliente en la cola de la transferencia
+func generateEnqueueTwiML(state *models.ConversationState) *models.TwiMLEnqueue {
+	return &models.TwiMLEnqueue{
+		Action:        voiceWebhookURL(queueResultPath, nil),
+		Method:        "POST",
+		WaitURL:       voiceWebhookURL(queueWaitPath, nil),
+		WaitURLMethod: "POST",
+		Name:          state.QueueName,
+	}
+}
+
+// HandleQueueWait devuelve lo que escucha el cliente mientras espera en la cola: su posición,
+// el tiempo estimado y la música de espera. Twilio lo vuelve a solicitar al terminar la música,
+// por lo que el anuncio se repite en cada vuelta.
+func HandleQueueWait(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	// Si se superó la espera máxima, el cliente sale de la cola y se aplica HANDOFF_FALLBACK
+	if queueMaxWait > 0 && utils.Atoi(r.FormValue("QueueTime"), 0) >= queueMaxWait {
+		respondWithDocument(w, twiml.New(&twiml.Leave{}), twiml.New(&twiml.Leave{}))
+		return
+	}
+
+	// El anuncio usa los mensajes del tenant de la llamada; sin estado se usan los predeterminados
+	state, err := getConversationState(r.Context(), r.FormValue("CallSid"))
+	if err != nil {
+		log.Printf("Error al obtener el estado de la conversación: %v", err)
+	}
+
+	// Si el anuncio es inválido, el cliente sigue en la cola en silencio: Twilio vuelve a pedir el waitUrl tras la pausa
+	respondWithDocument(w, queueWaitDocument(state, utils.Atoi(r.FormValue("QueuePosition"), 0), utils.Atoi(r.FormValue("AvgQueueTime"), 0)), twiml.New().Pause(queueWaitFallbackPause))
+}
+
+// queueWaitDocument anuncia la posición en la cola y, tras una pausa, el tiempo estimado de espera
+// antes de la música de espera
+func queueWaitDocument(state *models.ConversationState, position, avgQueueTime int) *twiml.Response {
+	greeting := []string{prompt(state, promptQueueThanks, nil)}
+	if position > 0 {
+		greeting = append(greeting, prompt(state, promptQueuePosition, map[string]string{"position": strconv.Itoa(position)}))
+	}
+
+	var wait []string
+	if avgQueueTime > 0 {
+		minutes := (avgQueueTime + 59) / 60
+		if minutes == 1 {
+			wait = append(wait, prompt(state, promptQueueWaitMinute, nil))
+		} else {
+			wait = append(wait, prompt(state, promptQueueWaitMinutes, map[string]string{"minutes": strconv.Itoa(minutes)}))
+		}
+	}
+	wait = append(wait, prompt(state, promptQueueAgentSoon, nil))
+
+	return twiml.New(speechSay(state, strings.Join(greeting, " "))).
+		Pause(1).
+		Add(speechSay(state, strings.Join(wait, " "))).
+		Play(queueHoldMusicURL)
+}
+
+// HandleQueueResult recibe cómo salió el cliente de la cola y registra el resultado de la transferencia
+func HandleQueueResult(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	callSid := r.FormValue("CallSid")
+	queueResult := r.FormValue("QueueResult")
+
+	ctx := r.Context()
+	state, err := getConversationState(ctx, callSid)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if state == nil {
+		respondWithDocument(w, twiml.New().Hangup(), twiml.New().Hangup())
+		return
+	}
+
+	state.QueueResult = queueResult
+	state.QueueTimeSeconds = utils.Atoi(r.FormValue("QueueTime"), 0)
+
+	var doc *twiml.Response
+	switch queueResult {
+	case "bridged":
+		// El agente atendió y la conversación con el agente ya terminó
+		state.TransferOutcome = transferOutcomeConnected
+		doc = twiml.New().Hangup()
+	case "hangup", "redirected":
+		// El cliente colgó mientras esperaba o la llamada ya sigue otro TwiML
+		doc = twiml.New()
+	case "leave", "queue-full", "error", "system-error":
+		log.Printf("El cliente %s salió de la cola %s sin ser atendido (%s)", callSid, state.QueueName, queueResult)
+		if len(state.PendingTransferNumbers) > 0 {
+			doc = modelDocument(advanceTransfer(state))
+		} else {
+			doc = transferFallbackDocument(ctx, state)
+		}
+	default:
+		doc = transferFallbackDocument(ctx, state)
+	}
+
+	if err := updateConversationState(ctx, state); err != nil {
+		log.Printf("Error al actualizar el estado de la conversación: %v", err)
+	}
+
+	respondWithDocument(w, doc, conversationFallback(state))
+}
+
+// DequeueCall conecta a un agente con el primer cliente de una cola: llama al agente y, al contestar,
+// escucha el resumen de la conversación (si la transferencia lo preserva) antes de conectarse
+func DequeueCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req dequeueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Cuerpo de la solicitud inválido", http.StatusBadRequest)
+		return
+	}
+	if req.Queue == "" || req.AgentNumber == "" {
+		http.Error(w, "Faltan queue o agent_number", http.StatusBadRequest)
+		return
+	}
+
+	queue := &models.TwiMLQueue{Name: req.Queue}
+	if warmTransferEnabled {
+		// Twilio solicita la url con el CallSid del cliente que sale de la cola
+		queue.URL = voiceWebhookURL(agentWhisperPath, nil)
+		queue.Method = "POST"
+	}
+	xmlString, err := renderTwiML(&models.TwiMLResponse{Dial: &models.TwiMLDial{Queue: queue}}, twiml.New().Hangup())
+	if err != nil {
+		log.Printf("Error al serializar el TwiML: %v", err)
+		http.Error(w, "Error interno del servidor", http.StatusInternalServerError)
+		return
+	}
+
+	params := &twilioApi.CreateCallParams{}
+	params.SetTo(normalizeAgentDestination(req.AgentNumber))
+	params.SetFrom(twilioPhoneNumber)
+	params.SetTwiml(xmlString)
+
+	call, err := getTwilioClient().Api.CreateCall(params)
+	if err != nil {
+		log.Printf("Error al llamar al agente %s para la cola %s: %v", req.AgentNumber, req.Queue, err)
+		http.Error(w, "Error al llamar al agente", http.StatusBadGateway)
+		return
+	}
+
+	agentCallSid := ""
+	if call.Sid != nil {
+		agentCallSid = *call.Sid
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"agent_call_sid": agentCallSid,
+		"queue":          req.Queue,
+	})
+}
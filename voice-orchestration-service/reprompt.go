@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"kairosia/internal/models"
+	"kairosia/internal/twiml"
+	"kairosia/internal/utils"
+)
+
+const (
+	// Pasos de la política de reintentos (NO_INPUT_ESCALATION y NO_MATCH_ESCALATION). El paso de cada
+	// intento fallido seguido es el de su posición en la lista; desde el último, se repite el último.
+	escalationReprompt = "reprompt"
+	escalationRephrase = "rephrase"
+	escalationDTMF     = "dtmf"
+	escalationTransfer = "transfer"
+	escalationHangup   = "hangup"
+
+	// Teclas del menú DTMF de la política de reintentos
+	escalationMenuRetryDigit = "1"
+	escalationMenuAgentDigit = "0"
+
+	// dialogflowNoMatch es el tipo de coincidencia de Dialogflow CX cuando no reconoce la respuesta del cliente
+	dialogflowNoMatch = "NO_MATCH"
+)
+
+// parseEscalation lee una política de reintentos: una lista de pasos separados por comas
+func parseEscalation(value string) ([]string, error) {
+	steps := utils.ParseList(value)
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("la política no tiene pasos")
+	}
+	for _, step := range steps {
+		switch step {
+		case escalationReprompt, escalationRephrase, escalationDTMF, escalationTransfer, escalationHangup:
+		default:
+			return nil, fmt.Errorf("paso desconocido: %s", step)
+		}
+	}
+	return steps, nil
+}
+
+// escalationStep devuelve el paso de la política para la cantidad de intentos fallidos seguidos
+func escalationStep(steps []string, count int) string {
+	if count <= 0 || len(steps) == 0 {
+		return ""
+	}
+	if count > len(steps) {
+		count = len(steps)
+	}
+	return steps[count-1]
+}
+
+// handleNoInput responde cuando el <Gather> terminó sin voz ni dígitos, según NO_INPUT_ESCALATION
+func handleNoInput(ctx context.Context, state *models.ConversationState) *twiml.Response {
+	state.NoInputCount++
+	state.NoInputTotal++
+	state.LastUpdateTimestamp = time.Now()
+
+	step := escalationStep(noInputEscalation, state.NoInputCount)
+	log.Printf("Sin respuesta del cliente en la llamada %s (%d seguidas): %s", state.CallSid, state.NoInputCount, step)
+	if step == escalationTransfer {
+		return startEscalationHandoff(ctx, state)
+	}
+
+	response := escalationTwiML(state, step, prompt(state, promptNoInput, nil), promptNoInputRephrase)
+	if err := updateConversationState(ctx, state); err != nil {
+		log.Printf("Error al actualizar el estado de la conversación: %v", err)
+	}
+	return conversationDocument(ctx, state, response)
+}
+
+// recordNoMatch cuenta las respuestas seguidas que Dialogflow no reconoció y devuelve el paso de
+// NO_MATCH_ESCALATION, o vacío si Dialogflow reconoció la respuesta
+func recordNoMatch(state *models.ConversationState, response *models.DialogflowQueryResult) string {
+	if response.MatchType != dialogflowNoMatch {
+		state.NoMatchCount = 0
+		return ""
+	}
+	state.NoMatchCount++
+	state.NoMatchTotal++
+
+	step := escalationStep(noMatchEscalation, state.NoMatchCount)
+	log.Printf("Dialogflow no reconoció la respuesta en la llamada %s (%d seguidas): %s", state.CallSid, state.NoMatchCount, step)
+	return step
+}
+
+// escalationTwiML genera la respuesta de un paso que no transfiere la llamada. reprompt repite text,
+// rephrase usa el mensaje rephraseID del catálogo, dtmf ofrece el menú por teclado y hangup se despide.
+func escalationTwiML(state *models.ConversationState, step, text, rephraseID string) *models.TwiMLResponse {
+	state.EscalationMenu = false
+	switch step {
+	case escalationRephrase:
+		return generateResponseTwiML(state, prompt(state, rephraseID, nil))
+	case escalationDTMF:
+		state.EscalationMenu = true
+		return generateEscalationMenuTwiML(state)
+	case escalationHangup:
+		return &models.TwiMLResponse{
+			Say: &models.TwiMLSay{
+				Voice:    sayVoice(state),
+				Language: sayLanguage(state),
+				Value:    prompt(state, promptEscalationHangup, nil),
+			},
+			Hangup: &models.TwiMLHangup{},
+		}
+	default:
+		return generateResponseTwiML(state, text)
+	}
+}
+
+// generateEscalationMenuTwiML genera el menú por teclado de la política de reintentos. El cliente aún puede
+// responder por voz.
+func generateEscalationMenuTwiML(state *models.ConversationState) *models.TwiMLResponse {
+	twiml := generateResponseTwiML(state, prompt(state, promptEscalationMenu, nil))
+	twiml.Gather.Input = "dtmf speech"
+	twiml.Gather.NumDigits = "1"
+	return twiml
+}
+
+// handleEscalationMenu atiende una tecla del menú por teclado de la política de reintentos. Devuelve nil si
+// la llamada no estaba en el menú o la tecla no es del menú, para que la entrada siga a Dialogflow.
+func handleEscalationMenu(ctx context.Context, state *models.ConversationState, digits string) *twiml.Response {
+	if !state.EscalationMenu {
+		return nil
+	}
+	state.EscalationMenu = false
+	state.NoInputCount = 0
+
+	switch digits {
+	case escalationMenuAgentDigit:
+		state.NoMatchCount = 0
+		return startEscalationHandoff(ctx, state)
+	case escalationMenuRetryDigit:
+		state.NoMatchCount = 0
+		state.LastUpdateTimestamp = time.Now()
+		if err := updateConversationState(ctx, state); err != nil {
+			log.Printf("Error al actualizar el estado de la conversación: %v", err)
+		}
+		return modelDocument(generateResponseTwiML(state, prompt(state, promptWelcomeReprompt, nil)))
+	}
+	return nil
+}
+
+// startEscalationHandoff transfiere al agente al final de la política de reintentos. Fuera del horario de
+// atención se aplica la alternativa del tenant.
+func startEscalationHandoff(ctx context.Context, state *models.ConversationState) *twiml.Response {
+	if handoffClosed(state) {
+		doc := afterHoursHandoffDocument(ctx, state)
+		if err := updateConversationState(ctx, state); err != nil {
+			log.Printf("Error al actualizar el estado de la conversación: %v", err)
+		}
+		return doc
+	}
+	return modelDocument(startHandoff(ctx, state, escalationHandoffPayload(state), prompt(state, promptEscalationTransfer, nil)))
+}
+
+// escalationHandoffPayload devuelve la transferencia al agente de la política de reintentos
+func escalationHandoffPayload(state *models.ConversationState) *models.LiveAgentHandoffPayload {
+	return &models.LiveAgentHandoffPayload{
+		Action:           "LiveAgentHandoff",
+		TransferNumber:   transferPhoneNumber,
+		Reason:           prompt(state, promptHandoffReasonEscalation, nil),
+		PreserveContext:  true,
+		AlternateNumbers: transferAlternateNumbers,
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"kairosia/internal/models"
+)
+
+const (
+	// routingAnyTenant es el tenant de las reglas que aplican a todos los tenants
+	routingAnyTenant = "*"
+
+	// Prefijos de los destinos de la tabla de enrutamiento que no son números de teléfono
+	sipDestinationPrefix    = "sip:"
+	clientDestinationPrefix = "client:"
+	queueDestinationPrefix  = "queue:"
+)
+
+// routingAttributes son los datos de la llamada con los que se evalúan las reglas de enrutamiento
+type routingAttributes struct {
+	TenantID     string
+	Intent       string
+	Language     string
+	CustomerTier string
+	AfterHours   bool
+	Now          time.Time
+}
+
+// routeHandoff evalúa la tabla de enrutamiento al detectar una transferencia y, si alguna regla coincide,
+// reemplaza los destinos del payload por los de la regla. Sin reglas coincidentes se mantienen
+// los destinos del payload de Dialogflow o de TRANSFER_PHONE_NUMBER.
+func routeHandoff(ctx context.Context, state *models.ConversationState, handoffPayload *models.LiveAgentHandoffPayload) error {
+	rules, err := loadRoutingRules(ctx, state.TenantID)
+	if err != nil {
+		return err
+	}
+
+	rule := matchRoutingRule(rules, handoffRoutingAttributes(state))
+	if rule == nil {
+		return nil
+	}
+
+	state.RoutingRuleID = rule.ID
+	handoffPayload.TransferNumber = ""
+	handoffPayload.Queue = ""
+	if queue, ok := strings.CutPrefix(rule.Destinations[0], queueDestinationPrefix); ok {
+		handoffPayload.Queue = queue
+	} else {
+		handoffPayload.TransferNumber = rule.Destinations[0]
+	}
+	handoffPayload.AlternateNumbers = append([]string{}, rule.Destinations[1:]...)
+	return nil
+}
+
+// handoffRoutingAttributes reúne el tenant, la intención, el idioma y el segmento del cliente de la llamada
+func handoffRoutingAttributes(state *models.ConversationState) routingAttributes {
+	attributes := routingAttributes{
+		TenantID:   state.TenantID,
+		Language:   sttLanguage(state),
+		AfterHours: isAfterHours(state),
+		Now:        clock(),
+	}
+
+	if result := state.LastDialogflowResult; result != nil {
+		attributes.Intent = result.IntentName
+		if tier, ok := result.Parameters["customer_tier"].(string); ok {
+			attributes.CustomerTier = tier
+		}
+	}
+	if attributes.CustomerTier == "" && state.Campaign != nil {
+		attributes.CustomerTier = state.Campaign.Variables["customer_tier"]
+	}
+
+	return attributes
+}
+
+// loadRoutingRules obtiene las reglas del tenant y las que aplican a todos los tenants
+func loadRoutingRules(ctx context.Context, tenantID string) ([]models.RoutingRule, error) {
+	// Inicializar el cliente de Firestore
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("error al crear el cliente de Firestore: %v", err)
+	}
+	defer client.Close()
+
+	docs, err := client.Collection(routingCollection).
+		Where("tenant_id", "in", []string{tenantID, routingAnyTenant}).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error al consultar la tabla de enrutamiento: %v", err)
+	}
+
+	rules := make([]models.RoutingRule, 0, len(docs))
+	for _, doc := range docs {
+		var rule models.RoutingRule
+		if err := doc.DataTo(&rule); err != nil {
+			return nil, fmt.Errorf("error al parsear la regla de enrutamiento %s: %v", doc.Ref.ID, err)
+		}
+		if rule.ID == "" {
+			rule.ID = doc.Ref.ID
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// matchRoutingRule devuelve la regla de mayor prioridad que coincide con la llamada.
+// A igual prioridad, las reglas del tenant se evalúan antes que las comunes a todos los tenants.
+func matchRoutingRule(rules []models.RoutingRule, attributes routingAttributes) *models.RoutingRule {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		return rules[i].TenantID != routingAnyTenant && rules[j].TenantID == routingAnyTenant
+	})
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Disabled || len(rule.Destinations) == 0 {
+			continue
+		}
+		if !matchesRoutingValue(rule.Intents, attributes.Intent) ||
+			!matchesRoutingValue(rule.Languages, attributes.Language) ||
+			!matchesRoutingValue(rule.CustomerTiers, attributes.CustomerTier) {
+			continue
+		}
+		if !matchesBusinessHours(rule.BusinessHours, attributes.AfterHours) {
+			continue
+		}
+		if rule.Hours != nil && !withinCallingHours(*rule.Hours, attributes.Now) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// matchesRoutingValue indica si un valor está entre los aceptados por la regla; una lista vacía acepta cualquiera
+func matchesRoutingValue(accepted []string, value string) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+	for _, candidate := range accepted {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesBusinessHours indica si la regla aplica según el horario de atención ("open" o "closed"); vacío aplica siempre
+func matchesBusinessHours(businessHours string, afterHours bool) bool {
+	switch businessHours {
+	case routingBusinessHoursOpen:
+		return !afterHours
+	case routingBusinessHoursClosed:
+		return afterHours
+	default:
+		return true
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"kairosia/internal/models"
+)
+
+// Cabeceras con el contexto de la conversación que recibe la central SIP o el cliente del agente,
+// para mostrar la ficha del cliente al contestar
+const (
+	sipHeaderCallSid = "X-Call-Sid"
+	sipHeaderIntent  = "X-Intent"
+	sipHeaderTenant  = "X-Tenant"
+)
+
+// transferContextHeaders devuelve las cabeceras con el contexto de la transferencia, en orden y sin las vacías
+func transferContextHeaders(state *models.ConversationState) []models.TwiMLParameter {
+	intent := ""
+	if state.LastDialogflowResult != nil {
+		intent = state.LastDialogflowResult.IntentName
+	}
+
+	var headers []models.TwiMLParameter
+	for _, header := range []models.TwiMLParameter{
+		{Name: sipHeaderCallSid, Value: state.CallSid},
+		{Name: sipHeaderIntent, Value: intent},
+		{Name: sipHeaderTenant, Value: state.TenantID},
+	} {
+		if header.Value != "" {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+// sipURIWithHeaders agrega las cabeceras a la URI SIP como parámetros, que Twilio envía como cabeceras del INVITE
+func sipURIWithHeaders(uri string, headers []models.TwiMLParameter) string {
+	if len(headers) == 0 {
+		return uri
+	}
+	query := make([]string, len(headers))
+	for i, header := range headers {
+		query[i] = header.Name + "=" + url.QueryEscape(header.Value)
+	}
+	separator := "?"
+	if strings.Contains(uri, "?") {
+		separator = "&"
+	}
+	return uri + separator + strings.Join(query, "&")
+}
+
+// generateAgentNoun completa el <Dial> con el destino actual de la transferencia: una URI SIP de la central,
+// un agente de Twilio Voice SDK ("client:<identidad>") o un número de teléfono
+func generateAgentNoun(dial *models.TwiMLDial, state *models.ConversationState, whisperURL, whisperMethod string) {
+	destination := state.CurrentTransferNumber
+	switch {
+	case strings.HasPrefix(destination, sipDestinationPrefix):
+		dial.Sip = &models.TwiMLSip{
+			URL:      whisperURL,
+			Method:   whisperMethod,
+			Username: transferSipUsername,
+			Password: transferSipPassword,
+			URI:      sipURIWithHeaders(destination, transferContextHeaders(state)),
+		}
+	case strings.HasPrefix(destination, clientDestinationPrefix):
+		dial.Client = &models.TwiMLClient{
+			URL:        whisperURL,
+			Method:     whisperMethod,
+			Identity:   strings.TrimPrefix(destination, clientDestinationPrefix),
+			Parameters: transferContextHeaders(state),
+		}
+	default:
+		dial.Number = &models.TwiMLNumber{Value: destination, URL: whisperURL, Method: whisperMethod}
+	}
+}
+
+// agentCallAddress devuelve el destino de la llamada saliente al agente (modo conferencia) con el contexto
+// de la transferencia: las URIs SIP y los clientes lo reciben como parámetros del destino
+func agentCallAddress(state *models.ConversationState) string {
+	destination := state.CurrentTransferNumber
+	if strings.HasPrefix(destination, sipDestinationPrefix) || strings.HasPrefix(destination, clientDestinationPrefix) {
+		return sipURIWithHeaders(destination, transferContextHeaders(state))
+	}
+	return destination
+}
+
+// normalizeAgentDestination normaliza el número de un agente; las URIs SIP y los clientes se usan tal cual
+func normalizeAgentDestination(destination string) string {
+	if strings.HasPrefix(destination, sipDestinationPrefix) || strings.HasPrefix(destination, clientDestinationPrefix) {
+		return destination
+	}
+	return normalizePhoneNumber(destination)
+}
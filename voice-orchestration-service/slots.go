@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+
+	"kairosia/internal/models"
+	"kairosia/internal/rut"
+	"kairosia/internal/speech"
+)
+
+// fillSpokenSlot completa por voz una captura de RUT pendiente: el cliente puede decir el RUT en vez de
+// marcarlo ("doce millones trescientos ... guion K"). El RUT se valida con su dígito verificador, por lo que
+// no se confirma la transcripción. Devuelve false si no hay una captura de RUT pendiente o la respuesta no es
+// un RUT ("no lo tengo a mano"); en ese caso la respuesta sigue a Dialogflow como texto.
+func fillSpokenSlot(state *models.ConversationState, speechResult string) (dialogflowInput, *models.TwiMLResponse, bool) {
+	collection := state.DigitCollection
+	if collection == nil || collection.Type != digitsTypeRUT {
+		return dialogflowInput{}, nil, false
+	}
+
+	parsed, err := rut.ParseSpoken(speechResult)
+	if err != nil {
+		// El error incluye la transcripción, que puede tener parte del RUT
+		log.Printf("La respuesta de la llamada %s no es un RUT, se envía a Dialogflow como texto", state.CallSid)
+		return dialogflowInput{}, nil, false
+	}
+
+	input := dialogflowInput{Text: parsed.String(), Transcript: rut.Mask(parsed.String())}
+	return input, finishCollection(state, &input, parsed.String(), parsed.Valid()), true
+}
+
+// slotSpeechHints devuelve los hints de la captura pendiente: para un RUT, la secuencia de dígitos y las
+// palabras del dígito verificador
+func slotSpeechHints(state *models.ConversationState) []speech.Hint {
+	if state.DigitCollection == nil || state.DigitCollection.Type != digitsTypeRUT {
+		return nil
+	}
+	return []speech.Hint{
+		{Phrase: "$OOV_CLASS_DIGIT_SEQUENCE"},
+		{Phrase: "guion", Boost: speechHintBoost},
+		{Phrase: "K", Boost: speechHintBoost},
+		{Phrase: "millones", Boost: speechHintBoost},
+	}
+}
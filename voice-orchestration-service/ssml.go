@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"kairosia/internal/models"
+	"kairosia/internal/ssml"
+	"kairosia/internal/twiml"
+)
+
+// ssmlPayloadKey es la clave del payload personalizado de Dialogflow con el SSML explícito de la respuesta
+const ssmlPayloadKey = "ssml"
+
+// setResponseSSML guarda el SSML explícito de una respuesta de Dialogflow: el del payload personalizado o, si no
+// hay, el del audio de salida configurado en la página. Si la respuesta no tiene texto, el texto es el del SSML,
+// que es el que queda en la transcripción.
+func setResponseSSML(queryResult *models.DialogflowQueryResult, outputAudioSSML string) {
+	queryResult.ResponseSSML = outputAudioSSML
+	if markup, ok := queryResult.CustomPayload[ssmlPayloadKey].(string); ok && strings.TrimSpace(markup) != "" {
+		queryResult.ResponseSSML = markup
+	}
+	if queryResult.ResponseSSML == "" || strings.TrimSpace(queryResult.ResponseText) != "" {
+		return
+	}
+	text, err := ssml.PlainText(queryResult.ResponseSSML)
+	if err != nil {
+		log.Printf("Error al leer el SSML de Dialogflow: %v", err)
+		return
+	}
+	queryResult.ResponseText = text
+}
+
+// applyResponseSSML usa en el <Say> el SSML explícito de Dialogflow en lugar de convertir su texto.
+// El SSML se sanea antes de usarlo; el texto que el servicio agrega después de la respuesta (por ejemplo
+// el aviso de transferencia) se convierte como cualquier otro texto. Si el SSML es inválido se lee el texto.
+func applyResponseSSML(say *models.TwiMLSay, response *models.DialogflowQueryResult) {
+	if !ssmlEnabled || say == nil || response.ResponseSSML == "" || !strings.HasPrefix(say.Value, response.ResponseText) {
+		return
+	}
+
+	nodes, err := ssml.Parse(response.ResponseSSML)
+	if err != nil {
+		log.Printf("Error al leer el SSML de Dialogflow, se usará el texto: %v", err)
+		return
+	}
+	if suffix := say.Value[len(response.ResponseText):]; suffix != "" {
+		nodes = append(nodes, ssml.FromText(suffix)...)
+	}
+
+	markup, err := ssml.Render(nodes)
+	if err != nil {
+		log.Printf("Error al serializar el SSML de Dialogflow, se usará el texto: %v", err)
+		return
+	}
+	say.SSML = markup
+}
+
+// addSpeechSSML convierte en SSML el texto de los <Say> de la respuesta que no tienen SSML, para que los
+// montos, fechas, teléfonos y códigos se lean correctamente
+func addSpeechSSML(response *models.TwiMLResponse) {
+	if !ssmlEnabled || response == nil {
+		return
+	}
+	addSaySSML(response.Say)
+	if response.Gather != nil {
+		addSaySSML(response.Gather.Say)
+	}
+}
+
+// addSaySSML convierte en SSML el texto de un <Say>. Si no se puede serializar, el <Say> lee el texto.
+func addSaySSML(say *models.TwiMLSay) {
+	if say == nil || say.SSML != "" || strings.TrimSpace(say.Value) == "" {
+		return
+	}
+	markup, err := ssml.Render(ssml.FromText(say.Value))
+	if err != nil {
+		log.Printf("Error al convertir el texto a SSML, se usará el texto: %v", err)
+		return
+	}
+	say.SSML = markup
+}
+
+// speechSay crea un <Say> de un documento TwiML con la voz y el idioma de la llamada. Como en addSaySSML,
+// el texto se lee como SSML si está habilitado.
+func speechSay(state *models.ConversationState, text string) *twiml.Say {
+	if !ssmlEnabled {
+		return twiml.SayText(sayVoice(state), sayLanguage(state), text)
+	}
+	return &twiml.Say{Voice: sayVoice(state), Language: sayLanguage(state), Nodes: ssml.FromText(text)}
+}
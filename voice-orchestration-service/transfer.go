@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kairosia/internal/models"
+	"kairosia/internal/twiml"
+	"kairosia/internal/utils"
+)
+
+const (
+	// dialResultPath es la ruta del action del <Dial> de la transferencia a un agente
+	dialResultPath = "/dial-result"
+	// voicemailRecordingPath es la ruta del action del <Record> del buzón de voz
+	voicemailRecordingPath = "/voicemail-recording"
+
+	// Alternativas cuando ningún agente contesta la transferencia (HANDOFF_FALLBACK)
+	handoffFallbackCallback  = "callback"
+	handoffFallbackVoicemail = "voicemail"
+	handoffFallbackResume    = "resume"
+
+	transferOutcomeConnected        = "connected"
+	transferOutcomeCallbackOffered  = "callback_offered"
+	transferOutcomeVoicemailOffered = "voicemail_offered"
+	transferOutcomeVoicemail        = "voicemail"
+	transferOutcomeResumed          = "resumed"
+)
+
+// beginTransfer registra en el estado el inicio de una transferencia a un agente
+func beginTransfer(state *models.ConversationState, handoffPayload *models.LiveAgentHandoffPayload) {
+	now := time.Now()
+	state.HandoffOccurred = true
+	state.HandoffReason = handoffPayload.Reason
+	state.HandoffTimestamp = &now
+	state.LastUpdateTimestamp = now
+	state.PendingTransferNumbers = handoffPayload.AlternateNumbers
+	state.TransferOutcome = ""
+	state.HandoffPreserveContext = handoffPayload.PreserveContext
+	state.HandoffSummary = buildHandoffSummary(state)
+	state.AgentCallSid = ""
+	state.QueueResult = ""
+
+	if handoffPayload.Queue != "" || handoffMode == handoffModeQueue {
+		setTransferDestination(state, queueDestinationPrefix+queueForHandoff(state, handoffPayload))
+	} else {
+		setTransferDestination(state, handoffPayload.TransferNumber)
+	}
+}
+
+// startHandoff transfiere al agente una llamada que no viene de una respuesta de Dialogflow: aplica la tabla
+// de enrutamiento, guarda el estado con los eventos de la transferencia y anuncia el mensaje antes del <Dial>
+func startHandoff(ctx context.Context, state *models.ConversationState, handoffPayload *models.LiveAgentHandoffPayload, message string) *models.TwiMLResponse {
+	if err := routeHandoff(ctx, state, handoffPayload); err != nil {
+		log.Printf("Error al evaluar la tabla de enrutamiento: %v", err)
+	}
+	beginTransfer(state, handoffPayload)
+
+	handoffEvent, err := newHandoffRequestedEvent(state, handoffPayload)
+	outboxEvents := appendOutboxEvent(nil, handoffEvent, err)
+	outboxEvents = appendHandoffContextEvent(outboxEvents, state, handoffPayload)
+	if err := saveConversationStateWithOutbox(ctx, state, outboxEvents...); err != nil {
+		log.Printf("Error al actualizar el estado de la conversación: %v", err)
+	}
+
+	return generateHandoffTwiML(state, message)
+}
+
+// setTransferDestination define el destino actual de la transferencia: un número, una URI SIP o una cola ("queue:<cola>")
+func setTransferDestination(state *models.ConversationState, destination string) {
+	state.CurrentTransferNumber = ""
+	state.QueueName = ""
+	state.ConferenceName = ""
+
+	if queue, ok := strings.CutPrefix(destination, queueDestinationPrefix); ok {
+		state.QueueName = queue
+		return
+	}
+	state.CurrentTransferNumber = destination
+	if handoffMode == handoffModeConference {
+		state.ConferenceName = conferenceName(state.CallSid)
+	}
+}
+
+// addTransferVerb agrega al TwiML el verbo que conecta con el destino actual de la transferencia.
+// En una cola el cliente espera a que un agente lo atienda; en modo conferencia, a que el agente se una.
+func addTransferVerb(twiml *models.TwiMLResponse, state *models.ConversationState) *models.TwiMLResponse {
+	switch {
+	case state.QueueName != "":
+		twiml.Enqueue = generateEnqueueTwiML(state)
+	case state.ConferenceName != "":
+		twiml.Dial = generateConferenceDial(state)
+	default:
+		twiml.Dial = generateTransferDial(state)
+	}
+	return twiml
+}
+
+// advanceTransfer pasa al siguiente destino alternativo y genera el TwiML que lo intenta
+func advanceTransfer(state *models.ConversationState) *models.TwiMLResponse {
+	setTransferDestination(state, state.PendingTransferNumbers[0])
+	state.PendingTransferNumbers = state.PendingTransferNumbers[1:]
+	return addTransferVerb(&models.TwiMLResponse{
+		Say: &models.TwiMLSay{
+			Voice:    sayVoice(state),
+			Language: sayLanguage(state),
+			Value:    prompt(state, promptTransferAlternate, nil),
+		},
+	}, state)
+}
+
+// HandleDialResult recibe el resultado del <Dial> de la transferencia a un agente.
+// Registra el intento y, si nadie contestó, marca el siguiente número alternativo o aplica HANDOFF_FALLBACK.
+func HandleDialResult(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	callSid := r.FormValue("CallSid")
+	dialCallStatus := r.FormValue("DialCallStatus")
+	duration := utils.Atoi(r.FormValue("DialCallDuration"), 0)
+
+	ctx := r.Context()
+	state, err := getConversationState(ctx, callSid)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if state == nil {
+		respondWithDocument(w, twiml.New().Hangup(), twiml.New().Hangup())
+		return
+	}
+
+	now := time.Now()
+	state.TransferAttempts = append(state.TransferAttempts, models.TransferAttempt{
+		Number:          state.CurrentTransferNumber,
+		DialCallSid:     r.FormValue("DialCallSid"),
+		DialCallStatus:  dialCallStatus,
+		DurationSeconds: duration,
+		Timestamp:       now,
+	})
+	state.LastUpdateTimestamp = now
+
+	var doc *twiml.Response
+	switch {
+	case dialCallStatus == "completed" || dialCallStatus == "answered":
+		// El agente contestó y la conversación con el agente ya terminó
+		state.TransferOutcome = transferOutcomeConnected
+		state.TransferDurationSeconds = duration
+		doc = twiml.New().Hangup()
+	case len(state.PendingTransferNumbers) > 0:
+		log.Printf("La transferencia de la llamada %s a %s no fue contestada (%s), intentando otro destino", callSid, state.CurrentTransferNumber, dialCallStatus)
+		doc = modelDocument(advanceTransfer(state))
+	default:
+		log.Printf("La transferencia de la llamada %s no fue contestada (%s)", callSid, dialCallStatus)
+		doc = transferFallbackDocument(ctx, state)
+	}
+
+	if err := updateConversationState(ctx, state); err != nil {
+		log.Printf("Error al actualizar el estado de la conversación: %v", err)
+	}
+
+	respondWithDocument(w, doc, conversationFallback(state))
+}
+
+// transferFallbackDocument aplica la alternativa configurada cuando ningún agente contestó
+func transferFallbackDocument(ctx context.Context, state *models.ConversationState) *twiml.Response {
+	return handoffFallbackDocument(ctx, state, handoffFallback)
+}
+
+// handoffFallbackDocument aplica una alternativa a la transferencia: devolución de llamada, buzón de voz o seguir con la IA
+func handoffFallbackDocument(ctx context.Context, state *models.ConversationState, fallback string) *twiml.Response {
+	// Una devolución de llamada no ofrece otra: se retoma la conversación con la IA
+	if fallback == handoffFallbackCallback && state.Callback != nil {
+		fallback = handoffFallbackResume
+	}
+
+	switch fallback {
+	case handoffFallbackVoicemail:
+		state.TransferOutcome = transferOutcomeVoicemailOffered
+		return voicemailDocument(state)
+	case handoffFallbackResume:
+		state.TransferOutcome = transferOutcomeResumed
+		return conversationDocument(ctx, state, generateGatherTwiML(state, prompt(state, promptAgentsUnavailable, nil)))
+	default:
+		state.TransferOutcome = transferOutcomeCallbackOffered
+		state.CallbackOffered = true
+		return conversationDocument(ctx, state, generateGatherTwiML(state, prompt(state, promptCallbackOffer, nil)))
+	}
+}
+
+// voicemailDocument ofrece el buzón de voz y graba el mensaje. Si el cliente no dejó mensaje, Twilio no llama
+// a la acción del <Record> y sigue con los verbos siguientes: se despide y cuelga.
+func voicemailDocument(state *models.ConversationState) *twiml.Response {
+	return twiml.New(
+		speechSay(state, prompt(state, promptVoicemailOffer, nil)),
+		&twiml.Record{
+			Action:      voiceWebhookURL(voicemailRecordingPath, nil),
+			Method:      "POST",
+			MaxLength:   strconv.Itoa(voicemailMaxLength),
+			PlayBeep:    "true",
+			FinishOnKey: "#",
+		},
+		speechSay(state, prompt(state, promptVoicemailEmpty, nil)),
+	).Hangup()
+}
+
+// HandleVoicemailRecording recibe la grabación del buzón de voz que dejó el cliente
+func HandleVoicemailRecording(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	callSid := r.FormValue("CallSid")
+	recordingURL := r.FormValue("RecordingUrl")
+
+	ctx := r.Context()
+	state, err := getConversationState(ctx, callSid)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if state == nil || recordingURL == "" {
+		respondWithDocument(w, twiml.New().Hangup(), twiml.New().Hangup())
+		return
+	}
+
+	state.VoicemailRecordingURL = recordingURL
+	state.TransferOutcome = transferOutcomeVoicemail
+	state.LastUpdateTimestamp = time.Now()
+	if err := updateConversationState(ctx, state); err != nil {
+		log.Printf("Error al actualizar el estado de la conversación: %v", err)
+	}
+
+	respondWithDocument(w, twiml.New(speechSay(state, prompt(state, promptVoicemailThanks, nil))).Hangup(), twiml.New().Hangup())
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"kairosia/internal/models"
+	"kairosia/internal/twiml"
+)
+
+const (
+	// Modos de TWIML_VALIDATION_MODE: enforce reemplaza el TwiML inválido por la respuesta segura;
+	// log solo registra las infracciones y responde el TwiML generado
+	twimlValidationEnforce = "enforce"
+	twimlValidationLog     = "log"
+
+	// twimlViolationEvent identifica en los logs las infracciones de TwiML; la métrica basada en logs
+	// twiml_violations las cuenta por regla y modo
+	twimlViolationEvent = "twiml_violation"
+)
+
+// twimlViolationEntry es la entrada de log estructurada de una infracción. Cloud Logging lee cada línea JSON
+// como jsonPayload y toma severity y message como la severidad y el mensaje de la entrada.
+type twimlViolationEntry struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Event    string `json:"event"`
+	Rule     string `json:"rule"`
+	Path     string `json:"path"`
+	Mode     string `json:"mode"`
+}
+
+// renderValidatedTwiML serializa un documento TwiML y lo valida. Si es inválido, registra las infracciones y, en
+// modo enforce, serializa en su lugar fallback: la respuesta segura que indica cada llamador según el tramo de la
+// llamada (seguir la conversación con el cliente, colgar, seguir en la cola o no hacer nada).
+func renderValidatedTwiML(doc, fallback *twiml.Response) (string, error) {
+	xmlString, err := doc.Render()
+	if err != nil {
+		return "", err
+	}
+	violations := documentViolations(doc, xmlString)
+	if len(violations) == 0 {
+		return xmlString, nil
+	}
+
+	recordTwiMLViolations(violations)
+	if twimlValidationMode != twimlValidationEnforce {
+		return xmlString, nil
+	}
+	return fallback.Render()
+}
+
+// conversationFallback es la respuesta segura de los turnos de la conversación: pide repetir y sigue con la IA,
+// en el tenant, el idioma y la voz de la llamada
+func conversationFallback(state *models.ConversationState) *twiml.Response {
+	return modelDocument(generateGatherTwiML(state, prompt(state, promptTwiMLFallback, nil)))
+}
+
+// documentViolations valida el documento y el tamaño de su serialización
+func documentViolations(doc *twiml.Response, xmlString string) []twiml.Violation {
+	violations := twiml.Validate(doc)
+	if len(xmlString) > twiml.MaxDocumentSize {
+		violations = append(violations, twiml.Violation{
+			Path:    "Response",
+			Rule:    "length",
+			Message: fmt.Sprintf("el documento de %d bytes supera el máximo de %d", len(xmlString), twiml.MaxDocumentSize),
+		})
+	}
+	return violations
+}
+
+// recordTwiMLViolations registra cada infracción como una entrada de log estructurada
+func recordTwiMLViolations(violations []twiml.Violation) {
+	for _, violation := range violations {
+		line, err := json.Marshal(twimlViolationEntry{
+			Severity: "WARNING",
+			Message:  fmt.Sprintf("TwiML inválido: %s", violation),
+			Event:    twimlViolationEvent,
+			Rule:     violation.Rule,
+			Path:     violation.Path,
+			Mode:     twimlValidationMode,
+		})
+		if err != nil {
+			log.Printf("TwiML inválido: %s", violation)
+			continue
+		}
+		fmt.Fprintln(os.Stderr, string(line))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"kairosia/internal/models"
+	"kairosia/internal/twiml"
+	"kairosia/internal/utils"
+)
+
+const (
+	// agentWhisperPath es la ruta del TwiML que escucha el agente antes de conectarse con el cliente
+	agentWhisperPath = "/agent-whisper"
+
+	// Límites del resumen para que el agente lo escuche en pocos segundos
+	whisperMaxParameters   = 5
+	whisperMaxCustomerText = 200
+)
+
+// internalSessionParameters son los parámetros de sesión que agrega el servicio y que no aportan al agente
+var internalSessionParameters = map[string]bool{
+	"additional_context":    true,
+	"previous_conversation": true,
+	"callback_offered":      true,
+	"callback_id":           true,
+	"original_call_sid":     true,
+	"callback_reason":       true,
+	"campaign_id":           true,
+	"contact_id":            true,
+	"business_hours_open":   true,
+	"handoff_available":     true,
+	"holiday":               true,
+}
+
+// HandleAgentWhisper devuelve el resumen que escucha el agente antes de conectarse (transferencia cálida).
+// Twilio lo solicita en la llamada del agente; el CallSid del cliente llega en el parámetro call_sid,
+// o en CallSid cuando el agente atiende a un cliente de una cola.
+func HandleAgentWhisper(w http.ResponseWriter, r *http.Request) {
+	// Parsear la solicitud de Twilio
+	if err := r.ParseForm(); err != nil {
+		log.Printf("Error al parsear el formulario: %v", err)
+		http.Error(w, "Error al parsear el formulario", http.StatusBadRequest)
+		return
+	}
+
+	callSid := r.FormValue("call_sid")
+	if callSid == "" {
+		callSid = r.FormValue("CallSid")
+	}
+	state, err := getConversationState(r.Context(), callSid)
+	if err != nil {
+		// Sin resumen el agente se conecta igual; no se debe perder la transferencia
+		log.Printf("Error al obtener el estado de la conversación %s para el resumen: %v", callSid, err)
+	}
+
+	summary := ""
+	if state != nil && state.HandoffPreserveContext {
+		summary = state.HandoffSummary
+		if summary == "" {
+			summary = buildHandoffSummary(state)
+		}
+	}
+	// El TwiML es del tramo del agente: si es inválido, el agente se conecta sin el resumen
+	if summary == "" {
+		respondWithDocument(w, twiml.New(), twiml.New())
+		return
+	}
+
+	respondWithDocument(w, twiml.New(speechSay(state, summary)), twiml.New())
+}
+
+// buildHandoffSummary genera un resumen breve de la conversación para el agente:
+// motivo, intención detectada, parámetros capturados y lo último que dijo el cliente
+func buildHandoffSummary(state *models.ConversationState) string {
+	var parts []string
+	if state.HandoffReason != "" {
+		parts = append(parts, prompt(state, promptHandoffSummaryReason, map[string]string{"reason": strings.TrimSuffix(state.HandoffReason, ".")}))
+	}
+
+	if result := state.LastDialogflowResult; result != nil {
+		if result.IntentName != "" {
+			parts = append(parts, prompt(state, promptHandoffSummaryIntent, map[string]string{"intent": strings.ReplaceAll(result.IntentName, "_", " ")}))
+		}
+		if params := summaryParameters(result.Parameters); params != "" {
+			parts = append(parts, prompt(state, promptHandoffSummaryParams, map[string]string{"params": params}))
+		}
+	}
+
+	if text := lastCustomerText(state); text != "" {
+		parts = append(parts, prompt(state, promptHandoffSummaryText, map[string]string{"text": utils.TruncateString(text, whisperMaxCustomerText)}))
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+	return prompt(state, promptHandoffSummaryIntro, nil) + " " + strings.Join(parts, " ")
+}
+
+// summaryParameters formatea los parámetros de Dialogflow capturados, en orden y sin los internos del servicio
+func summaryParameters(params map[string]interface{}) string {
+	names := make([]string, 0, len(params))
+	for name, value := range params {
+		if internalSessionParameters[name] || value == nil || fmt.Sprint(value) == "" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > whisperMaxParameters {
+		names = names[:whisperMaxParameters]
+	}
+
+	items := make([]string, len(names))
+	for i, name := range names {
+		items[i] = fmt.Sprintf("%s %v", strings.ReplaceAll(name, "_", " "), params[name])
+	}
+	return strings.Join(items, ", ")
+}
+
+// lastCustomerText devuelve lo último que dijo el cliente, incluso si fue en la llamada original de una devolución
+func lastCustomerText(state *models.ConversationState) string {
+	turns := state.RecentTurns
+	if state.Callback != nil {
+		turns = append(append([]models.TranscriptEntry{}, state.Callback.PreviousTurns...), turns...)
+	}
+	for i := len(turns) - 1; i >= 0; i-- {
+		if turns[i].Speaker == "user" && turns[i].Text != "" {
+			return turns[i].Text
+		}
+	}
+	return ""
+}